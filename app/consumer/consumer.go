@@ -0,0 +1,168 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/jamesineda/reschedular/app/event"
+	"github.com/jamesineda/reschedular/app/utils"
+	"log"
+	"sync"
+	"time"
+)
+
+// Consumer long-polls an SQS queue for QUESTIONNAIRE_COMPLETED messages and handles each one the same way a Lambda
+// invocation would. A message is only deleted once it has been handled, so anything that fails, or isn't a
+// QUESTIONNAIRE_COMPLETED event, is redelivered by SQS once its visibility timeout runs out
+type Consumer struct {
+	svc    sqsiface.SQSAPI
+	config *utils.ConsumerConfig
+	// handle is swapped out in tests
+	handle func(ctx context.Context, e event.IncomingEvent) error
+}
+
+func NewConsumer(svc sqsiface.SQSAPI, config *utils.ConsumerConfig, deps *event.Dependencies) (*Consumer, error) {
+	if config.QueueUrl == "" {
+		return nil, fmt.Errorf("consumer requires a queue_url")
+	}
+
+	if err := deps.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &Consumer{svc: svc, config: config, handle: func(ctx context.Context, e event.IncomingEvent) error {
+		return e.HandleEvent(ctx, deps)
+	}}, nil
+}
+
+// Run receives messages until the context is cancelled, messages that have been received are handled before it returns.
+// Only receiving is cancelled, messages are handled with a context of their own, otherwise shutting down would roll
+// back the transactions they're part way through
+func (c *Consumer) Run(ctx context.Context) error {
+	for {
+		output, err := c.svc.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			MaxNumberOfMessages:   aws.Int64(c.config.MaxMessages),
+			MessageAttributeNames: aws.StringSlice([]string{sqs.QueueAttributeNameAll}),
+			QueueUrl:              aws.String(c.config.QueueUrl),
+			VisibilityTimeout:     aws.Int64(int64(c.config.VisibilityTimeout.Seconds())),
+			WaitTimeSeconds:       aws.Int64(int64(c.config.WaitTime.Seconds())),
+		})
+
+		if ctx.Err() != nil {
+			log.Println("SQS consumer shutting down")
+			return nil
+		} else if err != nil {
+			log.Printf("failed to receive messages from SQS: %s", err)
+			// don't hammer SQS while it's failing
+			select {
+			case <-time.After(c.config.WaitTime):
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		var wg sync.WaitGroup
+		for _, message := range output.Messages {
+			wg.Add(1)
+			go func(message *sqs.Message) {
+				defer wg.Done()
+				c.process(context.Background(), message)
+			}(message)
+		}
+		wg.Wait()
+	}
+}
+
+// process handles a single message, keeping it hidden from other consumers while it's being handled
+func (c *Consumer) process(ctx context.Context, message *sqs.Message) {
+	e, err := DecodeMessage(message)
+	if err != nil {
+		// left on the queue, so it'll end up in the queue's dead-letter queue if it has one
+		log.Printf("failed to decode SQS message %s: %s", aws.StringValue(message.MessageId), err)
+		return
+	}
+	if e.FunctionName() != event.QuestionnaireCompleted {
+		// it's some other consumer's to handle
+		log.Printf("SQS message %s is a %s event, only %s events are handled", aws.StringValue(message.MessageId),
+			e.FunctionName(), event.QuestionnaireCompleted)
+		return
+	}
+
+	stopHeartbeat := c.startHeartbeat(message)
+	err = c.handle(ctx, e)
+	stopHeartbeat()
+
+	if err != nil && !event.IsHandledOutcome(err) {
+		log.Printf("failed to handle %s event (id: %s), it'll be redelivered: %s", e.FunctionName(), e.EventId(), err)
+		return
+	}
+
+	// deliberately not using ctx, a handled message should still be deleted while shutting down
+	if _, err = c.svc.DeleteMessage(&sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(c.config.QueueUrl),
+		ReceiptHandle: message.ReceiptHandle,
+	}); err != nil {
+		log.Printf("failed to delete SQS message %s, it'll be redelivered: %s", aws.StringValue(message.MessageId), err)
+	}
+}
+
+// startHeartbeat extends the message's visibility timeout every heartbeat interval, until the returned func is called
+func (c *Consumer) startHeartbeat(message *sqs.Message) (stop func()) {
+	if c.config.HeartbeatInterval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(c.config.HeartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := c.svc.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+					QueueUrl:          aws.String(c.config.QueueUrl),
+					ReceiptHandle:     message.ReceiptHandle,
+					VisibilityTimeout: aws.Int64(int64(c.config.VisibilityTimeout.Seconds())),
+				}); err != nil {
+					log.Printf("failed to extend visibility of SQS message %s: %s", aws.StringValue(message.MessageId), err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// DecodeMessage message bodies are either an event envelope, or a bare event. Bare events without a Name are assumed to
+// be QUESTIONNAIRE_COMPLETED
+func DecodeMessage(message *sqs.Message) (event.IncomingEvent, error) {
+	body := []byte(aws.StringValue(message.Body))
+	e, err := event.UnmarshalEnvelope(body)
+	if err == nil {
+		return e, nil
+	} else if err != event.ErrNotAnEnvelope {
+		return nil, err
+	}
+
+	e, err = event.DefaultRegistry.Decode(body)
+	if unknown, ok := err.(*event.UnknownEventError); ok && unknown.Name == "" {
+		completed := &event.QuestionnaireCompletedEvent{}
+		if err = json.Unmarshal(body, completed); err != nil {
+			return nil, err
+		}
+		completed.Name = event.QuestionnaireCompleted
+		return completed, nil
+	}
+	return e, err
+}
@@ -0,0 +1,297 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/jamesineda/reschedular/app/db"
+	"github.com/jamesineda/reschedular/app/event"
+	"github.com/jamesineda/reschedular/app/models"
+	"github.com/jamesineda/reschedular/app/publisher"
+	"github.com/jamesineda/reschedular/app/queue"
+	"github.com/jamesineda/reschedular/app/utils"
+	"github.com/stretchr/testify/suite"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSQS hands out messages on the first receive, and blocks every receive after that until the context is done
+type fakeSQS struct {
+	sqsiface.SQSAPI
+	sync.Mutex
+	messages   []*sqs.Message
+	deleted    []string
+	heartbeats int
+}
+
+func (f *fakeSQS) ReceiveMessageWithContext(ctx aws.Context, _ *sqs.ReceiveMessageInput, _ ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	f.Lock()
+	messages := f.messages
+	f.messages = nil
+	f.Unlock()
+
+	if len(messages) == 0 {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return &sqs.ReceiveMessageOutput{Messages: messages}, nil
+}
+
+func (f *fakeSQS) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.deleted = append(f.deleted, aws.StringValue(input.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (f *fakeSQS) ChangeMessageVisibility(*sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.heartbeats++
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+type ConsumerSuite struct {
+	suite.Suite
+	SQS      *fakeSQS
+	Consumer *Consumer
+}
+
+func (suite *ConsumerSuite) SetupTest() {
+	suite.SQS = &fakeSQS{}
+	consumer, err := NewConsumer(suite.SQS, &utils.ConsumerConfig{
+		QueueUrl:          "https://sqs.example/queue",
+		MaxMessages:       10,
+		WaitTime:          time.Millisecond,
+		VisibilityTimeout: 30 * time.Second,
+		HeartbeatInterval: time.Millisecond,
+	}, newTestDependencies())
+	suite.Require().NoError(err)
+	suite.Consumer = consumer
+}
+
+func newTestDependencies() *event.Dependencies {
+	dbConn, _ := db.NewFakeDatabaseConn(&db.FakeSQLX{})
+	return &event.Dependencies{
+		DB:          dbConn,
+		Timer:       utils.NewFakeTimer(time.Date(2022, 7, 18, 10, 0, 0, 0, time.UTC)),
+		IdGenny:     utils.NewFakeIdGenny("ABC123"),
+		Publisher:   publisher.NewMemoryPublisher(),
+		Queue:       queue.NewEventsQueue(10),
+		Retry:       utils.NewDefaultRetryConfig(),
+		Processor:   utils.NewDefaultProcessorConfig(),
+		Idempotency: event.NewDBIdempotencyStore(dbConn),
+	}
+}
+
+func completedMessage(receiptHandle, eventId string) *sqs.Message {
+	return &sqs.Message{
+		MessageId:     aws.String(eventId),
+		ReceiptHandle: aws.String(receiptHandle),
+		Body:          aws.String(fmt.Sprintf(`{"Name": "QUESTIONNAIRE_COMPLETED", "Id": "%s", "UserId": "PARTICIPANT1"}`, eventId)),
+	}
+}
+
+// failingDB every read fails
+type failingDB struct {
+	db.Client
+	err error
+}
+
+func (f *failingDB) GetById(string, interface{}) (interface{}, error) {
+	return nil, f.err
+}
+
+func (f *failingDB) GetList(interface{}, db.Filters, ...db.ListOption) error {
+	return f.err
+}
+
+// run runs the consumer until every message has been handled, by handle or by the real handler when it's nil
+func (suite *ConsumerSuite) run(handle func(ctx context.Context, e event.IncomingEvent) error, messages ...*sqs.Message) {
+	var handled sync.WaitGroup
+	handled.Add(len(messages))
+	if handle == nil {
+		handle = suite.Consumer.handle
+	}
+	suite.Consumer.handle = func(ctx context.Context, e event.IncomingEvent) error {
+		defer handled.Done()
+		return handle(ctx, e)
+	}
+	suite.SQS.messages = messages
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- suite.Consumer.Run(ctx)
+	}()
+
+	handled.Wait()
+	cancel()
+	suite.NoError(<-done)
+}
+
+func (suite *ConsumerSuite) Test_NewConsumer() {
+	suite.Run("when there's no queue url", func() {
+		_, err := NewConsumer(suite.SQS, &utils.ConsumerConfig{}, newTestDependencies())
+		suite.Error(err)
+	})
+
+	suite.Run("when dependencies are missing", func() {
+		_, err := NewConsumer(suite.SQS, &utils.ConsumerConfig{QueueUrl: "https://sqs.example/queue"}, &event.Dependencies{})
+		suite.Error(err)
+	})
+}
+
+func (suite *ConsumerSuite) Test_Run() {
+	suite.Run("handled messages are deleted", func() {
+		suite.SetupTest()
+		var handled []string
+		var lock sync.Mutex
+		suite.run(func(ctx context.Context, e event.IncomingEvent) error {
+			lock.Lock()
+			defer lock.Unlock()
+			handled = append(handled, e.EventId())
+			return nil
+		}, completedMessage("RECEIPT1", "ABC123"), completedMessage("RECEIPT2", "ABC456"))
+
+		suite.ElementsMatch([]string{"ABC123", "ABC456"}, handled)
+		suite.ElementsMatch([]string{"RECEIPT1", "RECEIPT2"}, suite.SQS.deleted)
+	})
+
+	suite.Run("completions that didn't need a new schedule are still deleted", func() {
+		suite.SetupTest()
+		suite.run(func(ctx context.Context, e event.IncomingEvent) error {
+			return event.ErrMaxAttemptsReached
+		}, completedMessage("RECEIPT1", "ABC123"))
+
+		suite.Equal([]string{"RECEIPT1"}, suite.SQS.deleted)
+	})
+
+	suite.Run("messages that fail are left to be redelivered", func() {
+		suite.SetupTest()
+		suite.run(func(ctx context.Context, e event.IncomingEvent) error {
+			return fmt.Errorf("database is down")
+		}, completedMessage("RECEIPT1", "ABC123"))
+
+		suite.Empty(suite.SQS.deleted)
+	})
+
+	suite.Run("messages the real handler fails on are left to be redelivered, and the consumer keeps running", func() {
+		suite.SetupTest()
+		deps := newTestDependencies()
+		deps.DB = &failingDB{Client: deps.DB, err: fmt.Errorf("database is down")}
+		deps.Idempotency = event.NewDBIdempotencyStore(deps.DB)
+		consumer, err := NewConsumer(suite.SQS, suite.Consumer.config, deps)
+		suite.Require().NoError(err)
+		suite.Consumer = consumer
+
+		suite.run(nil, completedMessage("RECEIPT1", "ABC123"), completedMessage("RECEIPT2", "ABC456"))
+		suite.Empty(suite.SQS.deleted)
+	})
+
+	suite.Run("messages being handled when it's shut down are finished off", func() {
+		suite.SetupTest()
+		deps := newTestDependencies()
+		dbConn, err := db.NewDatabaseConn(&utils.DatabaseConfig{ClientName: "sqlx", Driver: "sqlite3",
+			Dsn: filepath.Join(suite.T().TempDir(), "reschedular.db")})
+		suite.Require().NoError(err)
+		migrator, err := db.NewMigrator(dbConn, deps.Timer)
+		suite.Require().NoError(err)
+		_, err = migrator.Up()
+		suite.Require().NoError(err)
+		suite.Require().NoError(dbConn.CreateAll(
+			&models.Participant{Id: "PARTICIPANT1", Name: "Ann"},
+			&models.Questionnaire{Id: "QUESTIONNAIRE1", StudyId: "STUDY1", Name: "hair regrowth", Questions: "{}"},
+		))
+		deps.DB = dbConn
+		deps.Idempotency = event.NewDBIdempotencyStore(dbConn)
+		consumer, err := NewConsumer(suite.SQS, suite.Consumer.config, deps)
+		suite.Require().NoError(err)
+
+		// shut down as soon as the message has been received, before its transaction has begun
+		ctx, cancel := context.WithCancel(context.Background())
+		handle := consumer.handle
+		consumer.handle = func(handleCtx context.Context, e event.IncomingEvent) error {
+			cancel()
+			return handle(handleCtx, e)
+		}
+		suite.SQS.messages = []*sqs.Message{{
+			MessageId:     aws.String("ABC123"),
+			ReceiptHandle: aws.String("RECEIPT1"),
+			Body: aws.String(`{"Name": "QUESTIONNAIRE_COMPLETED", "Id": "ABC123", "UserId": "PARTICIPANT1", ` +
+				`"QuestionnaireId": "QUESTIONNAIRE1", "CompletedAt": "2022-07-18T09:00:00Z", "RemainingCompletions": 2}`),
+		}}
+
+		suite.Require().NoError(consumer.Run(ctx))
+		suite.Equal([]string{"RECEIPT1"}, suite.SQS.deleted)
+		processed, err := deps.Idempotency.Lookup("ABC123")
+		suite.Require().NoError(err)
+		suite.Require().NotNil(processed)
+		suite.Equal(event.OutcomeAdhoc, processed.Outcome)
+	})
+
+	suite.Run("other event types are left on the queue", func() {
+		suite.SetupTest()
+		handled := false
+		suite.Consumer.handle = func(ctx context.Context, e event.IncomingEvent) error {
+			handled = true
+			return nil
+		}
+
+		suite.Consumer.process(context.Background(), &sqs.Message{
+			MessageId:     aws.String("DEF123"),
+			ReceiptHandle: aws.String("RECEIPT1"),
+			Body:          aws.String(`{"Name": "SCHEDULED_QUESTIONNAIRE", "Id": "DEF123"}`),
+		})
+		suite.False(handled)
+		suite.Empty(suite.SQS.deleted)
+	})
+
+	suite.Run("visibility is extended while a message is being handled", func() {
+		suite.SetupTest()
+		suite.run(func(ctx context.Context, e event.IncomingEvent) error {
+			time.Sleep(20 * time.Millisecond)
+			return nil
+		}, completedMessage("RECEIPT1", "ABC123"))
+
+		suite.Greater(suite.SQS.heartbeats, 0)
+	})
+}
+
+func (suite *ConsumerSuite) Test_DecodeMessage() {
+	suite.Run("a bare QUESTIONNAIRE_COMPLETED event", func() {
+		e, err := DecodeMessage(completedMessage("RECEIPT1", "ABC123"))
+		suite.Require().NoError(err)
+		suite.Equal(&event.QuestionnaireCompletedEvent{Name: event.QuestionnaireCompleted, Id: "ABC123", UserId: "PARTICIPANT1"}, e)
+	})
+
+	suite.Run("a bare event without a name is assumed to be QUESTIONNAIRE_COMPLETED", func() {
+		e, err := DecodeMessage(&sqs.Message{Body: aws.String(`{"Id": "ABC123"}`)})
+		suite.Require().NoError(err)
+		suite.Equal(event.QuestionnaireCompleted, e.FunctionName())
+	})
+
+	suite.Run("an event envelope", func() {
+		completed := &event.QuestionnaireCompletedEvent{Name: event.QuestionnaireCompleted, Id: "ABC123"}
+		body, err := event.MarshalEnvelope(completed)
+		suite.Require().NoError(err)
+
+		e, err := DecodeMessage(&sqs.Message{Body: aws.String(string(body))})
+		suite.Require().NoError(err)
+		suite.Equal(completed, e)
+	})
+
+	suite.Run("when the body isn't JSON", func() {
+		_, err := DecodeMessage(&sqs.Message{Body: aws.String("Information about current NY Times fiction bestseller")})
+		suite.Error(err)
+	})
+}
+
+func TestConsumerSuite(t *testing.T) {
+	suite.Run(t, new(ConsumerSuite))
+}
@@ -1,159 +1,560 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"github.com/jamesineda/reschedular/app/utils"
 	"github.com/jmoiron/sqlx"
 	"log"
 	"reflect"
+	"sort"
 	"strings"
+	"time"
 	"unicode"
 )
 
 const (
 	select_    = "SELECT"
 	insertInto = "INSERT INTO"
+	update     = "UPDATE"
+	set        = "SET"
+	deleteFrom = "DELETE FROM"
 	from       = "FROM"
 	values     = "VALUES"
 	where_     = "WHERE"
 	and        = "AND"
-	in         = "IN"
-	null       = "NULL"
 )
 
 type SQLXClient interface {
 	Get(dest interface{}, query string, args ...interface{}) error
 	Select(dest interface{}, query string, args ...interface{}) error
 	NamedExec(query string, arg interface{}) (sql.Result, error)
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// SQLXTx the subset of *sqlx.Tx that the client uses to group writes together
+type SQLXTx interface {
+	SQLXClient
+	Commit() error
+	Rollback() error
+}
+
+// TxBeginner is implemented by SQLX clients that are able to open a transaction
+type TxBeginner interface {
+	BeginTransaction(ctx context.Context, opts *sql.TxOptions) (SQLXTx, error)
+}
+
+var ErrTransactionsNotSupported = fmt.Errorf("database client does not support transactions")
+var ErrAlreadyInTransaction = fmt.Errorf("database client is already in a transaction")
+var ErrNoChanges = fmt.Errorf("no changes to update")
+var ErrPingNotSupported = fmt.Errorf("database client can't be pinged")
+
+// Pinger is implemented by SQLX clients that can check their connection is still alive
+type Pinger interface {
+	PingContext(ctx context.Context) error
 }
 
 type Client interface {
 	GetById(id string, table interface{}) (interface{}, error)
-	GetList(rows interface{}, filters Filters) error
+	GetList(rows interface{}, filters Filters, opts ...ListOption) error
+	Count(table interface{}, filters Filters) (int, error)
+	Exists(table interface{}, filters Filters) (bool, error)
+	Max(table interface{}, column string, filters Filters, dest interface{}) error
 	Create(object interface{}) error
+	CreateAll(objects ...interface{}) error
+	Update(object interface{}, fields ...string) error
+	UpdateWhere(table interface{}, filters Filters, changes map[string]interface{}) (int64, error)
+	Delete(object interface{}) error
+	Upsert(object interface{}, conflict ...string) error
+	Ping(ctx context.Context) error
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (Tx, error)
+	WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx Client) error) error
+}
+
+// Tx a Client whose reads and writes all happen within the one transaction, until it's committed or rolled back
+type Tx interface {
+	Client
+	Commit() error
+	Rollback() error
 }
 
 type DatabaseConn struct {
 	SQLXClient
+	// dialect how queries are written for the driver, they're built with ? placeholders and rebound before they're run
+	dialect Dialect
+	// inTx is set on the Client handed out by BeginTx, nested WithTx calls join the transaction that's already open
+	inTx bool
+}
+
+// txConn the Tx returned by BeginTx
+type txConn struct {
+	*DatabaseConn
+	tx *conflictTx
+}
+
+func (t *txConn) conflicted() bool {
+	return t.tx.conflict != nil
+}
+
+func (t *txConn) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *txConn) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// sqlxDB adapts *sqlx.DB so that it satisfies TxBeginner
+type sqlxDB struct {
+	*sqlx.DB
+}
+
+func (db *sqlxDB) BeginTransaction(ctx context.Context, opts *sql.TxOptions) (SQLXTx, error) {
+	return db.BeginTxx(ctx, opts)
 }
 
 func NewFakeDatabaseConn(fake *FakeSQLX) (Client, error) {
-	return &DatabaseConn{fake}, nil
+	return &DatabaseConn{SQLXClient: fake, dialect: sqliteDialect{}}, nil
 }
 
+// NewDatabaseConn opens the connection pool and waits for the database to answer, retrying as configured. Errors are
+// returned rather than exiting, so the caller decides what a missing database means
 func NewDatabaseConn(config *utils.DatabaseConfig) (Client, error) {
+	if config.ClientName != "" && config.ClientName != "sqlx" {
+		return nil, fmt.Errorf("unsupported database client %q", config.ClientName)
+	}
+
+	// the memory driver doesn't speak SQL, so it has no dialect
+	if config.Driver == "memory" {
+		return NewMemoryClient(), nil
+	}
+
+	dialect, err := DialectFor(config.Driver)
+	if err != nil {
+		return nil, err
+	}
+
 	switch config.Driver {
 	case "fake":
-		return &DatabaseConn{&FakeSQLX{}}, nil
+		return &DatabaseConn{SQLXClient: &FakeSQLX{}, dialect: dialect}, nil
 
 	default:
-		db, err := sqlx.Connect(config.Driver, config.Dsn)
+		db, err := sqlx.Open(config.Driver, config.Dsn)
 		if err != nil {
-			log.Fatalln(err)
-			return nil, err
+			return nil, fmt.Errorf("failed to open %s database: %v", config.Driver, err)
 		}
 
-		return &DatabaseConn{db}, nil
+		db.SetMaxOpenConns(config.MaxOpenConns)
+		db.SetMaxIdleConns(config.MaxIdleConns)
+		db.SetConnMaxLifetime(config.ConnMaxLifetime)
+		db.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+
+		if err = connect(db, config); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+		return &DatabaseConn{SQLXClient: &sqlxDB{db}, dialect: dialect}, nil
 	}
 }
 
-/*
-	A more polished solution could be something like a Filters class with a pre-defined set of supported
-	comparators and values, which would be validated and handled in the database client class, as to avoid SQL errors.
-	For the sake of time, I'm just implementing a basic slice of arguments that will be expected like:
+// connect pings the database until it answers, or it's been retried ConnectRetries times
+func connect(pinger Pinger, config *utils.DatabaseConfig) (err error) {
+	for attempt := 0; ; attempt++ {
+		if err = pingWithTimeout(pinger, config.ConnectTimeout); err == nil {
+			return nil
+		}
+
+		if attempt >= config.ConnectRetries {
+			return fmt.Errorf("failed to connect to %s database after %d attempt(s): %v", config.Driver, attempt+1, err)
+		}
+		log.Printf("failed to connect to %s database, retrying in %s: %s", config.Driver, config.ConnectRetryInterval, err)
+		time.Sleep(config.ConnectRetryInterval)
+	}
+}
 
-	[
-		["attr_a", "=", "foo"],
-		["attr_b", "<", 100],
-		["attr_c", "IN", "(1,2,3)"],
-	]
-*/
-type Filters [][]interface{}
+func pingWithTimeout(pinger Pinger, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return pinger.PingContext(ctx)
+}
 
-func (f *Filters) Values() (values []interface{}) {
-	for _, filter := range *f {
-		values = append(values, filter[2])
+// Ping checks the database can still be reached, it's only available outside of a transaction
+func (db *DatabaseConn) Ping(ctx context.Context) error {
+	pinger, ok := db.SQLXClient.(Pinger)
+	if !ok {
+		return ErrPingNotSupported
 	}
-	return
+	return pinger.PingContext(ctx)
 }
 
 func (db *DatabaseConn) GetById(id string, table interface{}) (interface{}, error) {
-	tableName, selectFields := getSelectOptions(table)
+	tableName, selectFields := getSelectOptions(db.dialect, table)
 
 	// I'd prefer an incremental ID on the database table, as well as created_at/ updated_at timestamps, which
 	// would be used for ordering in all queries.
-	query := fmt.Sprintf("SELECT %s FROM %s WHERE id=$1 LIMIT 1", selectFields, tableName)
-	if err := db.Get(table, query, id); err != nil {
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s=? LIMIT 1", selectFields, tableName, db.dialect.Quote("id"))
+	if err := db.Get(table, db.rebind(query), id); err != nil {
 		return nil, err
 	}
 
 	return table, nil
 }
 
-func (db *DatabaseConn) GetList(table interface{}, filters Filters) error {
-	tableName, selectFields := getSelectOptions(table)
-	query := generateSelectQuery(tableName, selectFields, filters)
-	if err := db.Select(table, query, filters.Values()...); err != nil {
+// GetList without any options, the rows come back in whatever order the database chooses
+func (db *DatabaseConn) GetList(table interface{}, filters Filters, opts ...ListOption) error {
+	tableName, selectFields := getSelectOptions(db.dialect, table)
+	query, args, err := generateSelectQuery(db.dialect, tableName, selectFields, filters, NewListOptions(opts...))
+	if err != nil {
+		return err
+	}
+
+	if err := db.Select(table, db.rebind(query), args...); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// Count the number of rows matching the filters, without loading any of them
+func (db *DatabaseConn) Count(table interface{}, filters Filters) (int, error) {
+	query, err := generateAggregateQuery(db.dialect, getTableName(table), "COUNT(*)", filters)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	if err = db.Get(&count, db.rebind(query), filters.Values()...); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Exists whether any row matches the filters, the database can stop looking as soon as it finds one
+func (db *DatabaseConn) Exists(table interface{}, filters Filters) (bool, error) {
+	query, err := generateAggregateQuery(db.dialect, getTableName(table), "1", filters)
+	if err != nil {
+		return false, err
+	}
+
+	var found int
+	switch err = db.Get(&found, db.rebind(query+" LIMIT 1"), filters.Values()...); err {
+	case nil:
+		return true, nil
+	case sql.ErrNoRows:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// Max scans the largest value of column across the rows matching the filters into dest. With no matching rows the
+// value is NULL, so dest should be able to hold one, e.g. *sql.NullTime
+func (db *DatabaseConn) Max(table interface{}, column string, filters Filters, dest interface{}) error {
+	if !columnName.MatchString(column) {
+		return fmt.Errorf("invalid column %q to aggregate", column)
+	}
+
+	query, err := generateAggregateQuery(db.dialect, getTableName(table), "MAX("+db.dialect.Quote(column)+")", filters)
+	if err != nil {
+		return err
+	}
+	return db.Get(dest, db.rebind(query), filters.Values()...)
+}
+
 func (db *DatabaseConn) Create(object interface{}) error {
-	tableName, selectFields := getSelectOptions(object)
+	_, err := db.NamedExec(getInsertQuery(db.dialect, object), object)
+	return err
+}
+
+// CreateAll inserts every object within a single transaction, so either all of the rows are written or none are
+func (db *DatabaseConn) CreateAll(objects ...interface{}) error {
+	return db.WithTx(context.Background(), nil, func(tx Client) error {
+		for _, object := range objects {
+			if err := tx.Create(object); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// BeginTx opens a transaction, opts can be nil to use the driver's default isolation level
+func (db *DatabaseConn) BeginTx(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+	if db.inTx {
+		return nil, ErrAlreadyInTransaction
+	}
+
+	beginner, ok := db.SQLXClient.(TxBeginner)
+	if !ok {
+		return nil, ErrTransactionsNotSupported
+	}
+
+	tx, err := beginner.BeginTransaction(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	conflict := &conflictTx{SQLXTx: tx, dialect: db.dialect}
+	return &txConn{DatabaseConn: &DatabaseConn{SQLXClient: conflict, dialect: db.dialect, inTx: true}, tx: conflict}, nil
+}
+
+// WithTx runs fn within a transaction, committing if it returns nil and rolling back otherwise. When called on a Client
+// that's already in a transaction, fn just joins it, and the outermost WithTx decides whether it's committed
+func (db *DatabaseConn) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx Client) error) error {
+	if db.inTx {
+		return fn(db)
+	}
+	return withTx(ctx, db, opts, fn)
+}
+
+// withTx begins a transaction on client and runs fn within it, see WithTx. When the transaction failed because of a
+// concurrent one, the error is returned as a *ConflictError
+func withTx(ctx context.Context, client Client, opts *sql.TxOptions, fn func(tx Client) error) (err error) {
+	tx, err := client.BeginTx(ctx, opts)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			_ = tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+
+		// fn's error may well have been wrapped, so it's the transaction that remembers whether it conflicted
+		if c, ok := tx.(conflicter); ok && err != nil && c.conflicted() {
+			err = &ConflictError{Err: err}
+		}
+	}()
+
+	err = fn(tx)
+	return
+}
+
+// Update writes the given fields of object back to its row, matched on id. With no fields, every column is written
+func (db *DatabaseConn) Update(object interface{}, fields ...string) error {
+	query, err := getUpdateQuery(db.dialect, object, fields)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.NamedExec(query, object)
+	if err != nil {
+		return err
+	}
+	return expectRowsAffected(result)
+}
+
+// UpdateWhere applies changes, keyed on column name, to every row matching the filters. It returns the number of rows
+// that were updated
+func (db *DatabaseConn) UpdateWhere(table interface{}, filters Filters, changes map[string]interface{}) (int64, error) {
+	if len(changes) == 0 {
+		return 0, ErrNoChanges
+	}
+
+	columns := make([]string, 0, len(changes))
+	for column := range changes {
+		columns = append(columns, column)
+	}
+	// sorted so the same changes always produce the same query
+	sort.Strings(columns)
+
+	args := make([]interface{}, 0, len(changes)+len(filters))
+	for _, column := range columns {
+		args = append(args, changes[column])
+	}
+	args = append(args, filters.Values()...)
+
+	query, err := generateUpdateWhereQuery(db.dialect, getTableName(table), columns, filters)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := db.SQLXClient.Exec(db.rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Delete removes the object's row, matched on id
+func (db *DatabaseConn) Delete(object interface{}) error {
+	query := strings.Join([]string{deleteFrom, db.dialect.Quote(getTableName(object)), where_, db.dialect.Quote("id") + "=:id"}, " ")
+	result, err := db.NamedExec(query, object)
+	if err != nil {
+		return err
+	}
+	return expectRowsAffected(result)
+}
+
+// Upsert inserts the object, or updates every other column of the row it conflicts with. conflict defaults to the id
+func (db *DatabaseConn) Upsert(object interface{}, conflict ...string) error {
+	query, err := getUpsertQuery(db.dialect, object, conflict)
+	if err != nil {
+		return err
+	}
+	_, err = db.NamedExec(query, object)
+	return err
+}
+
+// expectRowsAffected Update and Delete target a single row by id, so nothing being affected means it doesn't exist
+func expectRowsAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func getInsertQuery(d Dialect, object interface{}) string {
+	tableName, selectFields := getSelectOptions(d, object)
 	tags := getTags(object, "db")
 	fieldNames := ":" + strings.Join(tags, ",:")
-	query := generateInsertQuery(tableName, selectFields, fieldNames)
-	_, err := db.NamedExec(query, object)
-	return err
+	return generateInsertQuery(tableName, selectFields, fieldNames)
 }
 
 func generateInsertQuery(tableName, fieldNames, namedExecColName string) string {
 	return strings.Join([]string{insertInto, tableName, "(", fieldNames, ")", values, "(", namedExecColName, ")"}, " ")
 }
 
-func generateSelectQuery(tableName, fieldNames string, filters Filters) string {
-	query := strings.Join([]string{select_, fieldNames, from, tableName}, " ")
-	if filters != nil && len(filters) > 0 {
-		for fi, filter := range filters {
+// getUpsertQuery every column that isn't part of the conflict is updated
+func getUpsertQuery(d Dialect, object interface{}, conflict []string) (string, error) {
+	if len(conflict) == 0 {
+		conflict = []string{"id"}
+	}
 
-			whereOrAnd := and
-			if fi == 0 {
-				whereOrAnd = where_
-			}
+	tags := getTags(object, "db")
+	known := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		known[tag] = true
+	}
 
-			var q string
-			if filter[2] != nil || filter[2] == null {
-				q = "?"
-			} else {
-				q = null
-			}
+	inConflict := make(map[string]bool, len(conflict))
+	for _, column := range conflict {
+		if !known[column] {
+			return "", fmt.Errorf("%s has no column %s to upsert on", getTableName(object), column)
+		}
+		inConflict[column] = true
+	}
 
-			if filter[1] == in {
-				q = "("
-				v := reflect.ValueOf(filter[2])
-				for i := 0; i < v.Len(); i++ {
-					switch v.Index(i).Type().Kind() {
-					case reflect.String:
-						q += "'" + fmt.Sprint(v.Index(i).Interface()) + "',"
-					default:
-						q += fmt.Sprint(v.Index(i).Interface()) + ","
-					}
-				}
-				q = q[:len(q)-1] + ")"
+	var columns []string
+	for _, tag := range tags {
+		if !inConflict[tag] {
+			columns = append(columns, tag)
+		}
+	}
+	return getInsertQuery(d, object) + " " + d.Upsert(conflict, columns), nil
+}
+
+// getUpdateQuery fields are checked against the struct's db tags, so a typo can't end up in the query
+func getUpdateQuery(d Dialect, object interface{}, fields []string) (string, error) {
+	tags := getTags(object, "db")
+	if len(fields) == 0 {
+		for _, tag := range tags {
+			if tag != "id" {
+				fields = append(fields, tag)
 			}
+		}
+	}
+
+	known := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		known[tag] = true
+	}
+	if !known["id"] {
+		return "", fmt.Errorf("%s has no id column to update by", getTableName(object))
+	}
 
-			query = strings.Join([]string{query, whereOrAnd, fmt.Sprintf("%v", filter[0]), fmt.Sprintf("%v", filter[1]), q}, " ")
+	assignments := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if !known[field] || field == "id" {
+			return "", fmt.Errorf("%s has no updatable column %s", getTableName(object), field)
 		}
+		assignments = append(assignments, d.Quote(field)+"=:"+field)
+	}
+	if len(assignments) == 0 {
+		return "", ErrNoChanges
 	}
-	return query
+
+	return strings.Join([]string{update, d.Quote(getTableName(object)), set, strings.Join(assignments, ","), where_,
+		d.Quote("id") + "=:id"}, " "), nil
 }
 
-func getSelectOptions(table interface{}) (tn, fields string) {
-	tn = getTableName(table)
+func generateUpdateWhereQuery(d Dialect, tableName string, columns []string, filters Filters) (string, error) {
+	assignments := make([]string, 0, len(columns))
+	for _, column := range columns {
+		if !columnName.MatchString(column) {
+			return "", fmt.Errorf("invalid column %q to update", column)
+		}
+		assignments = append(assignments, d.Quote(column)+" = ?")
+	}
+
+	where, err := generateWhereClause(d, filters)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{update, d.Quote(tableName), set, strings.Join(assignments, ", ")}, " ") + where, nil
+}
+
+// generateSelectQuery returns the query along with its values, in the order their placeholders appear. tableName and
+// fieldNames are expected to have been quoted already, see getSelectOptions
+func generateSelectQuery(d Dialect, tableName, fieldNames string, filters Filters, options *ListOptions) (string, []interface{}, error) {
+	if err := options.Validate(); err != nil {
+		return "", nil, err
+	}
+
+	where, err := generateWhereClause(d, filters)
+	if err != nil {
+		return "", nil, err
+	}
+	args := filters.Values()
+
+	if keyset, keysetArgs := options.keysetCondition(d); keyset != "" {
+		whereOrAnd := and
+		if where == "" {
+			whereOrAnd = where_
+		}
+		where = strings.Join([]string{where, whereOrAnd, keyset}, " ")
+		args = append(args, keysetArgs...)
+	}
+
+	suffix, suffixArgs := options.suffix(d)
+	args = append(args, suffixArgs...)
+	return strings.Join([]string{select_, fieldNames, from, tableName}, " ") + where + suffix, args, nil
+}
+
+func generateAggregateQuery(d Dialect, tableName, aggregate string, filters Filters) (string, error) {
+	where, err := generateWhereClause(d, filters)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{select_, aggregate, from, d.Quote(tableName)}, " ") + where, nil
+}
+
+// rebind swaps the ? placeholders for the driver's own, e.g. $1, $2 for postgres
+func (db *DatabaseConn) rebind(query string) string {
+	return sqlx.Rebind(db.dialect.BindType(), query)
+}
+
+// getSelectOptions the quoted table name and column list
+func getSelectOptions(d Dialect, table interface{}) (tn, fields string) {
+	tn = d.Quote(getTableName(table))
 	tags := getTags(table, "db")
+	for i, tag := range tags {
+		tags[i] = d.Quote(tag)
+	}
 	fields = strings.Join(tags, ",")
 	return
 }
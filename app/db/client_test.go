@@ -1,9 +1,18 @@
 package db
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
 	"github.com/jamesineda/reschedular/app/models"
+	"github.com/jamesineda/reschedular/app/utils"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/suite"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 type ClientTestSuite struct {
@@ -18,24 +27,26 @@ func (suite *ClientTestSuite) Test_generateSelectQuery() {
 	suite.Run("generate query for GetList", func() {
 
 		table := models.QuestionnaireResults{}
-		tableName, selectFields := getSelectOptions(table)
+		tableName, selectFields := getSelectOptions(mysqlDialect{}, table)
 		filters := Filters{
-			[]interface{}{"name", "=", "hair regrowth questionnaire"},
-			[]interface{}{"max_attempts", "=", 5},
-			[]interface{}{"questions", "=", `{"did your hair grow back?": "no"}`},
+			Eq("name", "hair regrowth questionnaire"),
+			Eq("max_attempts", 5),
+			Eq("questions", `{"did your hair grow back?": "no"}`),
 		}
 
-		query := generateSelectQuery(tableName, selectFields, filters)
-		suite.Equal(`SELECT id,answers,questionnaire_id,participant_id,questionnaire_schedule_id,completed_at FROM questionnaire_results WHERE name = ? AND max_attempts = ? AND questions = ?`, query)
+		query, _, err := generateSelectQuery(mysqlDialect{}, tableName, selectFields, filters, NewListOptions())
+		suite.Require().NoError(err)
+		suite.Equal("SELECT `id`,`answers`,`questionnaire_id`,`participant_id`,`questionnaire_schedule_id`,`completed_at` "+
+			"FROM `questionnaire_results` WHERE `name` = ? AND `max_attempts` = ? AND `questions` = ?", query)
 	})
 }
 
 func (suite *ClientTestSuite) Test_Filter_Values() {
 	suite.Run("generate Filter values", func() {
 		filters := Filters{
-			[]interface{}{"name", "=", "hair regrowth questionnaire"},
-			[]interface{}{"max_attempts", "=", 5},
-			[]interface{}{"questions", "=", `{"did your hair grow back?": "no"}`},
+			Eq("name", "hair regrowth questionnaire"),
+			Eq("max_attempts", 5),
+			Eq("questions", `{"did your hair grow back?": "no"}`),
 		}
 
 		values := filters.Values()
@@ -43,6 +54,283 @@ func (suite *ClientTestSuite) Test_Filter_Values() {
 	})
 }
 
+func (suite *ClientTestSuite) Test_getUpdateQuery() {
+	suite.Run("only the given fields are set", func() {
+		query, err := getUpdateQuery(mysqlDialect{}, &models.ScheduledQuestionnaire{}, []string{"status"})
+		suite.Require().NoError(err)
+		suite.Equal("UPDATE `scheduled_questionnaires` SET `status`=:status WHERE `id`=:id", query)
+	})
+
+	suite.Run("every column but the id is set when no fields are given", func() {
+		query, err := getUpdateQuery(mysqlDialect{}, &models.ScheduledQuestionnaire{}, nil)
+		suite.Require().NoError(err)
+		suite.Equal("UPDATE `scheduled_questionnaires` SET `questionnaire_id`=:questionnaire_id,`participant_id`=:participant_id,"+
+			"`scheduled_at`=:scheduled_at,`status`=:status WHERE `id`=:id", query)
+	})
+
+	suite.Run("unknown fields and the id can't be updated", func() {
+		_, err := getUpdateQuery(mysqlDialect{}, &models.ScheduledQuestionnaire{}, []string{"stauts"})
+		suite.EqualError(err, "scheduled_questionnaires has no updatable column stauts")
+
+		_, err = getUpdateQuery(mysqlDialect{}, &models.ScheduledQuestionnaire{}, []string{"id"})
+		suite.EqualError(err, "scheduled_questionnaires has no updatable column id")
+	})
+}
+
+func (suite *ClientTestSuite) Test_generateUpdateWhereQuery() {
+	filters := Filters{
+		Eq("participant_id", "PARTICIPANT1"),
+		Eq("status", "pending"),
+	}
+	query, err := generateUpdateWhereQuery(mysqlDialect{}, "scheduled_questionnaires", []string{"scheduled_at", "status"}, filters)
+	suite.Require().NoError(err)
+	suite.Equal("UPDATE `scheduled_questionnaires` SET `scheduled_at` = ?, `status` = ? WHERE `participant_id` = ? AND `status` = ?", query)
+}
+
+func (suite *ClientTestSuite) Test_UpdateWhere() {
+	dbConn, _ := NewFakeDatabaseConn(&FakeSQLX{})
+
+	suite.Run("returns the number of rows updated", func() {
+		updated, err := dbConn.UpdateWhere(&models.ScheduledQuestionnaire{}, Filters{Eq("status", "pending")},
+			map[string]interface{}{"status": "cancelled"})
+		suite.NoError(err)
+		suite.Equal(int64(1), updated)
+	})
+
+	suite.Run("when there's nothing to change", func() {
+		_, err := dbConn.UpdateWhere(&models.ScheduledQuestionnaire{}, nil, nil)
+		suite.Equal(ErrNoChanges, err)
+	})
+}
+
+func (suite *ClientTestSuite) Test_generateAggregateQuery() {
+	suite.Run("count", func() {
+		query, err := generateAggregateQuery(mysqlDialect{}, "questionnaire_results", "COUNT(*)", Filters{Eq("participant_id", "PARTICIPANT1")})
+		suite.Require().NoError(err)
+		suite.Equal("SELECT COUNT(*) FROM `questionnaire_results` WHERE `participant_id` = ?", query)
+	})
+
+	suite.Run("without filters", func() {
+		query, err := generateAggregateQuery(mysqlDialect{}, "questionnaire_results", "MAX(completed_at)", nil)
+		suite.Require().NoError(err)
+		suite.Equal("SELECT MAX(completed_at) FROM `questionnaire_results`", query)
+	})
+}
+
+func (suite *ClientTestSuite) Test_Max() {
+	suite.Run("the column is checked before it's written into the query", func() {
+		dbConn, _ := NewFakeDatabaseConn(&FakeSQLX{})
+		var latest sql.NullTime
+		err := dbConn.Max(&models.QuestionnaireResult{}, "completed_at) FROM participants --", nil, &latest)
+		suite.EqualError(err, `invalid column "completed_at) FROM participants --" to aggregate`)
+	})
+}
+
+// flakyPinger fails the first few pings
+type flakyPinger struct {
+	failures int
+	pings    int
+}
+
+func (p *flakyPinger) PingContext(ctx context.Context) error {
+	p.pings++
+	if p.pings <= p.failures {
+		return fmt.Errorf("connection refused")
+	}
+	return nil
+}
+
+func (suite *ClientTestSuite) Test_connect() {
+	config := &utils.DatabaseConfig{Driver: "mysql", ConnectRetries: 2, ConnectRetryInterval: time.Millisecond}
+
+	suite.Run("retries until the database answers", func() {
+		pinger := &flakyPinger{failures: 2}
+		suite.NoError(connect(pinger, config))
+		suite.Equal(3, pinger.pings)
+	})
+
+	suite.Run("gives up after the last retry", func() {
+		pinger := &flakyPinger{failures: 3}
+		suite.EqualError(connect(pinger, config), "failed to connect to mysql database after 3 attempt(s): connection refused")
+		suite.Equal(3, pinger.pings)
+	})
+}
+
+func (suite *ClientTestSuite) Test_NewDatabaseConn() {
+	suite.Run("unsupported clients are an error, not an exit", func() {
+		_, err := NewDatabaseConn(&utils.DatabaseConfig{ClientName: "gorm", Driver: "mysql"})
+		suite.EqualError(err, `unsupported database client "gorm"`)
+	})
+
+	suite.Run("the memory driver keeps rows in memory", func() {
+		dbConn, err := NewDatabaseConn(&utils.DatabaseConfig{Driver: "memory"})
+		suite.Require().NoError(err)
+		suite.IsType(&MemoryClient{}, dbConn)
+	})
+
+	suite.Run("a pooled sqlite connection can be pinged", func() {
+		config := utils.NewDefaultDatabaseConfig()
+		config.Driver = "sqlite3"
+		config.Dsn = filepath.Join(suite.T().TempDir(), "reschedular.db")
+
+		dbConn, err := NewDatabaseConn(config)
+		suite.Require().NoError(err)
+		suite.NoError(dbConn.Ping(context.Background()))
+	})
+}
+
+func (suite *ClientTestSuite) Test_FakeSQLX() {
+	suite.Run("Get and Select copy what they're set to return into dest", func() {
+		fake := NewSetFakeSQLX(&models.Participant{Id: "PARTICIPANT1"}, models.Participants{{Id: "PARTICIPANT2"}})
+
+		var participant models.Participant
+		suite.Require().NoError(fake.Get(&participant, ""))
+		suite.Equal("PARTICIPANT1", participant.Id)
+
+		var participants models.Participants
+		suite.Require().NoError(fake.Select(&participants, ""))
+		suite.Equal(models.Participants{{Id: "PARTICIPANT2"}}, participants)
+	})
+
+	suite.Run("dest is left alone when there's nothing to return", func() {
+		count := 3
+		suite.NoError((&FakeSQLX{}).Get(&count, ""))
+		suite.Equal(3, count)
+	})
+
+	suite.Run("mismatched types are an error", func() {
+		var count int
+		suite.EqualError(NewSetFakeSQLX("three", nil).Get(&count, ""), "can't scan string into *int")
+	})
+}
+
+func (suite *ClientTestSuite) Test_Ping() {
+	fake := &FakeSQLX{PingErr: fmt.Errorf("connection reset")}
+	dbConn, _ := NewFakeDatabaseConn(fake)
+	suite.EqualError(dbConn.Ping(context.Background()), "connection reset")
+}
+
+func (suite *ClientTestSuite) Test_WithTx() {
+	suite.Run("commits when fn succeeds", func() {
+		fake := &FakeSQLX{}
+		dbConn, _ := NewFakeDatabaseConn(fake)
+		err := dbConn.WithTx(context.Background(), nil, func(tx Client) error {
+			return tx.Create(&models.Participant{Id: "PARTICIPANT1"})
+		})
+		suite.NoError(err)
+		suite.Equal(1, fake.Commits)
+		suite.Equal(0, fake.Rollbacks)
+	})
+
+	suite.Run("rolls back and returns the error when fn fails", func() {
+		fake := &FakeSQLX{}
+		dbConn, _ := NewFakeDatabaseConn(fake)
+		failed := fmt.Errorf("failed")
+		err := dbConn.WithTx(context.Background(), nil, func(tx Client) error {
+			return failed
+		})
+		suite.Equal(failed, err)
+		suite.Equal(0, fake.Commits)
+		suite.Equal(1, fake.Rollbacks)
+	})
+
+	suite.Run("nested calls join the outer transaction", func() {
+		fake := &FakeSQLX{}
+		dbConn, _ := NewFakeDatabaseConn(fake)
+		err := dbConn.WithTx(context.Background(), nil, func(tx Client) error {
+			if _, err := tx.BeginTx(context.Background(), nil); err != ErrAlreadyInTransaction {
+				return fmt.Errorf("expected ErrAlreadyInTransaction, got %v", err)
+			}
+			return tx.CreateAll(&models.Participant{Id: "PARTICIPANT1"}, &models.Participant{Id: "PARTICIPANT2"})
+		})
+		suite.NoError(err)
+		suite.Equal(1, fake.Commits)
+	})
+
+	suite.Run("when the client doesn't support transactions", func() {
+		dbConn := &DatabaseConn{SQLXClient: &struct{ SQLXClient }{}}
+		err := dbConn.WithTx(context.Background(), nil, func(tx Client) error {
+			return nil
+		})
+		suite.Equal(ErrTransactionsNotSupported, err)
+	})
+}
+
+func (suite *ClientTestSuite) Test_IsConflict() {
+	for name, tc := range map[string]struct {
+		dialect  Dialect
+		conflict error
+		other    error
+	}{
+		"mysql deadlocks":                 {mysqlDialect{}, &mysql.MySQLError{Number: 1213}, &mysql.MySQLError{Number: 1062}},
+		"postgres serialization failures": {postgresDialect{}, &pq.Error{Code: "40001"}, &pq.Error{Code: "23505"}},
+		"postgres deadlocks":              {postgresDialect{}, &pq.Error{Code: "40P01"}, fmt.Errorf("40P01")},
+		"sqlite being busy":               {sqliteDialect{}, sqlite3.Error{Code: sqlite3.ErrBusy}, sqlite3.Error{Code: sqlite3.ErrConstraint}},
+	} {
+		suite.Run(name, func() {
+			suite.True(tc.dialect.IsConflict(tc.conflict))
+			suite.False(tc.dialect.IsConflict(tc.other))
+		})
+	}
+}
+
+func (suite *ClientTestSuite) Test_WithTxRetry() {
+	busy := sqlite3.Error{Code: sqlite3.ErrBusy}
+
+	suite.Run("a transaction that conflicts on commit is run again", func() {
+		fake := &FakeSQLX{CommitErrs: []error{busy}}
+		dbConn, _ := NewFakeDatabaseConn(fake)
+		runs := 0
+		err := WithTxRetry(context.Background(), dbConn, nil, 3, func(tx Client) error {
+			runs++
+			return tx.Create(&models.Participant{Id: "PARTICIPANT1"})
+		})
+		suite.NoError(err)
+		suite.Equal(2, runs)
+		suite.Equal(1, fake.Commits)
+	})
+
+	suite.Run("a conflict is spotted even when fn wraps the error", func() {
+		fake := &FakeSQLX{ExecErrs: []error{busy}}
+		dbConn, _ := NewFakeDatabaseConn(fake)
+		runs := 0
+		err := WithTxRetry(context.Background(), dbConn, nil, 3, func(tx Client) error {
+			runs++
+			if err := tx.Create(&models.Participant{Id: "PARTICIPANT1"}); err != nil {
+				return fmt.Errorf("failed to create participant: %v", err)
+			}
+			return nil
+		})
+		suite.NoError(err)
+		suite.Equal(2, runs)
+		suite.Equal(1, fake.Rollbacks)
+	})
+
+	suite.Run("gives up after the given number of attempts", func() {
+		fake := &FakeSQLX{CommitErrs: []error{busy, busy, busy}}
+		dbConn, _ := NewFakeDatabaseConn(fake)
+		err := WithTxRetry(context.Background(), dbConn, nil, 2, func(tx Client) error {
+			return nil
+		})
+		suite.True(IsConflict(err))
+		suite.Equal(busy, err.(*ConflictError).Err)
+		suite.Len(fake.CommitErrs, 1)
+	})
+
+	suite.Run("other errors aren't retried", func() {
+		fake := &FakeSQLX{ExecErrs: []error{sqlite3.Error{Code: sqlite3.ErrConstraint}}}
+		dbConn, _ := NewFakeDatabaseConn(fake)
+		runs := 0
+		err := WithTxRetry(context.Background(), dbConn, nil, 3, func(tx Client) error {
+			runs++
+			return tx.Create(&models.Participant{Id: "PARTICIPANT1"})
+		})
+		suite.Error(err)
+		suite.False(IsConflict(err))
+		suite.Equal(1, runs)
+	})
+}
+
 func TestClientTestSuite(t *testing.T) {
 	suite.Run(t, new(ClientTestSuite))
 }
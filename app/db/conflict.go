@@ -0,0 +1,86 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// conflictRetryBackoff how long WithTxRetry waits before each retry, multiplied by the number of attempts so far
+const conflictRetryBackoff = 10 * time.Millisecond
+
+// ConflictError a transaction that failed because of a concurrent one, e.g. a serialization failure, a deadlock or
+// sqlite being busy. Running the transaction again could succeed
+type ConflictError struct {
+	Err error
+}
+
+func (e *ConflictError) Error() string {
+	return e.Err.Error()
+}
+
+func IsConflict(err error) bool {
+	_, ok := err.(*ConflictError)
+	return ok
+}
+
+// conflicter is implemented by transactions that keep track of whether any of their statements conflicted
+type conflicter interface {
+	conflicted() bool
+}
+
+// conflictTx remembers the first error from the transaction's statements, or its commit, that the dialect says was down
+// to a concurrent transaction
+type conflictTx struct {
+	SQLXTx
+	dialect  Dialect
+	conflict error
+}
+
+func (t *conflictTx) check(err error) error {
+	if err != nil && t.conflict == nil && t.dialect.IsConflict(err) {
+		t.conflict = err
+	}
+	return err
+}
+
+func (t *conflictTx) Get(dest interface{}, query string, args ...interface{}) error {
+	return t.check(t.SQLXTx.Get(dest, query, args...))
+}
+
+func (t *conflictTx) Select(dest interface{}, query string, args ...interface{}) error {
+	return t.check(t.SQLXTx.Select(dest, query, args...))
+}
+
+func (t *conflictTx) NamedExec(query string, arg interface{}) (sql.Result, error) {
+	result, err := t.SQLXTx.NamedExec(query, arg)
+	return result, t.check(err)
+}
+
+func (t *conflictTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	result, err := t.SQLXTx.Exec(query, args...)
+	return result, t.check(err)
+}
+
+func (t *conflictTx) Commit() error {
+	return t.check(t.SQLXTx.Commit())
+}
+
+// WithTxRetry runs fn within a transaction, like WithTx, running it again while it fails with a *ConflictError, up to
+// attempts times in all. fn has to be safe to run more than once, nothing it did in a failed attempt is kept
+func WithTxRetry(ctx context.Context, client Client, opts *sql.TxOptions, attempts int, fn func(tx Client) error) error {
+	for attempt := 1; ; attempt++ {
+		err := client.WithTx(ctx, opts, fn)
+		if err == nil || attempt >= attempts || !IsConflict(err) {
+			return err
+		}
+
+		log.Printf("transaction conflicted with a concurrent one, retrying (attempt %d of %d): %s", attempt+1, attempts, err)
+		select {
+		case <-time.After(time.Duration(attempt) * conflictRetryBackoff):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
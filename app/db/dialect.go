@@ -0,0 +1,225 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"strings"
+)
+
+// Dialect the parts of the generated SQL that differ between databases. Queries are built with ? placeholders, which are
+// rebound to the dialect's BindType before they're run
+type Dialect interface {
+	Name() string
+	BindType() int
+	// Quote quotes an identifier, a table qualified column has each part quoted
+	Quote(identifier string) string
+	// Paginate the LIMIT and OFFSET clause, either can be zero to leave it out
+	Paginate(limit, offset int) (string, []interface{})
+	// Upsert the clause that goes on the end of an insert, so a row that conflicts on the given columns is updated
+	Upsert(conflict, columns []string) string
+	// JSONExtract the text value of a top level key within a JSON column
+	JSONExtract(column, key string) string
+	// JSONType the column type JSON payloads are stored in
+	JSONType() string
+	// DateTimeType the column type timestamps are stored in
+	DateTimeType() string
+	// IsConflict whether err means the transaction lost out to a concurrent one, e.g. a serialization failure or a
+	// deadlock, so running it again could succeed
+	IsConflict(err error) bool
+}
+
+// DialectFor picks the dialect for a DatabaseConfig.Driver. The fake driver uses SQLite's, as that's what the tests run
+// real queries against
+func DialectFor(driver string) (Dialect, error) {
+	switch driver {
+	case "mysql":
+		return mysqlDialect{}, nil
+	case "postgres":
+		return postgresDialect{}, nil
+	case "sqlite3", "fake":
+		return sqliteDialect{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", driver)
+	}
+}
+
+func quoteWith(identifier, quote string) string {
+	parts := strings.Split(identifier, ".")
+	for i, part := range parts {
+		parts[i] = quote + strings.ReplaceAll(part, quote, quote+quote) + quote
+	}
+	return strings.Join(parts, ".")
+}
+
+// paginate LIMIT and OFFSET as written by postgres and sqlite, noLimit is used when there's an offset without a limit
+func paginate(limit, offset int, noLimit string) (string, []interface{}) {
+	var parts []string
+	var args []interface{}
+
+	switch {
+	case limit > 0:
+		parts = append(parts, "LIMIT ?")
+		args = append(args, limit)
+	case offset > 0 && noLimit != "":
+		parts = append(parts, "LIMIT "+noLimit)
+	}
+	if offset > 0 {
+		parts = append(parts, "OFFSET ?")
+		args = append(args, offset)
+	}
+	return strings.Join(parts, " "), args
+}
+
+// onConflict the upsert clause shared by postgres and sqlite
+func onConflict(d Dialect, conflict, columns []string) string {
+	quoted := make([]string, 0, len(conflict))
+	for _, column := range conflict {
+		quoted = append(quoted, d.Quote(column))
+	}
+
+	assignments := make([]string, 0, len(columns))
+	for _, column := range columns {
+		assignments = append(assignments, d.Quote(column)+"=excluded."+d.Quote(column))
+	}
+	if len(assignments) == 0 {
+		return "ON CONFLICT (" + strings.Join(quoted, ",") + ") DO NOTHING"
+	}
+	return "ON CONFLICT (" + strings.Join(quoted, ",") + ") DO UPDATE SET " + strings.Join(assignments, ",")
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string {
+	return "mysql"
+}
+
+func (mysqlDialect) BindType() int {
+	return sqlx.QUESTION
+}
+
+func (mysqlDialect) Quote(identifier string) string {
+	return quoteWith(identifier, "`")
+}
+
+// Paginate mysql won't take an OFFSET on its own, so it's given the largest possible LIMIT
+func (mysqlDialect) Paginate(limit, offset int) (string, []interface{}) {
+	return paginate(limit, offset, "18446744073709551615")
+}
+
+// Upsert mysql updates on any unique key, so the conflict columns aren't needed
+func (d mysqlDialect) Upsert(conflict, columns []string) string {
+	assignments := make([]string, 0, len(columns))
+	for _, column := range columns {
+		assignments = append(assignments, d.Quote(column)+"=VALUES("+d.Quote(column)+")")
+	}
+	if len(assignments) == 0 {
+		// there's no DO NOTHING, so the first conflict column is set to itself instead
+		assignments = append(assignments, d.Quote(conflict[0])+"="+d.Quote(conflict[0]))
+	}
+	return "ON DUPLICATE KEY UPDATE " + strings.Join(assignments, ",")
+}
+
+func (d mysqlDialect) JSONExtract(column, key string) string {
+	return "JSON_UNQUOTE(JSON_EXTRACT(" + d.Quote(column) + ", '$." + key + "'))"
+}
+
+func (mysqlDialect) JSONType() string {
+	return "json"
+}
+
+func (mysqlDialect) DateTimeType() string {
+	return "datetime"
+}
+
+// IsConflict 1213 is a deadlock, mysql rolls the transaction back when it picks it as the victim
+func (mysqlDialect) IsConflict(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1213
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string {
+	return "postgres"
+}
+
+func (postgresDialect) BindType() int {
+	return sqlx.DOLLAR
+}
+
+func (postgresDialect) Quote(identifier string) string {
+	return quoteWith(identifier, `"`)
+}
+
+func (postgresDialect) Paginate(limit, offset int) (string, []interface{}) {
+	return paginate(limit, offset, "")
+}
+
+func (d postgresDialect) Upsert(conflict, columns []string) string {
+	return onConflict(d, conflict, columns)
+}
+
+func (d postgresDialect) JSONExtract(column, key string) string {
+	return d.Quote(column) + "->>'" + key + "'"
+}
+
+func (postgresDialect) JSONType() string {
+	return "jsonb"
+}
+
+// DateTimeType a plain timestamp drops the offset lib/pq sends, so times from different zones wouldn't compare correctly
+func (postgresDialect) DateTimeType() string {
+	return "timestamptz"
+}
+
+// IsConflict 40001 is a serialization failure and 40P01 a deadlock
+func (postgresDialect) IsConflict(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && (pqErr.Code == "40001" || pqErr.Code == "40P01")
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string {
+	return "sqlite3"
+}
+
+func (sqliteDialect) BindType() int {
+	return sqlx.QUESTION
+}
+
+func (sqliteDialect) Quote(identifier string) string {
+	return quoteWith(identifier, `"`)
+}
+
+// Paginate a negative LIMIT is no limit at all in sqlite
+func (sqliteDialect) Paginate(limit, offset int) (string, []interface{}) {
+	return paginate(limit, offset, "-1")
+}
+
+func (d sqliteDialect) Upsert(conflict, columns []string) string {
+	return onConflict(d, conflict, columns)
+}
+
+func (d sqliteDialect) JSONExtract(column, key string) string {
+	return "json_extract(" + d.Quote(column) + ", '$." + key + "')"
+}
+
+// JSONType sqlite has no JSON type, the JSON functions work on text
+func (sqliteDialect) JSONType() string {
+	return "text"
+}
+
+func (sqliteDialect) DateTimeType() string {
+	return "datetime"
+}
+
+// IsConflict sqlite locks the whole database, so a concurrent writer shows up as it being busy or locked
+func (sqliteDialect) IsConflict(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+}
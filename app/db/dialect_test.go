@@ -0,0 +1,70 @@
+package db
+
+import (
+	"github.com/jamesineda/reschedular/app/models"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type DialectTestSuite struct {
+	suite.Suite
+}
+
+func (suite *DialectTestSuite) Test_DialectFor() {
+	suite.Run("supported drivers", func() {
+		for driver, name := range map[string]string{"mysql": "mysql", "postgres": "postgres", "sqlite3": "sqlite3", "fake": "sqlite3"} {
+			dialect, err := DialectFor(driver)
+			suite.Require().NoError(err)
+			suite.Equal(name, dialect.Name())
+		}
+	})
+
+	suite.Run("unsupported drivers", func() {
+		_, err := DialectFor("oracle")
+		suite.EqualError(err, `unsupported database driver "oracle"`)
+	})
+}
+
+func (suite *DialectTestSuite) Test_Quote() {
+	suite.Equal("`scheduled_questionnaires`.`id`", mysqlDialect{}.Quote("scheduled_questionnaires.id"))
+	suite.Equal(`"scheduled_questionnaires"."id"`, postgresDialect{}.Quote("scheduled_questionnaires.id"))
+	suite.Equal(`"we""ird"`, sqliteDialect{}.Quote(`we"ird`))
+}
+
+func (suite *DialectTestSuite) Test_columnTypes() {
+	suite.Equal("datetime", columnTypes(mysqlDialect{}).Replace("{{datetime}}"))
+	suite.Equal("timestamptz", columnTypes(postgresDialect{}).Replace("{{datetime}}"))
+	suite.Equal("datetime", columnTypes(sqliteDialect{}).Replace("{{datetime}}"))
+}
+
+func (suite *DialectTestSuite) Test_getUpsertQuery() {
+	suite.Run("mysql", func() {
+		query, err := getUpsertQuery(mysqlDialect{}, &models.Participant{}, nil)
+		suite.Require().NoError(err)
+		suite.Equal("INSERT INTO `participants` ( `id`,`name`,`time_zone` ) VALUES ( :id,:name,:time_zone ) ON DUPLICATE KEY UPDATE "+
+			"`name`=VALUES(`name`),`time_zone`=VALUES(`time_zone`)", query)
+	})
+
+	suite.Run("postgres", func() {
+		query, err := getUpsertQuery(postgresDialect{}, &models.Participant{}, nil)
+		suite.Require().NoError(err)
+		suite.Equal(`INSERT INTO "participants" ( "id","name","time_zone" ) VALUES ( :id,:name,:time_zone ) ON CONFLICT ("id") `+
+			`DO UPDATE SET "name"=excluded."name","time_zone"=excluded."time_zone"`, query)
+	})
+
+	suite.Run("when every column is part of the conflict", func() {
+		query, err := getUpsertQuery(sqliteDialect{}, &models.Participant{}, []string{"id", "name", "time_zone"})
+		suite.Require().NoError(err)
+		suite.Equal(`INSERT INTO "participants" ( "id","name","time_zone" ) VALUES ( :id,:name,:time_zone ) `+
+			`ON CONFLICT ("id","name","time_zone") DO NOTHING`, query)
+	})
+
+	suite.Run("unknown conflict columns", func() {
+		_, err := getUpsertQuery(sqliteDialect{}, &models.Participant{}, []string{"email"})
+		suite.EqualError(err, "participants has no column email to upsert on")
+	})
+}
+
+func TestDialectTestSuite(t *testing.T) {
+	suite.Run(t, new(DialectTestSuite))
+}
@@ -0,0 +1,8 @@
+package db
+
+// the drivers for every supported Dialect, sqlx.Connect looks them up by DatabaseConfig.Driver
+import (
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
@@ -1,29 +1,109 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
+	"fmt"
+	"reflect"
 )
 
 type FakeSQLX struct {
 	GetReturn    interface{}
 	SelectReturn interface{}
+	// Commits and Rollbacks count how each transaction begun on the fake was finished
+	Commits   int
+	Rollbacks int
+	// PingErr is returned by PingContext
+	PingErr error
+	// ExecErrs and CommitErrs are returned by each Exec (or NamedExec) and Commit in turn, until they run out
+	ExecErrs   []error
+	CommitErrs []error
+}
+
+// nextErr takes the next error off errs
+func nextErr(errs *[]error) error {
+	if len(*errs) == 0 {
+		return nil
+	}
+	err := (*errs)[0]
+	*errs = (*errs)[1:]
+	return err
 }
 
 func NewSetFakeSQLX(get interface{}, selReturn interface{}) *FakeSQLX {
 	return &FakeSQLX{GetReturn: get, SelectReturn: selReturn}
 }
 
+// Get copies GetReturn into dest, which is left alone when GetReturn hasn't been set
 func (f *FakeSQLX) Get(dest interface{}, query string, args ...interface{}) error {
-	dest = f.GetReturn
-	return nil
+	return fakeScan(dest, f.GetReturn)
 }
 
+// Select copies SelectReturn into dest, which is left alone when SelectReturn hasn't been set
 func (f *FakeSQLX) Select(dest interface{}, query string, args ...interface{}) error {
-	dest = f.SelectReturn
-	return nil
+	return fakeScan(dest, f.SelectReturn)
 }
 
+// NamedExec nothing is kept, use a MemoryClient when the rows need reading back
 func (f *FakeSQLX) NamedExec(query string, arg interface{}) (sql.Result, error) {
+	if err := nextErr(&f.ExecErrs); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (f *FakeSQLX) Exec(query string, args ...interface{}) (sql.Result, error) {
+	if err := nextErr(&f.ExecErrs); err != nil {
+		return nil, err
+	}
 	return driver.RowsAffected(1), nil
 }
+
+func (f *FakeSQLX) PingContext(ctx context.Context) error {
+	return f.PingErr
+}
+
+func (f *FakeSQLX) BeginTransaction(ctx context.Context, opts *sql.TxOptions) (SQLXTx, error) {
+	return &FakeSQLXTx{f}, nil
+}
+
+// FakeSQLXTx runs everything against the parent FakeSQLX, commit and rollback are only counted
+type FakeSQLXTx struct {
+	*FakeSQLX
+}
+
+func (f *FakeSQLXTx) Commit() error {
+	if err := nextErr(&f.CommitErrs); err != nil {
+		return err
+	}
+	f.Commits++
+	return nil
+}
+
+func (f *FakeSQLXTx) Rollback() error {
+	f.Rollbacks++
+	return nil
+}
+
+// fakeScan ret can either be the value itself, or a pointer to it
+func fakeScan(dest interface{}, ret interface{}) error {
+	if ret == nil {
+		return nil
+	}
+
+	out := reflect.ValueOf(dest)
+	if out.Kind() != reflect.Ptr || out.IsNil() {
+		return fmt.Errorf("expected a pointer to scan into, got %T", dest)
+	}
+
+	v := reflect.ValueOf(ret)
+	if !v.Type().AssignableTo(out.Elem().Type()) && v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if !v.Type().AssignableTo(out.Elem().Type()) {
+		return fmt.Errorf("can't scan %T into %T", ret, dest)
+	}
+	out.Elem().Set(v)
+	return nil
+}
@@ -0,0 +1,193 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+const (
+	opEq        = "="
+	opNotEq     = "!="
+	opLt        = "<"
+	opLte       = "<="
+	opGt        = ">"
+	opGte       = ">="
+	opIn        = "IN"
+	opIsNull    = "IS NULL"
+	opIsNotNull = "IS NOT NULL"
+	opBetween   = "BETWEEN"
+	opLike      = "LIKE"
+	// opJSONEq compares a key within a JSON column, how it's written depends on the dialect
+	opJSONEq = "JSON ="
+)
+
+// operatorArgs the allow-list of operators, along with how many values each one binds. IN binds at least one value,
+// which is marked with -1
+var operatorArgs = map[string]int{
+	opEq:        1,
+	opNotEq:     1,
+	opLt:        1,
+	opLte:       1,
+	opGt:        1,
+	opGte:       1,
+	opIn:        -1,
+	opIsNull:    0,
+	opIsNotNull: 0,
+	opBetween:   2,
+	opLike:      1,
+	opJSONEq:    1,
+}
+
+// columnName a plain or table qualified column, anything else is refused rather than written into the query
+var columnName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// jsonKey the keys JSONEq can look up, they're written into the query as part of the JSON path
+var jsonKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Filter a single condition of a WHERE clause, built with one of Eq, NotEq, Lt, Lte, Gt, Gte, In, IsNull, IsNotNull,
+// Between, Like or JSONEq. Every value is bound as a parameter, none of them are written into the query itself
+type Filter struct {
+	Column   string
+	Operator string
+	Args     []interface{}
+	// Key is only used by JSONEq
+	Key string
+}
+
+// Filters are joined together with AND
+type Filters []Filter
+
+// Eq a nil value matches NULL columns, since = NULL never matches anything
+func Eq(column string, value interface{}) Filter {
+	if value == nil {
+		return IsNull(column)
+	}
+	return Filter{Column: column, Operator: opEq, Args: []interface{}{value}}
+}
+
+// NotEq a nil value matches columns that aren't NULL
+func NotEq(column string, value interface{}) Filter {
+	if value == nil {
+		return IsNotNull(column)
+	}
+	return Filter{Column: column, Operator: opNotEq, Args: []interface{}{value}}
+}
+
+func Lt(column string, value interface{}) Filter {
+	return Filter{Column: column, Operator: opLt, Args: []interface{}{value}}
+}
+
+func Lte(column string, value interface{}) Filter {
+	return Filter{Column: column, Operator: opLte, Args: []interface{}{value}}
+}
+
+func Gt(column string, value interface{}) Filter {
+	return Filter{Column: column, Operator: opGt, Args: []interface{}{value}}
+}
+
+func Gte(column string, value interface{}) Filter {
+	return Filter{Column: column, Operator: opGte, Args: []interface{}{value}}
+}
+
+// In values can either be a slice, e.g. []string{"a", "b"}, or the values themselves
+func In(column string, values ...interface{}) Filter {
+	var args []interface{}
+	for _, value := range values {
+		v := reflect.ValueOf(value)
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+			for i := 0; i < v.Len(); i++ {
+				args = append(args, v.Index(i).Interface())
+			}
+			continue
+		}
+		args = append(args, value)
+	}
+	return Filter{Column: column, Operator: opIn, Args: args}
+}
+
+func IsNull(column string) Filter {
+	return Filter{Column: column, Operator: opIsNull}
+}
+
+func IsNotNull(column string) Filter {
+	return Filter{Column: column, Operator: opIsNotNull}
+}
+
+// Between is inclusive of both ends
+func Between(column string, from, to interface{}) Filter {
+	return Filter{Column: column, Operator: opBetween, Args: []interface{}{from, to}}
+}
+
+func Like(column string, pattern string) Filter {
+	return Filter{Column: column, Operator: opLike, Args: []interface{}{pattern}}
+}
+
+// JSONEq matches rows where the top level key of a JSON column equals value, compared as text
+func JSONEq(column, key string, value interface{}) Filter {
+	return Filter{Column: column, Operator: opJSONEq, Key: key, Args: []interface{}{value}}
+}
+
+// Validate checks the column name, the operator, and that it has the right number of values
+func (f Filter) Validate() error {
+	if !columnName.MatchString(f.Column) {
+		return fmt.Errorf("invalid filter column %q", f.Column)
+	}
+
+	want, ok := operatorArgs[f.Operator]
+	if !ok {
+		return fmt.Errorf("unsupported filter operator %q on %s", f.Operator, f.Column)
+	}
+
+	switch {
+	case f.Operator == opJSONEq && !jsonKey.MatchString(f.Key):
+		return fmt.Errorf("invalid JSON key %q on %s", f.Key, f.Column)
+	case want < 0 && len(f.Args) == 0:
+		return fmt.Errorf("%s filter on %s needs at least one value", f.Operator, f.Column)
+	case want >= 0 && len(f.Args) != want:
+		return fmt.Errorf("%s filter on %s takes %d value(s), got %d", f.Operator, f.Column, want, len(f.Args))
+	}
+	return nil
+}
+
+// render the condition with a ? placeholder per value, these are rebound for the driver once the query is built
+func (f Filter) render(d Dialect) string {
+	column := d.Quote(f.Column)
+	switch f.Operator {
+	case opIsNull, opIsNotNull:
+		return column + " " + f.Operator
+	case opBetween:
+		return column + " " + f.Operator + " ? AND ?"
+	case opIn:
+		return column + " " + f.Operator + " (" + strings.TrimSuffix(strings.Repeat("?,", len(f.Args)), ",") + ")"
+	case opJSONEq:
+		return d.JSONExtract(f.Column, f.Key) + " = ?"
+	default:
+		return column + " " + f.Operator + " ?"
+	}
+}
+
+// Values every filter's values in the order their placeholders appear in the query
+func (f Filters) Values() (values []interface{}) {
+	for _, filter := range f {
+		values = append(values, filter.Args...)
+	}
+	return
+}
+
+// generateWhereClause returns an empty string when there aren't any filters, otherwise the clause with a leading space
+func generateWhereClause(d Dialect, filters Filters) (string, error) {
+	conditions := make([]string, 0, len(filters))
+	for _, filter := range filters {
+		if err := filter.Validate(); err != nil {
+			return "", err
+		}
+		conditions = append(conditions, filter.render(d))
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " " + where_ + " " + strings.Join(conditions, " "+and+" "), nil
+}
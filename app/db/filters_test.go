@@ -0,0 +1,111 @@
+package db
+
+import (
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type FiltersTestSuite struct {
+	suite.Suite
+}
+
+func (suite *FiltersTestSuite) Test_generateWhereClause() {
+	from := time.Date(2022, 7, 18, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2022, 7, 19, 0, 0, 0, 0, time.UTC)
+
+	suite.Run("every value is bound as a parameter", func() {
+		filters := Filters{
+			In("id", []string{"A", "B", "C"}),
+			Between("scheduled_at", from, to),
+			Like("name", "hair%"),
+			IsNull("sent_at"),
+			Gte("max_attempts", 2),
+		}
+
+		where, err := generateWhereClause(mysqlDialect{}, filters)
+		suite.Require().NoError(err)
+		suite.Equal(" WHERE `id` IN (?,?,?) AND `scheduled_at` BETWEEN ? AND ? AND `name` LIKE ? AND `sent_at` IS NULL AND "+
+			"`max_attempts` >= ?", where)
+		suite.Equal([]interface{}{"A", "B", "C", from, to, "hair%", 2}, filters.Values())
+	})
+
+	suite.Run("values that look like SQL stay out of the query", func() {
+		filters := Filters{In("id", "A') OR ('1'='1")}
+		where, err := generateWhereClause(mysqlDialect{}, filters)
+		suite.Require().NoError(err)
+		suite.Equal(" WHERE `id` IN (?)", where)
+	})
+
+	suite.Run("nil equality becomes IS NULL", func() {
+		where, err := generateWhereClause(mysqlDialect{}, Filters{Eq("sent_at", nil), NotEq("failed_at", nil)})
+		suite.Require().NoError(err)
+		suite.Equal(" WHERE `sent_at` IS NULL AND `failed_at` IS NOT NULL", where)
+	})
+
+	suite.Run("no filters", func() {
+		where, err := generateWhereClause(mysqlDialect{}, nil)
+		suite.Require().NoError(err)
+		suite.Equal("", where)
+	})
+}
+
+func (suite *FiltersTestSuite) Test_Validate() {
+	suite.Run("unsupported operators are refused", func() {
+		_, err := generateWhereClause(mysqlDialect{}, Filters{{Column: "id", Operator: "= 1 OR 1 =", Args: []interface{}{1}}})
+		suite.EqualError(err, `unsupported filter operator "= 1 OR 1 =" on id`)
+	})
+
+	suite.Run("column names can't contain SQL", func() {
+		_, err := generateWhereClause(mysqlDialect{}, Filters{Eq("id = id OR id", "A")})
+		suite.EqualError(err, `invalid filter column "id = id OR id"`)
+	})
+
+	suite.Run("IN needs at least one value", func() {
+		_, err := generateWhereClause(mysqlDialect{}, Filters{In("id", []string{})})
+		suite.EqualError(err, "IN filter on id needs at least one value")
+	})
+
+	suite.Run("the number of values has to match the operator", func() {
+		_, err := generateWhereClause(mysqlDialect{}, Filters{{Column: "scheduled_at", Operator: "BETWEEN", Args: []interface{}{1}}})
+		suite.EqualError(err, "BETWEEN filter on scheduled_at takes 2 value(s), got 1")
+	})
+}
+
+func (suite *FiltersTestSuite) Test_JSONEq() {
+	filters := Filters{JSONEq("payload", "participant_id", "PARTICIPANT1")}
+
+	suite.Run("each dialect reads the key its own way", func() {
+		for dialect, expected := range map[Dialect]string{
+			mysqlDialect{}:    " WHERE JSON_UNQUOTE(JSON_EXTRACT(`payload`, '$.participant_id')) = ?",
+			postgresDialect{}: ` WHERE "payload"->>'participant_id' = ?`,
+			sqliteDialect{}:   ` WHERE json_extract("payload", '$.participant_id') = ?`,
+		} {
+			where, err := generateWhereClause(dialect, filters)
+			suite.Require().NoError(err)
+			suite.Equal(expected, where, dialect.Name())
+		}
+	})
+
+	suite.Run("keys are checked before they're written into the query", func() {
+		_, err := generateWhereClause(sqliteDialect{}, Filters{JSONEq("payload", "id') OR ('1", "A")})
+		suite.EqualError(err, `invalid JSON key "id') OR ('1" on payload`)
+	})
+}
+
+func (suite *FiltersTestSuite) Test_rebind() {
+	suite.Run("postgres placeholders are numbered", func() {
+		conn := &DatabaseConn{dialect: postgresDialect{}}
+		suite.Equal("SELECT id FROM participants WHERE id IN ($1,$2) AND name = $3",
+			conn.rebind("SELECT id FROM participants WHERE id IN (?,?) AND name = ?"))
+	})
+
+	suite.Run("mysql keeps question marks", func() {
+		conn := &DatabaseConn{dialect: mysqlDialect{}}
+		suite.Equal("SELECT id FROM participants WHERE id = ?", conn.rebind("SELECT id FROM participants WHERE id = ?"))
+	})
+}
+
+func TestFiltersTestSuite(t *testing.T) {
+	suite.Run(t, new(FiltersTestSuite))
+}
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	orderBy = "ORDER BY"
+	asc     = "ASC"
+	desc    = "DESC"
+	or      = "OR"
+)
+
+// Order a column to sort GetList results by
+type Order struct {
+	Column     string
+	Descending bool
+}
+
+func Asc(column string) Order {
+	return Order{Column: column}
+}
+
+func Desc(column string) Order {
+	return Order{Column: column, Descending: true}
+}
+
+// ListOptions how GetList orders and pages its results. After is a keyset cursor, the OrderBy values of the last row of
+// the previous page, so the next page starts straight after it without the database having to skip over an offset
+type ListOptions struct {
+	OrderBy []Order
+	Limit   int
+	Offset  int
+	After   []interface{}
+}
+
+type ListOption func(o *ListOptions)
+
+func OrderBy(orders ...Order) ListOption {
+	return func(o *ListOptions) {
+		o.OrderBy = append(o.OrderBy, orders...)
+	}
+}
+
+func Limit(n int) ListOption {
+	return func(o *ListOptions) {
+		o.Limit = n
+	}
+}
+
+func Offset(n int) ListOption {
+	return func(o *ListOptions) {
+		o.Offset = n
+	}
+}
+
+// After takes one value per OrderBy column, in the same order
+func After(values ...interface{}) ListOption {
+	return func(o *ListOptions) {
+		o.After = values
+	}
+}
+
+func NewListOptions(opts ...ListOption) *ListOptions {
+	o := &ListOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func (o *ListOptions) Validate() error {
+	for _, order := range o.OrderBy {
+		if !columnName.MatchString(order.Column) {
+			return fmt.Errorf("invalid order by column %q", order.Column)
+		}
+	}
+
+	switch {
+	case o.Limit < 0:
+		return fmt.Errorf("limit can't be negative, got %d", o.Limit)
+	case o.Offset < 0:
+		return fmt.Errorf("offset can't be negative, got %d", o.Offset)
+	case len(o.After) > 0 && len(o.After) != len(o.OrderBy):
+		return fmt.Errorf("keyset cursor has %d value(s), but results are ordered by %d column(s)", len(o.After), len(o.OrderBy))
+	}
+	return nil
+}
+
+// keysetCondition rows that sort after the cursor, e.g. for a ASC, b DESC: (a > ? OR (a = ? AND b < ?))
+func (o *ListOptions) keysetCondition(d Dialect) (string, []interface{}) {
+	if len(o.After) == 0 {
+		return "", nil
+	}
+
+	var branches []string
+	var args []interface{}
+	for i, order := range o.OrderBy {
+		var terms []string
+		for j := 0; j < i; j++ {
+			terms = append(terms, d.Quote(o.OrderBy[j].Column)+" = ?")
+			args = append(args, o.After[j])
+		}
+
+		comparison := ">"
+		if order.Descending {
+			comparison = "<"
+		}
+		terms = append(terms, d.Quote(order.Column)+" "+comparison+" ?")
+		args = append(args, o.After[i])
+
+		branch := strings.Join(terms, " "+and+" ")
+		if len(terms) > 1 {
+			branch = "(" + branch + ")"
+		}
+		branches = append(branches, branch)
+	}
+	return "(" + strings.Join(branches, " "+or+" ") + ")", args
+}
+
+// suffix the ORDER BY, LIMIT and OFFSET that go on the end of the query, with the values for LIMIT and OFFSET
+func (o *ListOptions) suffix(d Dialect) (string, []interface{}) {
+	var parts []string
+
+	if len(o.OrderBy) > 0 {
+		columns := make([]string, 0, len(o.OrderBy))
+		for _, order := range o.OrderBy {
+			direction := asc
+			if order.Descending {
+				direction = desc
+			}
+			columns = append(columns, d.Quote(order.Column)+" "+direction)
+		}
+		parts = append(parts, orderBy+" "+strings.Join(columns, ", "))
+	}
+
+	pagination, args := d.Paginate(o.Limit, o.Offset)
+	if pagination != "" {
+		parts = append(parts, pagination)
+	}
+
+	if len(parts) == 0 {
+		return "", nil
+	}
+	return " " + strings.Join(parts, " "), args
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"github.com/jamesineda/reschedular/app/models"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type ListOptionsTestSuite struct {
+	suite.Suite
+	TableName    string
+	SelectFields string
+}
+
+func (suite *ListOptionsTestSuite) SetupTest() {
+	suite.TableName, suite.SelectFields = getSelectOptions(mysqlDialect{}, models.Participant{})
+}
+
+func (suite *ListOptionsTestSuite) Test_generateSelectQuery() {
+	completedAt := time.Date(2022, 7, 18, 10, 0, 0, 0, time.UTC)
+
+	suite.Run("ordered by several columns, with a limit and offset", func() {
+		query, args, err := generateSelectQuery(mysqlDialect{}, suite.TableName, suite.SelectFields, Filters{Eq("participant_id", "PARTICIPANT1")},
+			NewListOptions(OrderBy(Desc("completed_at"), Asc("id")), Limit(10), Offset(20)))
+		suite.Require().NoError(err)
+		suite.Equal("SELECT "+suite.SelectFields+" FROM `participants` WHERE `participant_id` = ? ORDER BY `completed_at` DESC, `id` ASC LIMIT ? OFFSET ?", query)
+		suite.Equal([]interface{}{"PARTICIPANT1", 10, 20}, args)
+	})
+
+	suite.Run("keyset pagination picks up after the cursor", func() {
+		query, args, err := generateSelectQuery(mysqlDialect{}, suite.TableName, suite.SelectFields, Filters{Eq("participant_id", "PARTICIPANT1")},
+			NewListOptions(OrderBy(Desc("completed_at"), Asc("id")), After(completedAt, "RESULT1"), Limit(10)))
+		suite.Require().NoError(err)
+		suite.Equal("SELECT "+suite.SelectFields+" FROM `participants` WHERE `participant_id` = ? AND "+
+			"(`completed_at` < ? OR (`completed_at` = ? AND `id` > ?)) ORDER BY `completed_at` DESC, `id` ASC LIMIT ?", query)
+		suite.Equal([]interface{}{"PARTICIPANT1", completedAt, completedAt, "RESULT1", 10}, args)
+	})
+
+	suite.Run("keyset pagination without any filters", func() {
+		query, _, err := generateSelectQuery(mysqlDialect{}, suite.TableName, suite.SelectFields, nil,
+			NewListOptions(OrderBy(Asc("id")), After("RESULT1")))
+		suite.Require().NoError(err)
+		suite.Equal("SELECT "+suite.SelectFields+" FROM `participants` WHERE (`id` > ?) ORDER BY `id` ASC", query)
+	})
+}
+
+func (suite *ListOptionsTestSuite) Test_Paginate() {
+	suite.Run("an offset without a limit", func() {
+		for dialect, expected := range map[Dialect]string{
+			mysqlDialect{}:    "LIMIT 18446744073709551615 OFFSET ?",
+			postgresDialect{}: "OFFSET ?",
+			sqliteDialect{}:   "LIMIT -1 OFFSET ?",
+		} {
+			pagination, args := dialect.Paginate(0, 20)
+			suite.Equal(expected, pagination, dialect.Name())
+			suite.Equal([]interface{}{20}, args)
+		}
+	})
+
+	suite.Run("a limit and an offset", func() {
+		pagination, args := postgresDialect{}.Paginate(10, 20)
+		suite.Equal("LIMIT ? OFFSET ?", pagination)
+		suite.Equal([]interface{}{10, 20}, args)
+	})
+}
+
+func (suite *ListOptionsTestSuite) Test_Validate() {
+	suite.Run("order by columns are checked", func() {
+		suite.EqualError(NewListOptions(OrderBy(Asc("id; DROP TABLE participants"))).Validate(),
+			`invalid order by column "id; DROP TABLE participants"`)
+	})
+
+	suite.Run("the cursor needs a value per order by column", func() {
+		suite.EqualError(NewListOptions(OrderBy(Asc("completed_at"), Asc("id")), After("RESULT1")).Validate(),
+			"keyset cursor has 1 value(s), but results are ordered by 2 column(s)")
+	})
+
+	suite.Run("negative limits", func() {
+		suite.EqualError(NewListOptions(Limit(-1)).Validate(), "limit can't be negative, got -1")
+	})
+}
+
+func TestListOptionsTestSuite(t *testing.T) {
+	suite.Run(t, new(ListOptionsTestSuite))
+}
@@ -0,0 +1,889 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryCreateAllAttempts how many times CreateAll is run when it conflicts with a concurrent write
+const memoryCreateAllAttempts = 3
+
+// MemoryClient a Client that keeps its rows in memory rather than a database, reading and writing structs by the same
+// db tags as DatabaseConn. It's meant for tests, so that handlers can be run end-to-end without a database
+type MemoryClient struct {
+	mu     *sync.Mutex
+	tables map[string]*memoryTable
+	// root is set on the Tx handed out by BeginTx. A transaction works on a copy of root's tables, the ones it wrote to
+	// replace root's when it's committed. If any of them were written to outside of the transaction in the meantime,
+	// the commit fails with a *ConflictError instead, so nothing written outside of it is lost
+	root *MemoryClient
+	// versions each table's version when the transaction began
+	versions map[string]int
+	done     bool
+}
+
+// memoryTable rows are held as addressable struct values, in the order they were inserted. version goes up with every
+// write, so a transaction can tell whether a table has changed since it began
+type memoryTable struct {
+	rowType reflect.Type
+	rows    []reflect.Value
+	version int
+}
+
+func NewMemoryClient() *MemoryClient {
+	return &MemoryClient{mu: &sync.Mutex{}, tables: map[string]*memoryTable{}}
+}
+
+func (m *MemoryClient) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *MemoryClient) GetById(id string, table interface{}) (interface{}, error) {
+	dest, err := structValue(table)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, err := m.table(table, false)
+	if err != nil {
+		return nil, err
+	}
+	row, _, err := t.byId(id)
+	if err != nil {
+		return nil, err
+	}
+	dest.Set(row)
+	return table, nil
+}
+
+// GetList rows is a pointer to a slice of structs, or of pointers to them. Without an OrderBy, rows come back in the
+// order they were inserted
+func (m *MemoryClient) GetList(rows interface{}, filters Filters, opts ...ListOption) error {
+	slice := reflect.ValueOf(rows)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("expected a pointer to a slice to list into, got %T", rows)
+	}
+	options := NewListOptions(opts...)
+	if err := options.Validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, err := m.table(rows, false)
+	if err != nil {
+		return err
+	}
+	matched, err := t.filter(filters)
+	if err != nil {
+		return err
+	}
+	if matched, err = options.apply(matched); err != nil {
+		return err
+	}
+
+	sliceType := slice.Elem().Type()
+	list := reflect.Zero(sliceType)
+	for _, row := range matched {
+		item := reflect.New(row.Type())
+		item.Elem().Set(row)
+		if sliceType.Elem().Kind() == reflect.Ptr {
+			list = reflect.Append(list, item)
+		} else {
+			list = reflect.Append(list, item.Elem())
+		}
+	}
+	slice.Elem().Set(list)
+	return nil
+}
+
+func (m *MemoryClient) Count(table interface{}, filters Filters) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, err := m.table(table, false)
+	if err != nil {
+		return 0, err
+	}
+	matched, err := t.filter(filters)
+	return len(matched), err
+}
+
+func (m *MemoryClient) Exists(table interface{}, filters Filters) (bool, error) {
+	count, err := m.Count(table, filters)
+	return count > 0, err
+}
+
+// Max with no matching rows, dest is scanned a NULL, the same as it would be from the database
+func (m *MemoryClient) Max(table interface{}, column string, filters Filters, dest interface{}) error {
+	if !columnName.MatchString(column) {
+		return fmt.Errorf("invalid column %q to aggregate", column)
+	}
+	if _, err := columnIndex(table, column); err != nil {
+		return err
+	}
+	out := reflect.ValueOf(dest)
+	if out.Kind() != reflect.Ptr || out.IsNil() {
+		return fmt.Errorf("expected a pointer to scan the max into, got %T", dest)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, err := m.table(table, false)
+	if err != nil {
+		return err
+	}
+	matched, err := t.filter(filters)
+	if err != nil {
+		return err
+	}
+
+	var max interface{}
+	for _, row := range matched {
+		value := memoryValue(row.FieldByIndex(t.columns()[column]).Interface())
+		if value == nil {
+			continue
+		}
+		if max == nil {
+			max = value
+			continue
+		}
+		c, err := compareValues(value, max)
+		if err != nil {
+			return fmt.Errorf("failed to aggregate %s: %v", column, err)
+		}
+		if c > 0 {
+			max = value
+		}
+	}
+	return assignValue(out.Elem(), max)
+}
+
+// Create fails when a row with the same id already exists, as the primary key would
+func (m *MemoryClient) Create(object interface{}) error {
+	row, err := structValue(object)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, err := m.table(object, true)
+	if err != nil {
+		return err
+	}
+	return t.insert(row)
+}
+
+// CreateAll inserts every object within a single transaction, so either all of the rows are written or none are. It's
+// retried if a concurrent write to one of the tables gets in first, as inserting can't conflict with it any other way
+func (m *MemoryClient) CreateAll(objects ...interface{}) error {
+	return WithTxRetry(context.Background(), m, nil, memoryCreateAllAttempts, func(tx Client) error {
+		for _, object := range objects {
+			if err := tx.Create(object); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Update writes the given fields of object back to its row, matched on id. With no fields, every column is written
+func (m *MemoryClient) Update(object interface{}, fields ...string) error {
+	source, err := structValue(object)
+	if err != nil {
+		return err
+	}
+
+	columns := columnsOf(source.Type())
+	if _, ok := columns["id"]; !ok {
+		return fmt.Errorf("%s has no id column to update by", getTableName(object))
+	}
+	if len(fields) == 0 {
+		for _, tag := range getTags(object, "db") {
+			if tag != "id" {
+				fields = append(fields, tag)
+			}
+		}
+	}
+	for _, field := range fields {
+		if _, ok := columns[field]; !ok || field == "id" {
+			return fmt.Errorf("%s has no updatable column %s", getTableName(object), field)
+		}
+	}
+	if len(fields) == 0 {
+		return ErrNoChanges
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, err := m.table(object, false)
+	if err != nil {
+		return err
+	}
+	row, _, err := t.byId(source.FieldByIndex(columns["id"]).String())
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		row.FieldByIndex(columns[field]).Set(source.FieldByIndex(columns[field]))
+	}
+	t.version++
+	return nil
+}
+
+// UpdateWhere applies changes, keyed on column name, to every row matching the filters. It returns the number of rows
+// that were updated
+func (m *MemoryClient) UpdateWhere(table interface{}, filters Filters, changes map[string]interface{}) (int64, error) {
+	if len(changes) == 0 {
+		return 0, ErrNoChanges
+	}
+	for column := range changes {
+		if !columnName.MatchString(column) {
+			return 0, fmt.Errorf("invalid column %q to update", column)
+		}
+		if _, err := columnIndex(table, column); err != nil {
+			return 0, err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, err := m.table(table, false)
+	if err != nil {
+		return 0, err
+	}
+	matched, err := t.filter(filters)
+	if err != nil {
+		return 0, err
+	}
+
+	// each change is tried out on a scratch row first, so a bad value can't leave the rows partly updated
+	columns := t.columns()
+	scratch := reflect.New(t.rowType).Elem()
+	for column, value := range changes {
+		if err = assignValue(scratch.FieldByIndex(columns[column]), value); err != nil {
+			return 0, fmt.Errorf("failed to update %s: %v", column, err)
+		}
+	}
+	for _, row := range matched {
+		for column := range changes {
+			row.FieldByIndex(columns[column]).Set(scratch.FieldByIndex(columns[column]))
+		}
+	}
+	if len(matched) > 0 {
+		t.version++
+	}
+	return int64(len(matched)), nil
+}
+
+// Delete removes the object's row, matched on id
+func (m *MemoryClient) Delete(object interface{}) error {
+	source, err := structValue(object)
+	if err != nil {
+		return err
+	}
+	index, err := columnIndex(object, "id")
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, err := m.table(object, false)
+	if err != nil {
+		return err
+	}
+	_, i, err := t.byId(source.FieldByIndex(index).String())
+	if err != nil {
+		return err
+	}
+	t.rows = append(t.rows[:i], t.rows[i+1:]...)
+	t.version++
+	return nil
+}
+
+// Upsert inserts the object, or updates every other column of the row it conflicts with. conflict defaults to the id
+func (m *MemoryClient) Upsert(object interface{}, conflict ...string) error {
+	source, err := structValue(object)
+	if err != nil {
+		return err
+	}
+	if len(conflict) == 0 {
+		conflict = []string{"id"}
+	}
+
+	columns := columnsOf(source.Type())
+	inConflict := make(map[string]bool, len(conflict))
+	filters := make(Filters, 0, len(conflict))
+	for _, column := range conflict {
+		index, ok := columns[column]
+		if !ok {
+			return fmt.Errorf("%s has no column %s to upsert on", getTableName(object), column)
+		}
+		inConflict[column] = true
+		filters = append(filters, Eq(column, memoryValue(source.FieldByIndex(index).Interface())))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, err := m.table(object, true)
+	if err != nil {
+		return err
+	}
+	matched, err := t.filter(filters)
+	if err != nil {
+		return err
+	}
+	if len(matched) == 0 {
+		return t.insert(source)
+	}
+
+	for column, index := range columns {
+		if !inConflict[column] {
+			matched[0].FieldByIndex(index).Set(source.FieldByIndex(index))
+		}
+	}
+	t.version++
+	return nil
+}
+
+// BeginTx opts are ignored, every transaction works on its own copy of the rows
+func (m *MemoryClient) BeginTx(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+	if m.root != nil {
+		return nil, ErrAlreadyInTransaction
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tables := make(map[string]*memoryTable, len(m.tables))
+	versions := make(map[string]int, len(m.tables))
+	for name, t := range m.tables {
+		tables[name] = t.copy()
+		versions[name] = t.version
+	}
+	return &MemoryClient{mu: m.mu, tables: tables, root: m, versions: versions}, nil
+}
+
+// WithTx runs fn within a transaction, committing if it returns nil and rolling back otherwise. When called on a Client
+// that's already in a transaction, fn just joins it, and the outermost WithTx decides whether it's committed
+func (m *MemoryClient) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx Client) error) error {
+	if m.root != nil {
+		return fn(m)
+	}
+	return withTx(ctx, m, opts, fn)
+}
+
+func (m *MemoryClient) Commit() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.root == nil || m.done {
+		return sql.ErrTxDone
+	}
+	m.done = true
+
+	// a table that's missing from versions didn't exist when the transaction began, which is the same as version 0
+	var written []string
+	for name, t := range m.tables {
+		if t.version == m.versions[name] {
+			continue
+		}
+		if root, ok := m.root.tables[name]; ok && root.version != m.versions[name] {
+			return &ConflictError{Err: fmt.Errorf("%s was written to outside of the transaction while it was open", name)}
+		}
+		written = append(written, name)
+	}
+
+	for _, name := range written {
+		m.root.tables[name] = m.tables[name]
+	}
+	return nil
+}
+
+func (m *MemoryClient) Rollback() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.root == nil || m.done {
+		return sql.ErrTxDone
+	}
+	m.done = true
+	return nil
+}
+
+// table the table obj's rows are kept in, which is nil when nothing has been inserted into it and create isn't set
+func (m *MemoryClient) table(obj interface{}, create bool) (*memoryTable, error) {
+	rowType, err := structType(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	name := getTableName(obj)
+	t, ok := m.tables[name]
+	switch {
+	case !ok && create:
+		t = &memoryTable{rowType: rowType}
+		m.tables[name] = t
+	case !ok:
+		return &memoryTable{rowType: rowType}, nil
+	case t.rowType != rowType:
+		return nil, fmt.Errorf("%s holds %s rows, not %s", name, t.rowType, rowType)
+	}
+	return t, nil
+}
+
+func (t *memoryTable) copy() *memoryTable {
+	rows := make([]reflect.Value, 0, len(t.rows))
+	for _, row := range t.rows {
+		c := reflect.New(t.rowType).Elem()
+		c.Set(row)
+		rows = append(rows, c)
+	}
+	return &memoryTable{rowType: t.rowType, rows: rows, version: t.version}
+}
+
+func (t *memoryTable) columns() map[string][]int {
+	return columnsOf(t.rowType)
+}
+
+func (t *memoryTable) insert(source reflect.Value) error {
+	if index, ok := t.columns()["id"]; ok {
+		id := source.FieldByIndex(index).String()
+		if _, _, err := t.byId(id); err == nil {
+			return fmt.Errorf("duplicate id %q in %s", id, getTableName(reflect.New(t.rowType).Interface()))
+		}
+	}
+
+	row := reflect.New(t.rowType).Elem()
+	row.Set(source)
+	t.rows = append(t.rows, row)
+	t.version++
+	return nil
+}
+
+// byId sql.ErrNoRows when there isn't a row with the id
+func (t *memoryTable) byId(id string) (reflect.Value, int, error) {
+	index, ok := t.columns()["id"]
+	if !ok {
+		return reflect.Value{}, 0, fmt.Errorf("%s has no id column", t.rowType)
+	}
+	for i, row := range t.rows {
+		if row.FieldByIndex(index).String() == id {
+			return row, i, nil
+		}
+	}
+	return reflect.Value{}, 0, sql.ErrNoRows
+}
+
+// filter the rows matching every filter, these are the table's own rows rather than copies of them
+func (t *memoryTable) filter(filters Filters) ([]reflect.Value, error) {
+	columns := t.columns()
+	for _, filter := range filters {
+		if err := filter.Validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := columns[filter.Column]; !ok {
+			return nil, fmt.Errorf("%s has no column %s to filter on", t.rowType, filter.Column)
+		}
+	}
+
+	var matched []reflect.Value
+	for _, row := range t.rows {
+		match := true
+		for _, filter := range filters {
+			ok, err := filter.matches(memoryValue(row.FieldByIndex(columns[filter.Column]).Interface()))
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				match = false
+				break
+			}
+		}
+		if match {
+			matched = append(matched, row)
+		}
+	}
+	return matched, nil
+}
+
+// matches follows SQL's rules, so a NULL value only ever matches IS NULL
+func (f Filter) matches(value interface{}) (bool, error) {
+	switch f.Operator {
+	case opIsNull:
+		return value == nil, nil
+	case opIsNotNull:
+		return value != nil, nil
+	}
+	if value == nil {
+		return false, nil
+	}
+
+	switch f.Operator {
+	case opIn:
+		for _, arg := range f.Args {
+			if c, err := compareValues(value, memoryValue(arg)); err != nil || c == 0 {
+				return err == nil, err
+			}
+		}
+		return false, nil
+	case opBetween:
+		from, err := compareValues(value, memoryValue(f.Args[0]))
+		if err != nil {
+			return false, err
+		}
+		to, err := compareValues(value, memoryValue(f.Args[1]))
+		return from >= 0 && to <= 0, err
+	case opLike:
+		return like(value, f.Args[0])
+	case opJSONEq:
+		return jsonEq(value, f.Key, f.Args[0])
+	}
+
+	c, err := compareValues(value, memoryValue(f.Args[0]))
+	if err != nil {
+		return false, fmt.Errorf("failed to filter on %s: %v", f.Column, err)
+	}
+	switch f.Operator {
+	case opEq:
+		return c == 0, nil
+	case opNotEq:
+		return c != 0, nil
+	case opLt:
+		return c < 0, nil
+	case opLte:
+		return c <= 0, nil
+	case opGt:
+		return c > 0, nil
+	default:
+		return c >= 0, nil
+	}
+}
+
+// like % matches any number of characters and _ exactly one, the same as LIKE
+func like(value, pattern interface{}) (bool, error) {
+	text, ok := value.(string)
+	if !ok {
+		return false, fmt.Errorf("LIKE needs a text column, got %T", value)
+	}
+
+	var expr strings.Builder
+	for _, r := range fmt.Sprint(pattern) {
+		switch r {
+		case '%':
+			expr.WriteString(".*")
+		case '_':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return regexp.MatchString("^(?s)"+expr.String()+"$", text)
+}
+
+// jsonEq the key is compared as text, the same as JSONEq in the database
+func jsonEq(value interface{}, key string, want interface{}) (bool, error) {
+	text, ok := value.(string)
+	if !ok {
+		return false, fmt.Errorf("JSON filters need a JSON column, got %T", value)
+	}
+
+	var document map[string]interface{}
+	if err := json.Unmarshal([]byte(text), &document); err != nil {
+		return false, nil
+	}
+	got, ok := document[key]
+	if !ok || got == nil {
+		return false, nil
+	}
+	return fmt.Sprint(got) == fmt.Sprint(want), nil
+}
+
+// apply sorts, pages and applies the keyset cursor to rows, as the database would
+func (o *ListOptions) apply(rows []reflect.Value) ([]reflect.Value, error) {
+	if len(rows) == 0 {
+		return rows, nil
+	}
+
+	columns := columnsOf(rows[0].Type())
+	keys := make([][]int, 0, len(o.OrderBy))
+	for _, order := range o.OrderBy {
+		index, ok := columns[order.Column]
+		if !ok {
+			return nil, fmt.Errorf("%s has no column %s to order by", rows[0].Type(), order.Column)
+		}
+		keys = append(keys, index)
+	}
+
+	// compareRow orders a row against a tuple of values, one per OrderBy column
+	var err error
+	compareRow := func(row reflect.Value, values func(i int) interface{}) int {
+		for i, order := range o.OrderBy {
+			c, cErr := compareNullable(memoryValue(row.FieldByIndex(keys[i]).Interface()), values(i))
+			if cErr != nil {
+				err = cErr
+			}
+			if order.Descending {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return 0
+	}
+
+	sorted := append([]reflect.Value(nil), rows...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return compareRow(sorted[i], func(k int) interface{} {
+			return memoryValue(sorted[j].FieldByIndex(keys[k]).Interface())
+		}) < 0
+	})
+
+	if len(o.After) > 0 {
+		var after []reflect.Value
+		for _, row := range sorted {
+			if compareRow(row, func(k int) interface{} { return memoryValue(o.After[k]) }) > 0 {
+				after = append(after, row)
+			}
+		}
+		sorted = after
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Offset >= len(sorted) {
+		return nil, nil
+	}
+	sorted = sorted[o.Offset:]
+	if o.Limit > 0 && o.Limit < len(sorted) {
+		sorted = sorted[:o.Limit]
+	}
+	return sorted, nil
+}
+
+// structType the struct behind obj, which can be a pointer to one, or a slice of them
+func structType(obj interface{}) (reflect.Type, error) {
+	t := reflect.TypeOf(obj)
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("expected a struct with db tags, got %T", obj)
+	}
+	return t, nil
+}
+
+// structValue the struct obj points to
+func structValue(obj interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("expected a pointer to a struct with db tags, got %T", obj)
+	}
+	return v.Elem(), nil
+}
+
+// columnsOf the field index of each db tag
+func columnsOf(t reflect.Type) map[string][]int {
+	columns := make(map[string][]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if tag := strings.Split(t.Field(i).Tag.Get("db"), ",")[0]; tag != "" && tag != "-" {
+			columns[tag] = t.Field(i).Index
+		}
+	}
+	return columns
+}
+
+func columnIndex(table interface{}, column string) ([]int, error) {
+	t, err := structType(table)
+	if err != nil {
+		return nil, err
+	}
+	index, ok := columnsOf(t)[column]
+	if !ok {
+		return nil, fmt.Errorf("%s has no column %s", getTableName(table), column)
+	}
+	return index, nil
+}
+
+// memoryValue reduces a field or filter value down to what the database would compare, nil for NULL, int64, float64,
+// string, bool or time.Time
+func memoryValue(value interface{}) interface{} {
+	v := reflect.ValueOf(value)
+	for v.IsValid() && v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil
+	}
+
+	if valuer, ok := v.Interface().(driver.Valuer); ok {
+		inner, err := valuer.Value()
+		if err != nil || inner == nil {
+			return nil
+		}
+		v = reflect.ValueOf(inner)
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes())
+		}
+	}
+	return v.Interface()
+}
+
+// compareValues both values have been through memoryValue and neither is nil
+func compareValues(a, b interface{}) (int, error) {
+	switch x := a.(type) {
+	case int64:
+		switch y := b.(type) {
+		case int64:
+			return compareOrdered(x, y), nil
+		case float64:
+			return compareOrdered(float64(x), y), nil
+		}
+	case float64:
+		switch y := b.(type) {
+		case int64:
+			return compareOrdered(x, float64(y)), nil
+		case float64:
+			return compareOrdered(x, y), nil
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), nil
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			switch {
+			case x == y:
+				return 0, nil
+			case y:
+				return -1, nil
+			default:
+				return 1, nil
+			}
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			switch {
+			case x.Before(y):
+				return -1, nil
+			case x.After(y):
+				return 1, nil
+			default:
+				return 0, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("can't compare %T with %T", a, b)
+}
+
+// compareNullable NULLs sort before everything else, as they do in MySQL and SQLite
+func compareNullable(a, b interface{}) (int, error) {
+	switch {
+	case a == nil && b == nil:
+		return 0, nil
+	case a == nil:
+		return -1, nil
+	case b == nil:
+		return 1, nil
+	}
+	return compareValues(a, b)
+}
+
+func compareOrdered[T int64 | float64](x, y T) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// assignValue sets field to value, scanning it in when the field is a sql.Scanner, e.g. sql.NullString. A nil value
+// sets the field to its zero value
+func assignValue(field reflect.Value, value interface{}) error {
+	if scanner, ok := field.Addr().Interface().(sql.Scanner); ok {
+		return scanner.Scan(memoryValue(value))
+	}
+
+	if value = memoryValue(value); value == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+
+	target := field.Type()
+	if target.Kind() == reflect.Ptr {
+		target = target.Elem()
+	}
+	v := reflect.ValueOf(value)
+	switch {
+	case v.Type() == target:
+	case v.Kind() == reflect.Int64 && (isInt(target) || isFloat(target)), v.Kind() == reflect.Float64 && isFloat(target),
+		v.Kind() == reflect.String && target.Kind() == reflect.String, v.Kind() == reflect.Bool && target.Kind() == reflect.Bool:
+		v = v.Convert(target)
+	default:
+		return fmt.Errorf("can't assign %T to %s", value, field.Type())
+	}
+
+	if field.Kind() == reflect.Ptr {
+		p := reflect.New(target)
+		p.Elem().Set(v)
+		v = p
+	}
+	field.Set(v)
+	return nil
+}
+
+func isInt(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func isFloat(t reflect.Type) bool {
+	return t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64
+}
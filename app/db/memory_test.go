@@ -0,0 +1,332 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jamesineda/reschedular/app/models"
+	"github.com/jamesineda/reschedular/app/utils"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type MemoryClientTestSuite struct {
+	suite.Suite
+	Client *MemoryClient
+	Timer  utils.Timer
+}
+
+func (suite *MemoryClientTestSuite) SetupTest() {
+	suite.Client = NewMemoryClient()
+	suite.Timer = utils.NewFakeTimer(time.Date(2022, 7, 18, 10, 0, 0, 0, time.UTC))
+
+	now := suite.Timer.GetTimeNow()
+	suite.Require().NoError(suite.Client.CreateAll(
+		&models.ScheduledQuestionnaire{Id: "SCHEDULED1", QuestionnaireId: "QUESTIONNAIRE1", ParticipantId: "PARTICIPANT1",
+			ScheduledAt: now.Add(2 * time.Hour), Status: sql.NullString{Valid: true, String: "pending"}},
+		&models.ScheduledQuestionnaire{Id: "SCHEDULED2", QuestionnaireId: "QUESTIONNAIRE1", ParticipantId: "PARTICIPANT1",
+			ScheduledAt: now, Status: sql.NullString{Valid: true, String: "completed"}},
+		&models.ScheduledQuestionnaire{Id: "SCHEDULED3", QuestionnaireId: "QUESTIONNAIRE2", ParticipantId: "PARTICIPANT2",
+			ScheduledAt: now.Add(1 * time.Hour)},
+	))
+}
+
+func (suite *MemoryClientTestSuite) ids(rows models.ScheduledQuestionnaires) (ids []string) {
+	for _, row := range rows {
+		ids = append(ids, row.Id)
+	}
+	return
+}
+
+func (suite *MemoryClientTestSuite) Test_GetById() {
+	suite.Run("returns a copy of the row", func() {
+		row, err := suite.Client.GetById("SCHEDULED3", &models.ScheduledQuestionnaire{})
+		suite.Require().NoError(err)
+		scheduled := row.(*models.ScheduledQuestionnaire)
+		suite.Equal("PARTICIPANT2", scheduled.ParticipantId)
+
+		scheduled.ParticipantId = "PARTICIPANT3"
+		row, err = suite.Client.GetById("SCHEDULED3", &models.ScheduledQuestionnaire{})
+		suite.Require().NoError(err)
+		suite.Equal("PARTICIPANT2", row.(*models.ScheduledQuestionnaire).ParticipantId)
+	})
+
+	suite.Run("when the row doesn't exist", func() {
+		_, err := suite.Client.GetById("SCHEDULED4", &models.ScheduledQuestionnaire{})
+		suite.Equal(sql.ErrNoRows, err)
+
+		_, err = suite.Client.GetById("PARTICIPANT1", &models.Participant{})
+		suite.Equal(sql.ErrNoRows, err)
+	})
+}
+
+func (suite *MemoryClientTestSuite) Test_GetList() {
+	now := suite.Timer.GetTimeNow()
+
+	for name, tc := range map[string]struct {
+		filters Filters
+		want    []string
+	}{
+		"without filters, in the order they were inserted": {nil, []string{"SCHEDULED1", "SCHEDULED2", "SCHEDULED3"}},
+		"=":           {Filters{Eq("participant_id", "PARTICIPANT1")}, []string{"SCHEDULED1", "SCHEDULED2"}},
+		"!=":          {Filters{NotEq("status", "pending")}, []string{"SCHEDULED2"}},
+		"<":           {Filters{Lt("scheduled_at", now.Add(1*time.Hour))}, []string{"SCHEDULED2"}},
+		">":           {Filters{Gt("scheduled_at", now)}, []string{"SCHEDULED1", "SCHEDULED3"}},
+		"IN":          {Filters{In("id", []string{"SCHEDULED1", "SCHEDULED3", "SCHEDULED4"})}, []string{"SCHEDULED1", "SCHEDULED3"}},
+		"IS NULL":     {Filters{Eq("status", nil)}, []string{"SCHEDULED3"}},
+		"IS NOT NULL": {Filters{IsNotNull("status")}, []string{"SCHEDULED1", "SCHEDULED2"}},
+		"BETWEEN":     {Filters{Between("scheduled_at", now, now.Add(1*time.Hour))}, []string{"SCHEDULED2", "SCHEDULED3"}},
+		"LIKE":        {Filters{Like("questionnaire_id", "%NAIRE_")}, []string{"SCHEDULED1", "SCHEDULED2", "SCHEDULED3"}},
+		"AND":         {Filters{Eq("participant_id", "PARTICIPANT1"), Eq("status", "pending")}, []string{"SCHEDULED1"}},
+		"no matches":  {Filters{Eq("participant_id", "PARTICIPANT3")}, nil},
+	} {
+		suite.Run(name, func() {
+			var rows models.ScheduledQuestionnaires
+			suite.Require().NoError(suite.Client.GetList(&rows, tc.filters))
+			suite.Equal(tc.want, suite.ids(rows))
+		})
+	}
+
+	suite.Run("ordered and paged", func() {
+		var rows models.ScheduledQuestionnaires
+		suite.Require().NoError(suite.Client.GetList(&rows, nil, OrderBy(Desc("scheduled_at")), Limit(2)))
+		suite.Equal([]string{"SCHEDULED1", "SCHEDULED3"}, suite.ids(rows))
+
+		suite.Require().NoError(suite.Client.GetList(&rows, nil, OrderBy(Desc("scheduled_at")), Limit(2), Offset(2)))
+		suite.Equal([]string{"SCHEDULED2"}, suite.ids(rows))
+	})
+
+	suite.Run("NULLs sort first", func() {
+		var rows models.ScheduledQuestionnaires
+		suite.Require().NoError(suite.Client.GetList(&rows, nil, OrderBy(Asc("status"), Asc("id"))))
+		suite.Equal([]string{"SCHEDULED3", "SCHEDULED2", "SCHEDULED1"}, suite.ids(rows))
+	})
+
+	suite.Run("keyset cursor", func() {
+		var rows models.ScheduledQuestionnaires
+		suite.Require().NoError(suite.Client.GetList(&rows, nil, OrderBy(Asc("scheduled_at"), Asc("id")),
+			After(now, "SCHEDULED2")))
+		suite.Equal([]string{"SCHEDULED3", "SCHEDULED1"}, suite.ids(rows))
+	})
+
+	suite.Run("unknown columns are an error", func() {
+		var rows models.ScheduledQuestionnaires
+		suite.Error(suite.Client.GetList(&rows, Filters{Eq("name", "hair regrowth")}))
+		suite.Error(suite.Client.GetList(&rows, nil, OrderBy(Asc("name"))))
+	})
+
+	suite.Run("a table holds one type of row", func() {
+		type ScheduledQuestionnaire struct {
+			Id string `db:"id"`
+		}
+		var rows []ScheduledQuestionnaire
+		suite.Error(suite.Client.GetList(&rows, nil))
+	})
+}
+
+func (suite *MemoryClientTestSuite) Test_JSONEq() {
+	suite.Require().NoError(suite.Client.CreateAll(
+		&models.Questionnaire{Id: "QUESTIONNAIRE1", Questions: `{"kind": "hair", "version": 2}`},
+		&models.Questionnaire{Id: "QUESTIONNAIRE2", Questions: `{"kind": "skin"}`},
+	))
+
+	var rows models.Questionnaires
+	suite.Require().NoError(suite.Client.GetList(&rows, Filters{JSONEq("questions", "kind", "hair")}))
+	suite.Require().Len(rows, 1)
+	suite.Equal("QUESTIONNAIRE1", rows[0].Id)
+
+	suite.Require().NoError(suite.Client.GetList(&rows, Filters{JSONEq("questions", "version", 2)}))
+	suite.Len(rows, 1)
+}
+
+func (suite *MemoryClientTestSuite) Test_Aggregates() {
+	suite.Run("count", func() {
+		count, err := suite.Client.Count(&models.ScheduledQuestionnaire{}, Filters{Eq("participant_id", "PARTICIPANT1")})
+		suite.Require().NoError(err)
+		suite.Equal(2, count)
+
+		count, err = suite.Client.Count(&models.QuestionnaireResult{}, nil)
+		suite.Require().NoError(err)
+		suite.Equal(0, count)
+	})
+
+	suite.Run("exists", func() {
+		exists, err := suite.Client.Exists(&models.ScheduledQuestionnaire{}, Filters{Eq("status", "completed")})
+		suite.Require().NoError(err)
+		suite.True(exists)
+
+		exists, err = suite.Client.Exists(&models.ScheduledQuestionnaire{}, Filters{Eq("status", "cancelled")})
+		suite.Require().NoError(err)
+		suite.False(exists)
+	})
+
+	suite.Run("max", func() {
+		var latest sql.NullTime
+		suite.Require().NoError(suite.Client.Max(&models.ScheduledQuestionnaire{}, "scheduled_at", nil, &latest))
+		suite.Equal(sql.NullTime{Valid: true, Time: suite.Timer.GetTimeNow().Add(2 * time.Hour)}, latest)
+
+		suite.Require().NoError(suite.Client.Max(&models.ScheduledQuestionnaire{}, "scheduled_at",
+			Filters{Eq("participant_id", "PARTICIPANT3")}, &latest))
+		suite.False(latest.Valid)
+	})
+}
+
+func (suite *MemoryClientTestSuite) Test_Writes() {
+	suite.Run("ids are unique", func() {
+		err := suite.Client.Create(&models.ScheduledQuestionnaire{Id: "SCHEDULED1"})
+		suite.EqualError(err, `duplicate id "SCHEDULED1" in scheduled_questionnaires`)
+	})
+
+	suite.Run("update only writes the given fields", func() {
+		err := suite.Client.Update(&models.ScheduledQuestionnaire{Id: "SCHEDULED3", ParticipantId: "PARTICIPANT3",
+			Status: sql.NullString{Valid: true, String: "cancelled"}}, "status")
+		suite.Require().NoError(err)
+
+		row, err := suite.Client.GetById("SCHEDULED3", &models.ScheduledQuestionnaire{})
+		suite.Require().NoError(err)
+		suite.Equal("PARTICIPANT2", row.(*models.ScheduledQuestionnaire).ParticipantId)
+		suite.Equal("cancelled", row.(*models.ScheduledQuestionnaire).Status.String)
+
+		suite.Equal(sql.ErrNoRows, suite.Client.Update(&models.ScheduledQuestionnaire{Id: "SCHEDULED4"}))
+	})
+
+	suite.Run("update where", func() {
+		updated, err := suite.Client.UpdateWhere(&models.ScheduledQuestionnaire{}, Filters{Eq("participant_id", "PARTICIPANT1")},
+			map[string]interface{}{"status": "cancelled"})
+		suite.Require().NoError(err)
+		suite.Equal(int64(2), updated)
+
+		count, err := suite.Client.Count(&models.ScheduledQuestionnaire{}, Filters{Eq("status", "cancelled")})
+		suite.Require().NoError(err)
+		suite.Equal(3, count)
+
+		_, err = suite.Client.UpdateWhere(&models.ScheduledQuestionnaire{}, nil, map[string]interface{}{"scheduled_at": "tomorrow"})
+		suite.EqualError(err, "failed to update scheduled_at: can't assign string to time.Time")
+	})
+
+	suite.Run("upsert", func() {
+		suite.Require().NoError(suite.Client.Upsert(&models.Participant{Id: "PARTICIPANT1", Name: "Ann"}))
+		suite.Require().NoError(suite.Client.Upsert(&models.Participant{Id: "PARTICIPANT1", Name: "Anne"}))
+
+		var rows models.Participants
+		suite.Require().NoError(suite.Client.GetList(&rows, nil))
+		suite.Equal(models.Participants{{Id: "PARTICIPANT1", Name: "Anne"}}, rows)
+	})
+
+	suite.Run("delete", func() {
+		suite.Require().NoError(suite.Client.Delete(&models.ScheduledQuestionnaire{Id: "SCHEDULED2"}))
+		suite.Equal(sql.ErrNoRows, suite.Client.Delete(&models.ScheduledQuestionnaire{Id: "SCHEDULED2"}))
+
+		count, err := suite.Client.Count(&models.ScheduledQuestionnaire{}, nil)
+		suite.Require().NoError(err)
+		suite.Equal(2, count)
+	})
+}
+
+func (suite *MemoryClientTestSuite) Test_WithTx() {
+	suite.Run("nothing is written when fn fails", func() {
+		err := suite.Client.WithTx(context.Background(), nil, func(tx Client) error {
+			if err := tx.Create(&models.Participant{Id: "PARTICIPANT1"}); err != nil {
+				return err
+			}
+			// the transaction sees its own writes
+			if _, err := tx.GetById("PARTICIPANT1", &models.Participant{}); err != nil {
+				return err
+			}
+			return fmt.Errorf("failed")
+		})
+		suite.EqualError(err, "failed")
+
+		_, err = suite.Client.GetById("PARTICIPANT1", &models.Participant{})
+		suite.Equal(sql.ErrNoRows, err)
+	})
+
+	suite.Run("CreateAll is all or nothing", func() {
+		err := suite.Client.CreateAll(&models.Participant{Id: "PARTICIPANT1"}, &models.Participant{Id: "PARTICIPANT1"})
+		suite.Error(err)
+
+		count, err := suite.Client.Count(&models.Participant{}, nil)
+		suite.Require().NoError(err)
+		suite.Equal(0, count)
+	})
+
+	suite.Run("writes are visible once committed", func() {
+		tx, err := suite.Client.BeginTx(context.Background(), nil)
+		suite.Require().NoError(err)
+		suite.Require().NoError(tx.Create(&models.Participant{Id: "PARTICIPANT1"}))
+
+		_, err = suite.Client.GetById("PARTICIPANT1", &models.Participant{})
+		suite.Equal(sql.ErrNoRows, err)
+
+		_, err = tx.BeginTx(context.Background(), nil)
+		suite.Equal(ErrAlreadyInTransaction, err)
+
+		suite.Require().NoError(tx.Commit())
+		suite.Equal(sql.ErrTxDone, tx.Rollback())
+
+		_, err = suite.Client.GetById("PARTICIPANT1", &models.Participant{})
+		suite.NoError(err)
+	})
+
+	suite.Run("writes made outside of the transaction are kept", func() {
+		suite.SetupTest()
+		tx, err := suite.Client.BeginTx(context.Background(), nil)
+		suite.Require().NoError(err)
+		suite.Require().NoError(tx.Create(&models.Participant{Id: "PARTICIPANT1"}))
+		suite.Require().NoError(suite.Client.Create(&models.OutboxEvent{Id: "OUTBOX1", CreatedAt: suite.Timer.GetTimeNow()}))
+
+		suite.Require().NoError(tx.Commit())
+		_, err = suite.Client.GetById("PARTICIPANT1", &models.Participant{})
+		suite.NoError(err)
+		_, err = suite.Client.GetById("OUTBOX1", &models.OutboxEvent{})
+		suite.NoError(err)
+	})
+
+	suite.Run("a table written to both inside and outside of the transaction conflicts", func() {
+		suite.SetupTest()
+		tx, err := suite.Client.BeginTx(context.Background(), nil)
+		suite.Require().NoError(err)
+		suite.Require().NoError(tx.Update(&models.ScheduledQuestionnaire{Id: "SCHEDULED1",
+			Status: sql.NullString{Valid: true, String: "cancelled"}}, "status"))
+		suite.Require().NoError(suite.Client.Update(&models.ScheduledQuestionnaire{Id: "SCHEDULED3",
+			Status: sql.NullString{Valid: true, String: "completed"}}, "status"))
+
+		err = tx.Commit()
+		suite.True(IsConflict(err))
+		suite.EqualError(err, "scheduled_questionnaires was written to outside of the transaction while it was open")
+
+		// neither the transaction's write, nor the one made outside of it, is lost track of
+		var rows models.ScheduledQuestionnaires
+		suite.Require().NoError(suite.Client.GetList(&rows, Filters{Eq("status", "completed")}, OrderBy(Asc("id"))))
+		suite.Equal([]string{"SCHEDULED2", "SCHEDULED3"}, suite.ids(rows))
+		suite.Require().NoError(suite.Client.GetList(&rows, Filters{Eq("status", "pending")}))
+		suite.Equal([]string{"SCHEDULED1"}, suite.ids(rows))
+	})
+
+	suite.Run("a conflicting transaction succeeds when it's retried", func() {
+		suite.SetupTest()
+		runs := 0
+		err := WithTxRetry(context.Background(), suite.Client, nil, 2, func(tx Client) error {
+			runs++
+			if runs == 1 {
+				// a concurrent write while the first attempt is open
+				if err := suite.Client.Delete(&models.ScheduledQuestionnaire{Id: "SCHEDULED3"}); err != nil {
+					return err
+				}
+			}
+			return tx.Update(&models.ScheduledQuestionnaire{Id: "SCHEDULED1",
+				Status: sql.NullString{Valid: true, String: "cancelled"}}, "status")
+		})
+		suite.Require().NoError(err)
+		suite.Equal(2, runs)
+
+		count, err := suite.Client.Count(&models.ScheduledQuestionnaire{}, nil)
+		suite.Require().NoError(err)
+		suite.Equal(2, count)
+	})
+}
+
+func TestMemoryClientTestSuite(t *testing.T) {
+	suite.Run(t, new(MemoryClientTestSuite))
+}
@@ -0,0 +1,254 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/jamesineda/reschedular/app/models"
+	"github.com/jamesineda/reschedular/app/utils"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Migration a versioned schema change. Up and Down are run one statement at a time, within a transaction along with the
+// schema_migrations row that records it. MySQL commits DDL as it goes, so a failed migration there has to be tidied by hand
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%03d_%s", m.Version, m.Name)
+}
+
+// statements swaps the column type placeholders for the dialect's own
+func (m Migration) statements(d Dialect, statements []string) []string {
+	replacer := columnTypes(d)
+	out := make([]string, 0, len(statements))
+	for _, statement := range statements {
+		out = append(out, replacer.Replace(statement))
+	}
+	return out
+}
+
+func columnTypes(d Dialect) *strings.Replacer {
+	return strings.NewReplacer("{{json}}", d.JSONType(), "{{datetime}}", d.DateTimeType())
+}
+
+// MigrationStatus a migration along with when it was applied, AppliedAt is nil if it hasn't been
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+func (s MigrationStatus) IsApplied() bool {
+	return s.AppliedAt != nil
+}
+
+var ErrMigrationsNotSupported = fmt.Errorf("database client does not support migrations")
+
+const createHistoryTableQuery = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version integer NOT NULL PRIMARY KEY,
+	name varchar(128) NOT NULL,
+	applied_at {{datetime}} NOT NULL
+)`
+
+// Migrator applies and rolls back Migrations, keeping track of where it's up to in the schema_migrations table
+type Migrator struct {
+	conn       *DatabaseConn
+	timer      utils.Timer
+	migrations []Migration
+}
+
+func NewMigrator(client Client, timer utils.Timer) (*Migrator, error) {
+	return newMigrator(client, timer, Migrations)
+}
+
+func newMigrator(client Client, timer utils.Timer, migrations []Migration) (*Migrator, error) {
+	conn, ok := client.(*DatabaseConn)
+	if !ok {
+		return nil, ErrMigrationsNotSupported
+	}
+
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	for i, migration := range sorted {
+		if migration.Version < 1 {
+			return nil, fmt.Errorf("migration %s needs a version above zero", migration)
+		}
+		if i > 0 && sorted[i-1].Version == migration.Version {
+			return nil, fmt.Errorf("migrations %s and %s share a version", sorted[i-1], migration)
+		}
+	}
+
+	m := &Migrator{conn: conn, timer: timer, migrations: sorted}
+	if _, err := conn.SQLXClient.Exec(columnTypes(conn.dialect).Replace(createHistoryTableQuery)); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations table: %v", err)
+	}
+	return m, nil
+}
+
+// Status every known migration, oldest first
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Migration: migration}
+		if row, ok := applied[migration.Version]; ok {
+			appliedAt := row.AppliedAt
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Version the most recent migration that's been applied, zero if there aren't any
+func (m *Migrator) Version() (int, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+
+	version := 0
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
+}
+
+// Up applies every migration that hasn't been applied yet
+func (m *Migrator) Up() ([]Migration, error) {
+	if len(m.migrations) == 0 {
+		return nil, nil
+	}
+	return m.To(m.migrations[len(m.migrations)-1].Version)
+}
+
+// Down rolls back the most recently applied migration
+func (m *Migrator) Down() ([]Migration, error) {
+	version, err := m.Version()
+	if err != nil || version == 0 {
+		return nil, err
+	}
+
+	previous := 0
+	for _, migration := range m.migrations {
+		if migration.Version < version {
+			previous = migration.Version
+		}
+	}
+	return m.To(previous)
+}
+
+// To applies or rolls back migrations until version is the latest one applied, zero rolls everything back. It returns
+// the migrations that were run, in the order they were run
+func (m *Migrator) To(version int) (run []Migration, err error) {
+	if version != 0 {
+		if _, ok := m.find(version); !ok {
+			return nil, fmt.Errorf("unknown migration version %d", version)
+		}
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	for v := range applied {
+		if _, ok := m.find(v); !ok && v > version {
+			return nil, fmt.Errorf("migration version %d has been applied, but isn't known to this build", v)
+		}
+	}
+
+	// newest first when rolling back
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; ok && migration.Version > version {
+			if err = m.down(migration); err != nil {
+				return run, err
+			}
+			run = append(run, migration)
+		}
+	}
+
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok && migration.Version <= version {
+			if err = m.up(migration); err != nil {
+				return run, err
+			}
+			run = append(run, migration)
+		}
+	}
+	return run, nil
+}
+
+func (m *Migrator) up(migration Migration) error {
+	return m.run(migration, migration.Up, func(tx Client) error {
+		return tx.Create(&models.SchemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: m.timer.GetTimeNow()})
+	})
+}
+
+func (m *Migrator) down(migration Migration) error {
+	return m.run(migration, migration.Down, func(tx Client) error {
+		_, err := execer(tx).Exec(m.conn.rebind("DELETE FROM schema_migrations WHERE version = ?"), migration.Version)
+		return err
+	})
+}
+
+func (m *Migrator) run(migration Migration, statements []string, record func(tx Client) error) error {
+	err := m.conn.WithTx(context.Background(), nil, func(tx Client) error {
+		for _, statement := range migration.statements(m.conn.dialect, statements) {
+			if _, err := execer(tx).Exec(statement); err != nil {
+				return err
+			}
+		}
+		return record(tx)
+	})
+	if err != nil {
+		return fmt.Errorf("migration %s failed: %v", migration, err)
+	}
+	return nil
+}
+
+func (m *Migrator) applied() (map[int]*models.SchemaMigration, error) {
+	var rows models.SchemaMigrations
+	if err := m.conn.GetList(&rows, nil, OrderBy(Asc("version"))); err != nil {
+		return nil, fmt.Errorf("failed to query schema_migrations from database: %v", err)
+	}
+
+	applied := make(map[int]*models.SchemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+func (m *Migrator) find(version int) (Migration, bool) {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+// execer the raw client underneath the Client handed to WithTx, migrations run statements the generic client can't build
+func execer(client Client) SQLXClient {
+	switch c := client.(type) {
+	case *txConn:
+		return c.SQLXClient
+	default:
+		return c.(*DatabaseConn).SQLXClient
+	}
+}
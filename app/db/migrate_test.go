@@ -0,0 +1,205 @@
+package db
+
+import (
+	"database/sql"
+	"github.com/jamesineda/reschedular/app/models"
+	"github.com/jamesineda/reschedular/app/utils"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/suite"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type MigrateTestSuite struct {
+	suite.Suite
+	DB       *sqlx.DB
+	Client   Client
+	Timer    utils.Timer
+	Migrator *Migrator
+}
+
+func (suite *MigrateTestSuite) SetupTest() {
+	db, err := sqlx.Connect("sqlite3", filepath.Join(suite.T().TempDir(), "reschedular.db"))
+	suite.Require().NoError(err)
+	suite.DB = db
+	suite.Client = &DatabaseConn{SQLXClient: &sqlxDB{db}, dialect: sqliteDialect{}}
+	suite.Timer = utils.NewFakeTimer(time.Date(2022, 7, 18, 10, 0, 0, 0, time.UTC))
+
+	suite.Migrator, err = NewMigrator(suite.Client, suite.Timer)
+	suite.Require().NoError(err)
+}
+
+func (suite *MigrateTestSuite) TearDownTest() {
+	suite.NoError(suite.DB.Close())
+}
+
+func (suite *MigrateTestSuite) tableExists(name string) bool {
+	var found string
+	err := suite.DB.Get(&found, "SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?", name)
+	return err == nil
+}
+
+func (suite *MigrateTestSuite) columnExists(table, column string) bool {
+	var found int
+	err := suite.DB.Get(&found, "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column)
+	suite.Require().NoError(err)
+	return found > 0
+}
+
+func (suite *MigrateTestSuite) Test_Up() {
+	run, err := suite.Migrator.Up()
+	suite.Require().NoError(err)
+	suite.Len(run, len(Migrations))
+
+	for _, table := range []string{"participants", "questionnaires", "scheduled_questionnaires", "questionnaire_results",
+		"outbox_events", "failed_events", "processed_events", "study_blackouts", "study_quiet_periods"} {
+		suite.True(suite.tableExists(table), table)
+	}
+
+	suite.Run("running it again does nothing", func() {
+		run, err := suite.Migrator.Up()
+		suite.Require().NoError(err)
+		suite.Empty(run)
+	})
+
+	suite.Run("every model can be written to its table", func() {
+		completedAt := suite.Timer.GetTimeNow()
+		suite.Require().NoError(suite.Client.CreateAll(
+			&models.Participant{Id: "PARTICIPANT1", Name: "Ann"},
+			&models.Questionnaire{Id: "QUESTIONNAIRE1", StudyId: "STUDY1", Name: "hair regrowth", Questions: "{}",
+				HoursBetweenAttempts: sql.NullInt64{Valid: true, Int64: 24}},
+			&models.ScheduledQuestionnaire{Id: "SCHEDULED1", QuestionnaireId: "QUESTIONNAIRE1", ParticipantId: "PARTICIPANT1",
+				ScheduledAt: completedAt},
+			&models.QuestionnaireResult{Id: "RESULT1", Answers: "{}", QuestionnaireId: "QUESTIONNAIRE1",
+				ParticipantId: "PARTICIPANT1", CompletedAt: &completedAt},
+			&models.OutboxEvent{Id: "OUTBOX1", EventType: "SCHEDULED_QUESTIONNAIRE", Payload: "{}", CreatedAt: completedAt},
+			&models.FailedEvent{Id: "FAILED1", EventType: "SCHEDULED_QUESTIONNAIRE", Payload: "{}", FailedAt: completedAt},
+			&models.ProcessedEvent{Id: "COMPLETED1", EventType: "QUESTIONNAIRE_COMPLETED", Outcome: "SCHEDULED",
+				ProcessedAt: completedAt},
+			&models.StudyBlackout{Id: "BLACKOUT1", StudyId: "STUDY1", StartsOn: "2022-12-25", EndsOn: "2022-12-26"},
+			&models.StudyQuietPeriod{Id: "QUIET1", StudyId: "STUDY1", StartTime: "22:00", EndTime: "07:00"},
+		))
+
+		row, err := suite.Client.GetById("QUESTIONNAIRE1", &models.Questionnaire{})
+		suite.Require().NoError(err)
+		suite.Equal(int64(24), row.(*models.Questionnaire).HoursBetweenAttempts.Int64)
+	})
+}
+
+func (suite *MigrateTestSuite) Test_DownAndTo() {
+	_, err := suite.Migrator.Up()
+	suite.Require().NoError(err)
+
+	suite.Run("down rolls back the latest migration", func() {
+		run, err := suite.Migrator.Down()
+		suite.Require().NoError(err)
+		suite.Require().Len(run, 1)
+		suite.Equal(Migrations[len(Migrations)-1].Version, run[0].Version)
+
+		version, err := suite.Migrator.Version()
+		suite.Require().NoError(err)
+		suite.Equal(Migrations[len(Migrations)-2].Version, version)
+	})
+
+	suite.Run("to an earlier version rolls back, newest first", func() {
+		run, err := suite.Migrator.To(2)
+		suite.Require().NoError(err)
+		var want []int
+		for version := len(Migrations) - 1; version > 2; version-- {
+			want = append(want, version)
+		}
+		suite.Equal(want, versions(run))
+		suite.True(suite.tableExists("questionnaires"))
+		suite.False(suite.tableExists("scheduled_questionnaires"))
+	})
+
+	suite.Run("to a later version applies, oldest first", func() {
+		run, err := suite.Migrator.To(4)
+		suite.Require().NoError(err)
+		suite.Equal([]int{3, 4}, versions(run))
+	})
+
+	suite.Run("status", func() {
+		statuses, err := suite.Migrator.Status()
+		suite.Require().NoError(err)
+		suite.Require().Len(statuses, len(Migrations))
+		suite.True(statuses[3].IsApplied())
+		suite.Equal(suite.Timer.GetTimeNow(), statuses[3].AppliedAt.UTC())
+		suite.False(statuses[4].IsApplied())
+	})
+
+	suite.Run("to zero rolls everything back", func() {
+		_, err := suite.Migrator.To(0)
+		suite.Require().NoError(err)
+		suite.False(suite.tableExists("participants"))
+	})
+
+	suite.Run("unknown versions", func() {
+		_, err := suite.Migrator.To(99)
+		suite.EqualError(err, "unknown migration version 99")
+	})
+}
+
+func (suite *MigrateTestSuite) Test_Down() {
+	_, err := suite.Migrator.Up()
+	suite.Require().NoError(err)
+
+	// each migration's Down, newest first, along with what should be left once it has run
+	for _, reverted := range []struct {
+		version int
+		check   func()
+	}{
+		{version: 10, check: func() {
+			suite.False(suite.tableExists("study_blackouts"))
+			suite.False(suite.tableExists("study_quiet_periods"))
+			suite.True(suite.columnExists("participants", "time_zone"))
+		}},
+		{version: 9, check: func() {
+			suite.False(suite.columnExists("participants", "time_zone"))
+			suite.False(suite.columnExists("questionnaires", "delivery_window_start"))
+			suite.False(suite.columnExists("questionnaires", "delivery_window_end"))
+			suite.True(suite.columnExists("questionnaires", "recurrence"))
+		}},
+		{version: 8, check: func() {
+			suite.False(suite.columnExists("questionnaires", "recurrence"))
+			suite.True(suite.columnExists("questionnaires", "hours_between_attempts"))
+		}},
+	} {
+		_, err := suite.Migrator.To(reverted.version - 1)
+		suite.Require().NoError(err)
+		reverted.check()
+	}
+}
+
+func (suite *MigrateTestSuite) Test_FailedMigration() {
+	migrator, err := newMigrator(suite.Client, suite.Timer, []Migration{
+		{Version: 1, Name: "create_things", Up: []string{"CREATE TABLE things (id integer)", "NOT SQL"}, Down: []string{"DROP TABLE things"}},
+	})
+	suite.Require().NoError(err)
+
+	_, err = migrator.Up()
+	suite.Error(err)
+	// sqlite runs DDL within the transaction, so nothing is left half applied
+	suite.False(suite.tableExists("things"))
+
+	version, err := migrator.Version()
+	suite.Require().NoError(err)
+	suite.Equal(0, version)
+}
+
+func (suite *MigrateTestSuite) Test_newMigrator() {
+	_, err := newMigrator(suite.Client, suite.Timer, []Migration{{Version: 1, Name: "a"}, {Version: 1, Name: "b"}})
+	suite.EqualError(err, "migrations 001_a and 001_b share a version")
+}
+
+func versions(migrations []Migration) (v []int) {
+	for _, migration := range migrations {
+		v = append(v, migration.Version)
+	}
+	return
+}
+
+func TestMigrateTestSuite(t *testing.T) {
+	suite.Run(t, new(MigrateTestSuite))
+}
@@ -0,0 +1,154 @@
+package db
+
+// Migrations every schema change, in the order they're applied. {{json}} and {{datetime}} are swapped for the dialect's
+// column types, see Migration.statements. Once a migration has been released it mustn't be changed, add a new one instead
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_participants",
+		Up: []string{
+			`CREATE TABLE participants (
+				id varchar(128) NOT NULL PRIMARY KEY,
+				name varchar(128) NOT NULL
+			)`,
+		},
+		Down: []string{`DROP TABLE participants`},
+	},
+	{
+		Version: 2,
+		Name:    "create_questionnaires",
+		Up: []string{
+			`CREATE TABLE questionnaires (
+				id varchar(128) NOT NULL PRIMARY KEY,
+				study_id varchar(128) NOT NULL,
+				name varchar(128) NOT NULL,
+				questions {{json}} NOT NULL,
+				max_attempts integer NULL,
+				hours_between_attempts integer NULL DEFAULT 24
+			)`,
+		},
+		Down: []string{`DROP TABLE questionnaires`},
+	},
+	{
+		Version: 3,
+		Name:    "create_scheduled_questionnaires",
+		Up: []string{
+			// status is one of pending, completed or cancelled
+			`CREATE TABLE scheduled_questionnaires (
+				id varchar(128) NOT NULL PRIMARY KEY,
+				questionnaire_id varchar(128) NOT NULL,
+				participant_id varchar(128) NOT NULL,
+				scheduled_at {{datetime}} NOT NULL,
+				status varchar(16) NULL
+			)`,
+			`CREATE INDEX scheduled_questionnaires_participant ON scheduled_questionnaires (participant_id, questionnaire_id, status)`,
+		},
+		Down: []string{`DROP TABLE scheduled_questionnaires`},
+	},
+	{
+		Version: 4,
+		Name:    "create_questionnaire_results",
+		Up: []string{
+			`CREATE TABLE questionnaire_results (
+				id varchar(128) NOT NULL PRIMARY KEY,
+				answers {{json}} NOT NULL,
+				questionnaire_id varchar(128) NOT NULL,
+				participant_id varchar(128) NOT NULL,
+				questionnaire_schedule_id varchar(128) NULL,
+				completed_at {{datetime}} NULL
+			)`,
+			`CREATE INDEX questionnaire_results_participant ON questionnaire_results (participant_id, questionnaire_id)`,
+		},
+		Down: []string{`DROP TABLE questionnaire_results`},
+	},
+	{
+		Version: 5,
+		Name:    "create_outbox_events",
+		Up: []string{
+			`CREATE TABLE outbox_events (
+				id varchar(128) NOT NULL PRIMARY KEY,
+				event_type varchar(128) NOT NULL,
+				payload {{json}} NOT NULL,
+				created_at {{datetime}} NOT NULL,
+				sent_at {{datetime}} NULL,
+				failed_at {{datetime}} NULL
+			)`,
+			`CREATE INDEX outbox_events_created_at ON outbox_events (created_at)`,
+		},
+		Down: []string{`DROP TABLE outbox_events`},
+	},
+	{
+		Version: 6,
+		Name:    "create_failed_events",
+		Up: []string{
+			`CREATE TABLE failed_events (
+				id varchar(128) NOT NULL PRIMARY KEY,
+				outbox_id varchar(128) NULL,
+				event_type varchar(128) NOT NULL,
+				payload {{json}} NOT NULL,
+				attempts integer NOT NULL DEFAULT 0,
+				last_error text NULL,
+				failed_at {{datetime}} NOT NULL,
+				replayed_at {{datetime}} NULL
+			)`,
+		},
+		Down: []string{`DROP TABLE failed_events`},
+	},
+	{
+		Version: 7,
+		Name:    "create_processed_events",
+		Up: []string{
+			`CREATE TABLE processed_events (
+				id varchar(128) NOT NULL PRIMARY KEY,
+				event_type varchar(128) NOT NULL,
+				outcome varchar(64) NOT NULL,
+				scheduled_questionnaire_id varchar(128) NULL,
+				processed_at {{datetime}} NOT NULL
+			)`,
+		},
+		Down: []string{`DROP TABLE processed_events`},
+	},
+	{
+		Version: 8,
+		Name:    "add_questionnaires_recurrence",
+		Up:      []string{`ALTER TABLE questionnaires ADD COLUMN recurrence text NULL`},
+		Down:    []string{`ALTER TABLE questionnaires DROP COLUMN recurrence`},
+	},
+	{
+		Version: 9,
+		Name:    "add_time_zones_and_delivery_windows",
+		Up: []string{
+			`ALTER TABLE participants ADD COLUMN time_zone varchar(64) NULL`,
+			`ALTER TABLE questionnaires ADD COLUMN delivery_window_start varchar(5) NULL`,
+			`ALTER TABLE questionnaires ADD COLUMN delivery_window_end varchar(5) NULL`,
+		},
+		Down: []string{
+			`ALTER TABLE questionnaires DROP COLUMN delivery_window_end`,
+			`ALTER TABLE questionnaires DROP COLUMN delivery_window_start`,
+			`ALTER TABLE participants DROP COLUMN time_zone`,
+		},
+	},
+	{
+		Version: 10,
+		Name:    "create_study_calendars",
+		Up: []string{
+			`CREATE TABLE study_blackouts (
+				id varchar(128) NOT NULL PRIMARY KEY,
+				study_id varchar(128) NOT NULL,
+				starts_on varchar(10) NOT NULL,
+				ends_on varchar(10) NOT NULL,
+				reason varchar(255) NULL
+			)`,
+			`CREATE INDEX study_blackouts_study_id_ends_on ON study_blackouts (study_id, ends_on)`,
+			`CREATE TABLE study_quiet_periods (
+				id varchar(128) NOT NULL PRIMARY KEY,
+				study_id varchar(128) NOT NULL,
+				start_time varchar(5) NOT NULL,
+				end_time varchar(5) NOT NULL,
+				days varchar(32) NULL
+			)`,
+			`CREATE INDEX study_quiet_periods_study_id ON study_quiet_periods (study_id)`,
+		},
+		Down: []string{`DROP TABLE study_quiet_periods`, `DROP TABLE study_blackouts`},
+	},
+}
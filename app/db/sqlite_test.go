@@ -0,0 +1,140 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jamesineda/reschedular/app/models"
+	"github.com/jamesineda/reschedular/app/utils"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/suite"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// SQLiteTestSuite runs the generated queries against a real, file backed, SQLite database with every migration applied
+type SQLiteTestSuite struct {
+	suite.Suite
+	DB     *sqlx.DB
+	Client Client
+}
+
+func (suite *SQLiteTestSuite) SetupTest() {
+	db, err := sqlx.Connect("sqlite3", filepath.Join(suite.T().TempDir(), "reschedular.db"))
+	suite.Require().NoError(err)
+	suite.DB = db
+	suite.Client = &DatabaseConn{SQLXClient: &sqlxDB{db}, dialect: sqliteDialect{}}
+
+	// the same schema as every other environment
+	migrator, err := NewMigrator(suite.Client, utils.NewFakeTimer(time.Date(2022, 7, 18, 10, 0, 0, 0, time.UTC)))
+	suite.Require().NoError(err)
+	_, err = migrator.Up()
+	suite.Require().NoError(err)
+}
+
+func (suite *SQLiteTestSuite) TearDownTest() {
+	suite.NoError(suite.DB.Close())
+}
+
+func (suite *SQLiteTestSuite) scheduled(id string, scheduledAt time.Time) *models.ScheduledQuestionnaire {
+	return &models.ScheduledQuestionnaire{
+		Id:              id,
+		QuestionnaireId: "QUESTIONNAIRE1",
+		ParticipantId:   "PARTICIPANT1",
+		ScheduledAt:     scheduledAt,
+		Status:          sql.NullString{Valid: true, String: "pending"},
+	}
+}
+
+func (suite *SQLiteTestSuite) Test_CRUD() {
+	suite.Require().NoError(suite.Client.Create(&models.Participant{Id: "PARTICIPANT1", Name: "Ann"}))
+
+	row, err := suite.Client.GetById("PARTICIPANT1", &models.Participant{})
+	suite.Require().NoError(err)
+	suite.Equal(&models.Participant{Id: "PARTICIPANT1", Name: "Ann"}, row)
+
+	suite.Require().NoError(suite.Client.Update(&models.Participant{Id: "PARTICIPANT1", Name: "Annie"}, "name"))
+	suite.Require().NoError(suite.Client.Upsert(&models.Participant{Id: "PARTICIPANT2", Name: "Bob"}))
+	suite.Require().NoError(suite.Client.Upsert(&models.Participant{Id: "PARTICIPANT2", Name: "Bobby"}))
+
+	var participants models.Participants
+	suite.Require().NoError(suite.Client.GetList(&participants, nil, OrderBy(Asc("id"))))
+	suite.Equal(models.Participants{{Id: "PARTICIPANT1", Name: "Annie"}, {Id: "PARTICIPANT2", Name: "Bobby"}}, participants)
+
+	suite.Require().NoError(suite.Client.Delete(&models.Participant{Id: "PARTICIPANT1"}))
+	suite.Equal(sql.ErrNoRows, suite.Client.Delete(&models.Participant{Id: "PARTICIPANT1"}))
+
+	_, err = suite.Client.GetById("PARTICIPANT1", &models.Participant{})
+	suite.Equal(sql.ErrNoRows, err)
+}
+
+func (suite *SQLiteTestSuite) Test_ListsAndAggregates() {
+	start := time.Date(2022, 7, 18, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		suite.Require().NoError(suite.Client.Create(suite.scheduled(fmt.Sprintf("SCHEDULED%d", i), start.Add(time.Duration(i)*time.Hour))))
+	}
+
+	suite.Run("pages through with a keyset cursor", func() {
+		var page models.ScheduledQuestionnaires
+		suite.Require().NoError(suite.Client.GetList(&page, Filters{Eq("participant_id", "PARTICIPANT1")},
+			OrderBy(Desc("scheduled_at")), Limit(2)))
+		suite.Require().Len(page, 2)
+		suite.Equal("SCHEDULED4", page[0].Id)
+
+		var next models.ScheduledQuestionnaires
+		suite.Require().NoError(suite.Client.GetList(&next, Filters{Eq("participant_id", "PARTICIPANT1")},
+			OrderBy(Desc("scheduled_at")), After(page[1].ScheduledAt), Limit(2)))
+		suite.Require().Len(next, 2)
+		suite.Equal("SCHEDULED2", next[0].Id)
+		suite.Equal("SCHEDULED1", next[1].Id)
+	})
+
+	suite.Run("IN binds every value", func() {
+		var rows models.ScheduledQuestionnaires
+		suite.Require().NoError(suite.Client.GetList(&rows, Filters{In("id", []string{"SCHEDULED0", "SCHEDULED3", "' OR '1'='1"})},
+			OrderBy(Asc("id"))))
+		suite.Len(rows, 2)
+	})
+
+	suite.Run("aggregates", func() {
+		count, err := suite.Client.Count(&models.ScheduledQuestionnaire{}, Filters{Lte("scheduled_at", start.Add(2*time.Hour))})
+		suite.Require().NoError(err)
+		suite.Equal(3, count)
+
+		exists, err := suite.Client.Exists(&models.ScheduledQuestionnaire{}, Filters{Eq("status", "completed")})
+		suite.Require().NoError(err)
+		suite.False(exists)
+
+		var latest sql.NullString
+		suite.Require().NoError(suite.Client.Max(&models.ScheduledQuestionnaire{}, "id", nil, &latest))
+		suite.Equal("SCHEDULED4", latest.String)
+	})
+
+	suite.Run("updates matching rows", func() {
+		updated, err := suite.Client.UpdateWhere(&models.ScheduledQuestionnaire{}, Filters{Lt("scheduled_at", start.Add(2*time.Hour))},
+			map[string]interface{}{"status": "completed"})
+		suite.Require().NoError(err)
+		suite.Equal(int64(2), updated)
+	})
+}
+
+func (suite *SQLiteTestSuite) Test_WithTx() {
+	start := time.Date(2022, 7, 18, 10, 0, 0, 0, time.UTC)
+	err := suite.Client.WithTx(context.Background(), nil, func(tx Client) error {
+		if err := tx.Create(suite.scheduled("SCHEDULED1", start)); err != nil {
+			return err
+		}
+		// the same id again, so the whole transaction is rolled back
+		return tx.Create(suite.scheduled("SCHEDULED1", start))
+	})
+	suite.Error(err)
+
+	count, err := suite.Client.Count(&models.ScheduledQuestionnaire{}, nil)
+	suite.Require().NoError(err)
+	suite.Equal(0, count)
+}
+
+func TestSQLiteTestSuite(t *testing.T) {
+	suite.Run(t, new(SQLiteTestSuite))
+}
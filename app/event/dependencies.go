@@ -0,0 +1,56 @@
+package event
+
+import (
+	"fmt"
+	"github.com/jamesineda/reschedular/app/db"
+	"github.com/jamesineda/reschedular/app/utils"
+	"strings"
+)
+
+// Dependencies everything the event handlers and the event processor need, wired up once in main and passed in
+// explicitly, so that anything missing is caught by Validate at startup
+type Dependencies struct {
+	DB        db.Client
+	Timer     utils.Timer
+	IdGenny   utils.IdGenny
+	Publisher Publisher
+	Queue     Queue
+	Retry     *utils.RetryConfig
+	Processor *utils.ProcessorConfig
+	// Idempotency stops redelivered events from being processed twice
+	Idempotency IdempotencyStore
+}
+
+// Validate returns an error naming every dependency that hasn't been set
+func (d *Dependencies) Validate() error {
+	var missing []string
+	if d.DB == nil {
+		missing = append(missing, "DB")
+	}
+	if d.Timer == nil {
+		missing = append(missing, "Timer")
+	}
+	if d.IdGenny == nil {
+		missing = append(missing, "IdGenny")
+	}
+	if d.Publisher == nil {
+		missing = append(missing, "Publisher")
+	}
+	if d.Queue == nil {
+		missing = append(missing, "Queue")
+	}
+	if d.Retry == nil {
+		missing = append(missing, "Retry")
+	}
+	if d.Processor == nil {
+		missing = append(missing, "Processor")
+	}
+	if d.Idempotency == nil {
+		missing = append(missing, "Idempotency")
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing dependencies: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
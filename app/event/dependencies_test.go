@@ -0,0 +1,35 @@
+package event
+
+import (
+	"github.com/jamesineda/reschedular/app/db"
+	"github.com/jamesineda/reschedular/app/utils"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type DependenciesSuite struct {
+	suite.Suite
+}
+
+func (suite *DependenciesSuite) Test_Validate() {
+	suite.Run("when nothing is set, everything is reported as missing", func() {
+		deps := &Dependencies{}
+		suite.EqualError(deps.Validate(), "missing dependencies: DB, Timer, IdGenny, Publisher, Queue, Retry, Processor, Idempotency")
+	})
+
+	suite.Run("when only some are set", func() {
+		dbConn, _ := db.NewFakeDatabaseConn(&db.FakeSQLX{})
+		deps := &Dependencies{
+			DB:      dbConn,
+			Timer:   utils.NewFakeTimer(time.Date(2022, 7, 18, 10, 0, 0, 0, time.UTC)),
+			IdGenny: utils.NewFakeIdGenny("ABC123"),
+			Retry:   utils.NewDefaultRetryConfig(),
+		}
+		suite.EqualError(deps.Validate(), "missing dependencies: Publisher, Queue, Processor, Idempotency")
+	})
+}
+
+func TestDependenciesSuite(t *testing.T) {
+	suite.Run(t, new(DependenciesSuite))
+}
@@ -0,0 +1,53 @@
+package event
+
+import (
+	"github.com/jamesineda/reschedular/app/db"
+	"github.com/jamesineda/reschedular/app/utils"
+	"log"
+	"sync/atomic"
+)
+
+// drainer keeps count of what happens to the events that are still waiting when the processor shuts down: they're
+// either flushed, or left over in the outbox to be sent by the next run
+type drainer struct {
+	db       db.Client
+	idGenny  utils.IdGenny
+	timer    utils.Timer
+	flushed  int64
+	leftOver int64
+}
+
+func (d *drainer) Flushed() int64 {
+	return atomic.LoadInt64(&d.flushed)
+}
+
+func (d *drainer) LeftOver() int64 {
+	return atomic.LoadInt64(&d.leftOver)
+}
+
+func (d *drainer) sent() {
+	atomic.AddInt64(&d.flushed, 1)
+}
+
+// persist outboxed events are already safe, anything else is written to the outbox so that it isn't lost
+func (d *drainer) persist(e IncomingEvent) {
+	if outboxed, ok := e.(*OutboxedEvent); ok {
+		if outboxed.OutboxId != "" {
+			atomic.AddInt64(&d.leftOver, 1)
+			return
+		}
+		e = outboxed.IncomingEvent
+	}
+
+	row, _, err := NewOutboxEvent(d.idGenny, d.timer, e)
+	if err == nil {
+		err = d.db.Create(row)
+	}
+
+	if err != nil {
+		log.Printf("failed to persist %s event (id: %s) on shutdown, it has been lost: %s", e.FunctionName(),
+			e.EventId(), err)
+		return
+	}
+	atomic.AddInt64(&d.leftOver, 1)
+}
@@ -0,0 +1,72 @@
+package event
+
+import (
+	"context"
+	"github.com/stretchr/testify/suite"
+	"sync"
+	"testing"
+)
+
+type DrainSuite struct {
+	suite.Suite
+	Publisher *failingPublisher
+	Worker    *publishWorker
+}
+
+func (suite *DrainSuite) SetupTest() {
+	suite.Worker, suite.Publisher = newTestWorker()
+}
+
+func (suite *DrainSuite) Test_run_WhenDraining() {
+	suite.Run("events sent after shutdown was requested are counted as flushed", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		suite.Worker.events <- scheduledFor("ABC123", "PARTICIPANT1")
+		close(suite.Worker.events)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		suite.Worker.run(ctx, context.Background(), &wg)
+
+		suite.Len(suite.Publisher.published, 1)
+		suite.Equal(int64(1), suite.Worker.drain.Flushed())
+		suite.Equal(int64(0), suite.Worker.drain.LeftOver())
+	})
+}
+
+func (suite *DrainSuite) Test_run_WhenDrainHasTimedOut() {
+	suite.Run("remaining events are left in the outbox instead of sent", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		suite.Worker.events <- &OutboxedEvent{IncomingEvent: scheduledFor("ABC123", "PARTICIPANT1"), OutboxId: "OUTBOX1"}
+		suite.Worker.events <- scheduledFor("ABC456", "PARTICIPANT2")
+		close(suite.Worker.events)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		suite.Worker.run(ctx, ctx, &wg)
+
+		suite.Empty(suite.Publisher.published)
+		suite.Equal(int64(0), suite.Worker.drain.Flushed())
+		suite.Equal(int64(2), suite.Worker.drain.LeftOver())
+	})
+}
+
+func (suite *DrainSuite) Test_publish_WhenDrainTimesOutDuringRetry() {
+	suite.Run("events waiting to be retried are left in the outbox", func() {
+		drainCtx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		suite.Publisher.failures = 1
+		suite.Worker.publish(context.Background(), drainCtx, IncomingEvents{scheduledFor("ABC123", "PARTICIPANT1")})
+
+		suite.Empty(suite.Publisher.published)
+		suite.Equal(int64(1), suite.Worker.drain.LeftOver())
+	})
+}
+
+func TestDrainSuite(t *testing.T) {
+	suite.Run(t, new(DrainSuite))
+}
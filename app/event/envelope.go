@@ -0,0 +1,56 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+var ErrNotAnEnvelope = fmt.Errorf("message body is not an event envelope")
+
+// Envelope the JSON document sent as the message body for every published event. The message attributes only carry
+// routing metadata, consumers should parse the envelope and use the Type and SchemaVersion to decode the Payload
+type Envelope struct {
+	Type          string          `json:"type"`
+	SchemaVersion int             `json:"schema_version"`
+	EventId       string          `json:"event_id"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+func NewEnvelope(e IncomingEvent) (*Envelope, error) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialise %s event payload: %v", e.FunctionName(), err)
+	}
+
+	return &Envelope{
+		Type:          e.FunctionName(),
+		SchemaVersion: e.SchemaVersion(),
+		EventId:       e.EventId(),
+		OccurredAt:    e.OccurredAt().UTC(),
+		Payload:       payload,
+	}, nil
+}
+
+// MarshalEnvelope the message body for e
+func MarshalEnvelope(e IncomingEvent) ([]byte, error) {
+	envelope, err := NewEnvelope(e)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope)
+}
+
+// UnmarshalEnvelope decodes a message body written by MarshalEnvelope back into its event
+func UnmarshalEnvelope(body []byte) (IncomingEvent, error) {
+	var envelope Envelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, err
+	}
+
+	if envelope.Type == "" || len(envelope.Payload) == 0 {
+		return nil, ErrNotAnEnvelope
+	}
+	return DefaultRegistry.DecodeAs(envelope.Type, envelope.Payload)
+}
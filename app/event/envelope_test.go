@@ -0,0 +1,63 @@
+package event
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type EnvelopeSuite struct {
+	suite.Suite
+}
+
+func (suite *EnvelopeSuite) Test_MarshalEnvelope() {
+	suite.Run("questionnaire completed event occurred when it was completed", func() {
+		completed := &QuestionnaireCompletedEvent{
+			Name:                 QuestionnaireCompleted,
+			Id:                   "ABC123",
+			UserId:               "PARTICIPANT1",
+			QuestionnaireId:      "QUESTIONNAIRE1",
+			CompletedAt:          "2022-07-18T10:00:00+01:00",
+			RemainingCompletions: 2,
+		}
+
+		body, err := MarshalEnvelope(completed)
+		suite.Require().NoError(err)
+
+		var envelope Envelope
+		suite.Require().NoError(json.Unmarshal(body, &envelope))
+		suite.Equal(QuestionnaireCompleted, envelope.Type)
+		suite.Equal(QuestionnaireCompletedSchemaVersion, envelope.SchemaVersion)
+		suite.Equal("ABC123", envelope.EventId)
+		suite.Equal(time.Date(2022, 7, 18, 9, 0, 0, 0, time.UTC), envelope.OccurredAt)
+
+		var payload QuestionnaireCompletedEvent
+		suite.Require().NoError(json.Unmarshal(envelope.Payload, &payload))
+		suite.Equal(*completed, payload)
+	})
+
+	suite.Run("scheduled questionnaire event occurred when it was created", func() {
+		createdAt := time.Date(2022, 7, 18, 10, 0, 0, 0, time.UTC)
+		scheduled := &ScheduledQuestionnaireEvent{
+			Name:        ScheduledQuestionnaire,
+			Id:          "XYZ987",
+			ScheduledAt: createdAt.Add(24 * time.Hour),
+			CreatedAt:   createdAt,
+		}
+
+		envelope, err := NewEnvelope(&OutboxedEvent{IncomingEvent: scheduled, OutboxId: "OUTBOX1"})
+		suite.Require().NoError(err)
+		suite.Equal(ScheduledQuestionnaire, envelope.Type)
+		suite.Equal("XYZ987", envelope.EventId)
+		suite.Equal(createdAt, envelope.OccurredAt)
+
+		var payload ScheduledQuestionnaireEvent
+		suite.Require().NoError(json.Unmarshal(envelope.Payload, &payload))
+		suite.Equal(*scheduled, payload)
+	})
+}
+
+func TestEnvelopeSuite(t *testing.T) {
+	suite.Run(t, new(EnvelopeSuite))
+}
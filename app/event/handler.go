@@ -2,60 +2,150 @@ package event
 
 import (
 	"context"
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"log"
 	"sync"
 	"time"
 )
 
-// Queue A queue for Asynchronous processing of SQS
+// Queue A queue for Asynchronous processing of SQS. PopContext blocks until there's something to pop, or the context is
+// done. Push fails if the queue is full and its overflow policy can't make room
 type Queue interface {
 	Pop() IncomingEvent
-	Push(instruction IncomingEvent)
+	PopContext(ctx context.Context) (IncomingEvent, error)
+	Push(instruction IncomingEvent) error
+	Len() int
+}
+
+// Publisher sends events on to whatever is listening downstream, e.g. an SQS queue or SNS topic. PublishBatch returns
+// one error per event, in the same order as the events, with nil for every event that was published
+type Publisher interface {
+	Publish(e IncomingEvent) error
+	PublishBatch(events IncomingEvents) []error
 }
 
 type IncomingEvent interface {
 	FunctionName() string
+	EventId() string
+	SchemaVersion() int
+	OccurredAt() time.Time
+	PartitionKey() string
 	ToSQSMessage() map[string]*sqs.MessageAttributeValue
-	HandleEvent(ctx context.Context) error
+	HandleEvent(ctx context.Context, deps *Dependencies) error
 }
 type IncomingEvents []IncomingEvent
 
-// StartAsynchronousEventProcessor a background process that pops events off a queue and sends them out to SQS
-func StartAsynchronousEventProcessor(ctx context.Context, wg *sync.WaitGroup, c <-chan bool) {
+// StartAsynchronousEventProcessor a background process that pops events off a queue and sends them out through the
+// publisher. Any events left in the outbox from a previous run are queued up once it has started. Events are spread
+// across a pool of workers by partition key, so events for the same participant are always sent by the same worker, in
+// order, while different participants are sent in parallel. Each worker has its own backlog, so a worker that's
+// backing off a failed send doesn't hold up events for the others.
+//
+// Once the context is cancelled the processor drains: everything still on the queue is sent for up to the drain
+// timeout, and whatever can't be sent in time is left in the outbox for the next run. The WaitGroup is done once the
+// drain has finished
+func StartAsynchronousEventProcessor(ctx context.Context, wg *sync.WaitGroup, deps *Dependencies) error {
+	if err := deps.Validate(); err != nil {
+		return err
+	}
+
+	dbConn := deps.DB
+	timer := deps.Timer
+	idGenny := deps.IdGenny
+	config := deps.Processor
+	eventsQueue := deps.Queue
+	publisher := deps.Publisher
+	retryHandler := &RetryHandler{
+		DB:      dbConn,
+		IdGenny: idGenny,
+		Timer:   timer,
+		Config:  deps.Retry,
+	}
+
+	// drainCtx is cancelled once the drain timeout is up, after that nothing else is sent
+	drainCtx, cancelDrain := context.WithCancel(context.Background())
+	drain := &drainer{db: dbConn, idGenny: idGenny, timer: timer}
+
+	var workersWg sync.WaitGroup
+	workers := make([]*publishWorker, config.GetWorkers())
+	backlogs := make([]chan<- IncomingEvent, len(workers))
+	for i := range workers {
+		workers[i] = &publishWorker{
+			events:       make(chan IncomingEvent, config.GetBatchSize()),
+			db:           dbConn,
+			timer:        timer,
+			publisher:    publisher,
+			retryHandler: retryHandler,
+			config:       config,
+			drain:        drain,
+		}
+		backlogs[i] = backlog(workers[i].events)
+		workersWg.Add(1)
+		go workers[i].run(ctx, drainCtx, &workersWg)
+	}
+
+	dispatch := func(e IncomingEvent, done <-chan struct{}) bool {
+		select {
+		case backlogs[partition(e.PartitionKey(), len(workers))] <- e:
+			return true
+		case <-done:
+			return false
+		}
+	}
+
 	wg.Add(1)
 	go func() {
+		defer wg.Done()
+		defer cancelDrain()
+
+		// an event popped just as the context was cancelled is the first to be drained
+		var pending IncomingEvent
 		for {
-			select {
-			case <-c:
-				log.Println("SQS queue shutting down")
-				wg.Done()
-				return
-
-			default:
-				eventsQueue := ctx.Value("eventsQueue").(Queue)
-				svc := ctx.Value("svc").(*sqs.SQS)
-				svcQueueUrl := ctx.Value("scsQueueUrl").(*string)
-
-				if queuedEvent := eventsQueue.Pop(); queuedEvent != nil {
-					_, err := svc.SendMessage(&sqs.SendMessageInput{
-						DelaySeconds:      aws.Int64(10),
-						MessageAttributes: queuedEvent.ToSQSMessage(),
-						MessageBody:       aws.String("Information about current NY Times fiction bestseller for week of 12/11/2016."),
-						QueueUrl:          svcQueueUrl,
-					})
-
-					// If we fail to submit the event to SQS, log the error. A better solution would be some sort of automatic
-					// retry and after N attempts, store as a failure in an "events" table or something. This would allow us to
-					// manually trigger a message via a console or something after the issue has been resolved.
-					if err != nil {
-						log.Printf("failed to submit event %s to SQS: %s", queuedEvent.FunctionName(), err)
-					}
-				}
-
-				time.Sleep(10 * time.Millisecond) // reduce CPU usage, less spam
+			// blocks until there's something to send
+			queuedEvent, err := eventsQueue.PopContext(ctx)
+			if err != nil {
+				break
+			}
+
+			if !dispatch(queuedEvent, ctx.Done()) {
+				pending = queuedEvent
+				break
 			}
 		}
+
+		log.Printf("event processor draining %d queued events, for up to %s", eventsQueue.Len(), config.DrainTimeout)
+		stopDrain := time.AfterFunc(config.DrainTimeout, cancelDrain)
+		defer stopDrain.Stop()
+
+		next := func() IncomingEvent {
+			if e := pending; e != nil {
+				pending = nil
+				return e
+			}
+			return eventsQueue.Pop()
+		}
+
+		for queuedEvent := next(); queuedEvent != nil; queuedEvent = next() {
+			if drainCtx.Err() != nil || !dispatch(queuedEvent, drainCtx.Done()) {
+				drain.persist(queuedEvent)
+			}
+		}
+
+		for _, b := range backlogs {
+			close(b)
+		}
+		workersWg.Wait()
+
+		log.Printf("event processor shut down: %d events flushed, %d left over in the outbox for the next run",
+			drain.Flushed(), drain.LeftOver())
 	}()
+
+	// the outbox is requeued once the processor is running, so a backlog bigger than the queue is sent as it's pushed
+	// instead of filling the queue up with nothing to take events off it
+	if requeued, err := RequeuePendingOutboxEvents(ctx, dbConn, eventsQueue); err != nil {
+		log.Printf("failed to requeue pending outbox events: %s", err)
+	} else if requeued > 0 {
+		log.Printf("requeued %d pending outbox events", requeued)
+	}
+	return nil
 }
@@ -0,0 +1,124 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"github.com/jamesineda/reschedular/app/db"
+	"github.com/jamesineda/reschedular/app/utils"
+	"github.com/stretchr/testify/suite"
+	"sync"
+	"testing"
+	"time"
+)
+
+// chanQueue an unbounded queue that's only as much as the processor needs
+type chanQueue struct {
+	Queue
+	events chan IncomingEvent
+}
+
+func (q *chanQueue) Pop() IncomingEvent {
+	select {
+	case e := <-q.events:
+		return e
+	default:
+		return nil
+	}
+}
+
+func (q *chanQueue) PopContext(ctx context.Context) (IncomingEvent, error) {
+	select {
+	case e := <-q.events:
+		return e, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (q *chanQueue) Push(e IncomingEvent) error {
+	q.events <- e
+	return nil
+}
+
+func (q *chanQueue) Len() int {
+	return len(q.events)
+}
+
+// partitionPublisher fails every event for one partition key, and records everything else it publishes
+type partitionPublisher struct {
+	sync.Mutex
+	failing   string
+	published IncomingEvents
+}
+
+func (p *partitionPublisher) Publish(e IncomingEvent) error {
+	p.Lock()
+	defer p.Unlock()
+	if e.PartitionKey() == p.failing {
+		return fmt.Errorf("publish failed")
+	}
+	p.published = append(p.published, e)
+	return nil
+}
+
+func (p *partitionPublisher) PublishBatch(events IncomingEvents) []error {
+	errs := make([]error, len(events))
+	for i, e := range events {
+		errs[i] = p.Publish(e)
+	}
+	return errs
+}
+
+func (p *partitionPublisher) Published() int {
+	p.Lock()
+	defer p.Unlock()
+	return len(p.published)
+}
+
+type HandlerSuite struct {
+	suite.Suite
+}
+
+func (suite *HandlerSuite) Test_StartAsynchronousEventProcessor() {
+	suite.Run("a worker backing off a failed send doesn't hold up the other workers", func() {
+		// a participant that's sent by a different worker to PARTICIPANT1
+		other := "PARTICIPANT2"
+		for i := 3; partition(other, 2) == partition("PARTICIPANT1", 2); i++ {
+			other = fmt.Sprintf("PARTICIPANT%d", i)
+		}
+
+		dbConn, _ := db.NewFakeDatabaseConn(&db.FakeSQLX{})
+		timer := utils.NewFakeTimer(time.Date(2022, 7, 18, 10, 0, 0, 0, time.UTC))
+		publisher := &partitionPublisher{failing: "PARTICIPANT1"}
+		queue := &chanQueue{events: make(chan IncomingEvent, 20)}
+
+		// more events for PARTICIPANT1 than its worker can hold while it's backing off, followed by the other participant's
+		for i := 0; i < 5; i++ {
+			suite.Require().NoError(queue.Push(scheduledFor(fmt.Sprintf("ABC%d", i), "PARTICIPANT1")))
+		}
+		for i := 0; i < 3; i++ {
+			suite.Require().NoError(queue.Push(scheduledFor(fmt.Sprintf("DEF%d", i), other)))
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		var wg sync.WaitGroup
+		suite.Require().NoError(StartAsynchronousEventProcessor(ctx, &wg, &Dependencies{
+			DB:          dbConn,
+			Timer:       timer,
+			IdGenny:     utils.NewFakeIdGenny("ID1"),
+			Publisher:   publisher,
+			Queue:       queue,
+			Retry:       &utils.RetryConfig{MaxAttempts: 3, InitialBackoff: time.Hour, Multiplier: 1},
+			Processor:   &utils.ProcessorConfig{BatchSize: 1, FlushInterval: time.Millisecond, Workers: 2, DrainTimeout: time.Millisecond},
+			Idempotency: NewDBIdempotencyStore(dbConn),
+		}))
+
+		suite.Eventually(func() bool { return publisher.Published() == 3 }, time.Second, time.Millisecond)
+		cancel()
+		wg.Wait()
+	})
+}
+
+func TestHandlerSuite(t *testing.T) {
+	suite.Run(t, new(HandlerSuite))
+}
@@ -0,0 +1,96 @@
+package event
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/jamesineda/reschedular/app/db"
+	"github.com/jamesineda/reschedular/app/models"
+	"time"
+)
+
+// outcomes recorded against a processed event, each maps back onto the result HandleEvent originally returned
+const (
+	OutcomeScheduled        = "SCHEDULED"
+	OutcomeMaxAttempts      = "MAX_ATTEMPTS_REACHED"
+	OutcomeAlreadyCompleted = "ALREADY_COMPLETED"
+	OutcomeAdhoc            = "ADHOC"
+)
+
+// IdempotencyStore remembers which incoming events have already been handled, and what happened to them. Processed
+// events are recorded by the handler in the same transaction as the rows they produced, so the store only looks them up
+type IdempotencyStore interface {
+	// Lookup returns nil when the event hasn't been processed yet
+	Lookup(eventId string) (*models.ProcessedEvent, error)
+}
+
+// DBIdempotencyStore looks processed events up in the processed_events table
+type DBIdempotencyStore struct {
+	DB db.Client
+}
+
+func NewDBIdempotencyStore(dbConn db.Client) *DBIdempotencyStore {
+	return &DBIdempotencyStore{DB: dbConn}
+}
+
+func (s *DBIdempotencyStore) Lookup(eventId string) (*models.ProcessedEvent, error) {
+	row, err := s.DB.GetById(eventId, &models.ProcessedEvent{})
+	switch err {
+	case nil:
+		return row.(*models.ProcessedEvent), nil
+	case sql.ErrNoRows:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("failed to look up processed event (id: %s): %v", eventId, err)
+	}
+}
+
+// NewProcessedEvent builds the processed_events row for an event that was handled with the given result
+func NewProcessedEvent(e IncomingEvent, result error, scheduledQuestionnaireId string, processedAt time.Time) (*models.ProcessedEvent, error) {
+	outcome, err := outcomeOf(result)
+	if err != nil {
+		return nil, err
+	}
+
+	row := &models.ProcessedEvent{
+		Id:          e.EventId(),
+		EventType:   e.FunctionName(),
+		Outcome:     outcome,
+		ProcessedAt: processedAt,
+	}
+	if scheduledQuestionnaireId != "" {
+		row.ScheduledQuestionnaireId = sql.NullString{Valid: true, String: scheduledQuestionnaireId}
+	}
+	return row, nil
+}
+
+// ProcessedResult the result HandleEvent returned the first time the event was processed
+func ProcessedResult(row *models.ProcessedEvent) error {
+	switch row.Outcome {
+	case OutcomeScheduled:
+		return nil
+	case OutcomeMaxAttempts:
+		return ErrMaxAttemptsReached
+	case OutcomeAlreadyCompleted:
+		return ErrScheduledQuestionnaireIsAlreadyCompleted
+	case OutcomeAdhoc:
+		return ErrAdhocQuestionnaireCompleted
+	default:
+		return fmt.Errorf("processed event (id: %s) has an unknown outcome: %s", row.Id, row.Outcome)
+	}
+}
+
+// outcomeOf only handled results are recorded, anything else should be retried when the event is redelivered
+func outcomeOf(result error) (string, error) {
+	switch result {
+	case nil:
+		return OutcomeScheduled, nil
+	case ErrMaxAttemptsReached:
+		return OutcomeMaxAttempts, nil
+	case ErrScheduledQuestionnaireIsAlreadyCompleted:
+		return OutcomeAlreadyCompleted, nil
+	case ErrAdhocQuestionnaireCompleted:
+		return OutcomeAdhoc, nil
+	default:
+		return "", fmt.Errorf("can't record an unhandled result: %v", result)
+	}
+}
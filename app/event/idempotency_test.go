@@ -0,0 +1,105 @@
+package event
+
+import (
+	"context"
+	"database/sql"
+	"github.com/jamesineda/reschedular/app/db"
+	"github.com/jamesineda/reschedular/app/models"
+	"github.com/jamesineda/reschedular/app/utils"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+// fakeIdempotencyStore returns whatever has been processed, keyed on the event id
+type fakeIdempotencyStore map[string]*models.ProcessedEvent
+
+func (f fakeIdempotencyStore) Lookup(eventId string) (*models.ProcessedEvent, error) {
+	return f[eventId], nil
+}
+
+// pushedQueue only records what's pushed onto it
+type pushedQueue struct {
+	Queue
+	pushed []IncomingEvent
+}
+
+func (q *pushedQueue) Push(e IncomingEvent) error {
+	q.pushed = append(q.pushed, e)
+	return nil
+}
+
+type IdempotencySuite struct {
+	suite.Suite
+	Timer utils.Timer
+	Event *QuestionnaireCompletedEvent
+}
+
+func (suite *IdempotencySuite) SetupTest() {
+	suite.Timer = utils.NewFakeTimer(time.Date(2022, 7, 18, 10, 0, 0, 0, time.UTC))
+	suite.Event = &QuestionnaireCompletedEvent{
+		Name:            QuestionnaireCompleted,
+		Id:              "COMPLETED1",
+		UserId:          "PARTICIPANT1",
+		QuestionnaireId: "QUESTIONNAIRE1",
+	}
+}
+
+func (suite *IdempotencySuite) Test_NewProcessedEvent() {
+	suite.Run("when a schedule was created", func() {
+		row, err := NewProcessedEvent(suite.Event, nil, "SCHEDULED1", suite.Timer.GetTimeNow())
+		suite.Require().NoError(err)
+		suite.Equal(&models.ProcessedEvent{
+			Id:                       "COMPLETED1",
+			EventType:                QuestionnaireCompleted,
+			Outcome:                  OutcomeScheduled,
+			ScheduledQuestionnaireId: sql.NullString{Valid: true, String: "SCHEDULED1"},
+			ProcessedAt:              suite.Timer.GetTimeNow(),
+		}, row)
+		suite.NoError(ProcessedResult(row))
+	})
+
+	suite.Run("handled outcomes map back onto the original result", func() {
+		for _, result := range []error{ErrMaxAttemptsReached, ErrScheduledQuestionnaireIsAlreadyCompleted, ErrAdhocQuestionnaireCompleted} {
+			row, err := NewProcessedEvent(suite.Event, result, "", suite.Timer.GetTimeNow())
+			suite.Require().NoError(err)
+			suite.False(row.ScheduledQuestionnaireId.Valid)
+			suite.Equal(result, ProcessedResult(row))
+		}
+	})
+
+	suite.Run("unhandled errors aren't recorded, so the event is retried", func() {
+		_, err := NewProcessedEvent(suite.Event, sql.ErrConnDone, "", suite.Timer.GetTimeNow())
+		suite.Error(err)
+	})
+}
+
+func (suite *IdempotencySuite) Test_HandleEvent_Redelivered() {
+	dbConn, _ := db.NewFakeDatabaseConn(&db.FakeSQLX{})
+	eventsQueue := &pushedQueue{}
+	deps := &Dependencies{
+		DB:      dbConn,
+		Timer:   suite.Timer,
+		IdGenny: utils.NewFakeIdGenny("ABC123"),
+		Queue:   eventsQueue,
+		Idempotency: fakeIdempotencyStore{
+			"COMPLETED1": {Id: "COMPLETED1", EventType: QuestionnaireCompleted, Outcome: OutcomeAdhoc},
+			"COMPLETED2": {Id: "COMPLETED2", EventType: QuestionnaireCompleted, Outcome: OutcomeScheduled},
+		},
+	}
+
+	suite.Run("returns the original result without publishing again", func() {
+		suite.Equal(ErrAdhocQuestionnaireCompleted, suite.Event.HandleEvent(context.Background(), deps))
+		suite.Empty(eventsQueue.pushed)
+	})
+
+	suite.Run("a scheduled event isn't scheduled twice", func() {
+		suite.Event.Id = "COMPLETED2"
+		suite.NoError(suite.Event.HandleEvent(context.Background(), deps))
+		suite.Empty(eventsQueue.pushed)
+	})
+}
+
+func TestIdempotencySuite(t *testing.T) {
+	suite.Run(t, new(IdempotencySuite))
+}
@@ -0,0 +1,92 @@
+package event
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/jamesineda/reschedular/app/db"
+	"github.com/jamesineda/reschedular/app/models"
+	"github.com/jamesineda/reschedular/app/utils"
+	"log"
+	"time"
+)
+
+// OutboxedEvent an IncomingEvent that has a matching row in the outbox_events table. Once the event has been sent, the
+// processor uses the OutboxId to mark the row as sent, so it isn't picked up again after a restart. Attempts counts the
+// failed sends so far
+type OutboxedEvent struct {
+	IncomingEvent
+	OutboxId string
+	Attempts int
+}
+
+// MarshalJSON only the wrapped event is serialised, the outbox details never leave the service
+func (o *OutboxedEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal(o.IncomingEvent)
+}
+
+// NewOutboxEvent serialises an event into an outbox_events row, returning the row along with the queue entry for it
+func NewOutboxEvent(idGenny utils.IdGenny, timer utils.Timer, e IncomingEvent) (*models.OutboxEvent, *OutboxedEvent, error) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to serialise %s event for the outbox: %v", e.FunctionName(), err)
+	}
+
+	row := &models.OutboxEvent{
+		Id:        idGenny.GenerateId(),
+		EventType: e.FunctionName(),
+		Payload:   string(payload),
+		CreatedAt: timer.GetTimeNow(),
+	}
+	return row, &OutboxedEvent{IncomingEvent: e, OutboxId: row.Id}, nil
+}
+
+// DecodeOutboxEvent rebuilds the queue entry from an outbox_events row
+func DecodeOutboxEvent(row *models.OutboxEvent) (*OutboxedEvent, error) {
+	e, err := DefaultRegistry.DecodeAs(row.EventType, []byte(row.Payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode outbox event (id: %s): %v", row.Id, err)
+	}
+	return &OutboxedEvent{IncomingEvent: e, OutboxId: row.Id}, nil
+}
+
+// RequeuePendingOutboxEvents pushes every unsent outbox row back onto the events queue, this picks up anything that was
+// still waiting to be sent when the process last stopped. It stops once the context is done, leaving the rest for the
+// next restart
+func RequeuePendingOutboxEvents(ctx context.Context, dbConn db.Client, eventsQueue Queue) (requeued int, err error) {
+	var rows models.OutboxEvents
+	err = dbConn.GetList(&rows, db.Filters{db.IsNull("sent_at"), db.IsNull("failed_at")}, db.OrderBy(db.Asc("created_at")))
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to query pending outbox_events from database: %v", err)
+	}
+
+	for _, row := range rows.Pending() {
+		if ctx.Err() != nil {
+			return requeued, fmt.Errorf("stopped requeueing outbox events: %v", ctx.Err())
+		}
+
+		e, err := DecodeOutboxEvent(row)
+		if err != nil {
+			// leave the row unsent, so it can be looked at by hand
+			log.Println(err)
+			continue
+		}
+		if err = eventsQueue.Push(e); err != nil {
+			// the rest stay in the outbox until the next restart
+			return requeued, fmt.Errorf("failed to requeue outbox event (id: %s): %v", row.Id, err)
+		}
+		requeued++
+	}
+	return requeued, nil
+}
+
+func MarkOutboxEventSent(dbConn db.Client, outboxId string, sentAt time.Time) error {
+	return dbConn.Update(&models.OutboxEvent{Id: outboxId, SentAt: &sentAt}, "sent_at")
+}
+
+func MarkOutboxEventFailed(dbConn db.Client, outboxId string, failedAt time.Time) error {
+	return dbConn.Update(&models.OutboxEvent{Id: outboxId, FailedAt: &failedAt}, "failed_at")
+}
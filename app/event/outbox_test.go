@@ -0,0 +1,68 @@
+package event
+
+import (
+	"github.com/jamesineda/reschedular/app/models"
+	"github.com/jamesineda/reschedular/app/utils"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type OutboxSuite struct {
+	suite.Suite
+	IdGenny utils.IdGenny
+	Timer   utils.Timer
+}
+
+func (suite *OutboxSuite) SetupTest() {
+	suite.IdGenny = utils.NewFakeIdGenny("OUTBOX1")
+	suite.Timer = utils.NewFakeTimer(time.Date(2022, 7, 18, 10, 0, 0, 0, time.UTC))
+}
+
+func (suite *OutboxSuite) Test_NewOutboxEvent() {
+	scheduled := &ScheduledQuestionnaireEvent{
+		Name:            ScheduledQuestionnaire,
+		Id:              "ABC123",
+		ParticipantId:   "PARTICIPANT1",
+		QuestionnaireId: "QUESTIONNAIRE1",
+		Status:          Pending,
+		ScheduledAt:     time.Date(2022, 7, 19, 10, 0, 0, 0, time.UTC),
+	}
+
+	row, outboxed, err := NewOutboxEvent(suite.IdGenny, suite.Timer, scheduled)
+	suite.Require().NoError(err)
+
+	suite.Run("row is unsent and typed by the event's function name", func() {
+		suite.Equal("OUTBOX1", row.Id)
+		suite.Equal(ScheduledQuestionnaire, row.EventType)
+		suite.Equal(suite.Timer.GetTimeNow(), row.CreatedAt)
+		suite.False(row.IsSent())
+	})
+
+	suite.Run("queued event refers back to the row", func() {
+		suite.Equal(row.Id, outboxed.OutboxId)
+		suite.Equal(scheduled, outboxed.IncomingEvent)
+	})
+
+	suite.Run("row decodes back into the original event", func() {
+		decoded, err := DecodeOutboxEvent(row)
+		suite.Require().NoError(err)
+		suite.Equal(outboxed, decoded)
+	})
+}
+
+func (suite *OutboxSuite) Test_DecodeOutboxEvent() {
+	suite.Run("when the event type is unknown", func() {
+		_, err := DecodeOutboxEvent(&models.OutboxEvent{Id: "OUTBOX1", EventType: "UNKNOWN", Payload: "{}"})
+		suite.Error(err)
+	})
+
+	suite.Run("when the payload is not valid JSON", func() {
+		_, err := DecodeOutboxEvent(&models.OutboxEvent{Id: "OUTBOX1", EventType: QuestionnaireCompleted, Payload: "{"})
+		suite.Error(err)
+	})
+}
+
+func TestOutboxSuite(t *testing.T) {
+	suite.Run(t, new(OutboxSuite))
+}
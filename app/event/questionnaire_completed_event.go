@@ -8,17 +8,37 @@ import (
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/jamesineda/reschedular/app/db"
 	"github.com/jamesineda/reschedular/app/models"
-	"github.com/jamesineda/reschedular/app/utils"
+	"github.com/jamesineda/reschedular/app/recurrence"
 	"log"
 	"strconv"
 	"time"
 )
 
 const (
-	QuestionnaireCompleted = "QUESTIONNAIRE_COMPLETED"
+	QuestionnaireCompleted              = "QUESTIONNAIRE_COMPLETED"
+	QuestionnaireCompletedSchemaVersion = 1
 )
 
 var ErrAdhocQuestionnaireCompleted = fmt.Errorf("an adhoc questionnaire was completed")
+var ErrEventAlreadyProcessed = fmt.Errorf("event has already been processed")
+
+// scheduleTxOptions serializable, so a concurrent completion can't change the results counted by CanAttempt before the
+// schedule is inserted
+var scheduleTxOptions = &sql.TxOptions{Isolation: sql.LevelSerializable}
+
+// scheduleTxAttempts how many times the schedule transaction is run when it loses out to a concurrent completion, the
+// database rolls one of them back rather than letting both insert a schedule
+const scheduleTxAttempts = 3
+
+// IsHandledOutcome HandleEvent returns these errors when the completion was handled, but didn't need a new schedule
+func IsHandledOutcome(err error) bool {
+	switch err {
+	case ErrMaxAttemptsReached, ErrScheduledQuestionnaireIsAlreadyCompleted, ErrAdhocQuestionnaireCompleted:
+		return true
+	default:
+		return false
+	}
+}
 
 // QuestionnaireCompletedEvent provides an interface to handle QuestionnaireCompleted events via SQS message transmission
 // and Lambda call
@@ -34,13 +54,30 @@ type QuestionnaireCompletedEvent struct {
 
 // GetCompletedAt I've not seen a completedAt time format, so let's pretend all timestamps sent via APIs is in RFC3339
 func (q *QuestionnaireCompletedEvent) GetCompletedAt() time.Time {
-	t, _ := time.Parse(q.CompletedAt, time.RFC3339)
+	t, _ := time.Parse(time.RFC3339, q.CompletedAt)
 	return t
 }
 func (q *QuestionnaireCompletedEvent) FunctionName() string {
 	return q.Name
 }
 
+func (q *QuestionnaireCompletedEvent) EventId() string {
+	return q.Id
+}
+
+func (q *QuestionnaireCompletedEvent) SchemaVersion() int {
+	return QuestionnaireCompletedSchemaVersion
+}
+
+func (q *QuestionnaireCompletedEvent) OccurredAt() time.Time {
+	return q.GetCompletedAt()
+}
+
+// PartitionKey events for the same participant are published in order
+func (q *QuestionnaireCompletedEvent) PartitionKey() string {
+	return q.UserId
+}
+
 func (q *QuestionnaireCompletedEvent) ToSQSMessage() map[string]*sqs.MessageAttributeValue {
 	return map[string]*sqs.MessageAttributeValue{
 		"Id": &sqs.MessageAttributeValue{
@@ -73,100 +110,271 @@ func (q *QuestionnaireCompletedEvent) ToSQSMessage() map[string]*sqs.MessageAttr
 /*
 	TODO: Would-be handling of a specific QuestionnaireCompleteEvent if that's something that can be invoked by lambda
 */
-func (event *QuestionnaireCompletedEvent) HandleEvent(ctx context.Context) (err error) {
-	dbConn := ctx.Value("db").(db.Client)
-	idGenny := ctx.Value("idGenny").(utils.IdGenny)
-	var scheduledQuestionnaire *models.ScheduledQuestionnaire = nil
+func (event *QuestionnaireCompletedEvent) HandleEvent(ctx context.Context, deps *Dependencies) (err error) {
+	dbConn := deps.DB
+	var outboxed *OutboxedEvent = nil
+	// duplicate is set when the event has already been processed, there's nothing left to publish
+	duplicate := false
 
 	defer func() {
-		eventsQueue := ctx.Value("eventsQueue").(Queue)
-		event.handleDeferFunc(err, eventsQueue, scheduledQuestionnaire)
+		if duplicate {
+			return
+		}
+		event.handleDeferFunc(err, deps.Queue, outboxed)
 	}()
 
-	//	2. Determine if a new questionnaire schedule should be saved to the database.
-	questionnaireRow, err := dbConn.GetById(event.QuestionnaireId, &models.Questionnaire{})
+	// SQS and lambda are at-least-once, so a redelivered event gets the result it had first time around
+	processed, err := deps.Idempotency.Lookup(event.Id)
 	if err != nil {
-		err = fmt.Errorf("failed to get Questionnaire (id: %s) from database: %v", event.QuestionnaireId, err)
 		return
 	}
+	if processed != nil {
+		log.Printf("%s event (id: %s) has already been processed, skipping", event.FunctionName(), event.Id)
+		duplicate = true
+		err = ProcessedResult(processed)
+		return
+	}
+
+	// the reads and the insert share a transaction, otherwise two completions arriving together could both pass
+	// CanAttempt and both insert a schedule. Whichever transaction the database rolls back is run again
+	var txOutboxed *OutboxedEvent
+	var outcome error
+	err = db.WithTxRetry(ctx, dbConn, scheduleTxOptions, scheduleTxAttempts, func(tx db.Client) (txErr error) {
+		outcome = nil
+		txOutboxed, txErr = event.schedule(tx, deps)
+		if IsHandledOutcome(txErr) {
+			// no new schedule, but the status changes to the existing ones are committed along with the event itself
+			// going into the outbox
+			outcome = txErr
+			txOutboxed, txErr = event.outboxCompleted(tx, deps, outcome)
+		}
+		return
+	})
+	if err == nil {
+		outboxed, err = txOutboxed, outcome
+	} else if original, ok := alreadyProcessed(deps.Idempotency, event.Id); ok {
+		// a concurrent delivery of the same event beat us to it, its schedule is the one that counts
+		duplicate = true
+		err = ProcessedResult(original)
+	}
+	return
+}
+
+// outboxCompleted writes the event itself to the outbox, along with its processed_events row, within the same
+// transaction as the status changes that led to it being sent
+func (event *QuestionnaireCompletedEvent) outboxCompleted(tx db.Client, deps *Dependencies, outcome error) (*OutboxedEvent, error) {
+	row, outboxed, err := NewOutboxEvent(deps.IdGenny, deps.Timer, event)
+	if err != nil {
+		return nil, err
+	}
+	processed, err := NewProcessedEvent(event, outcome, "", deps.Timer.GetTimeNow())
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.CreateAll(row, processed); err != nil {
+		return nil, err
+	}
+	return outboxed, nil
+}
+
+// schedule runs within the transaction opened by HandleEvent, returning the outboxed scheduled_questionnaire created
+// message when a new schedule was inserted, or one of the handled outcomes when it wasn't
+func (event *QuestionnaireCompletedEvent) schedule(tx db.Client, deps *Dependencies) (*OutboxedEvent, error) {
+	idGenny := deps.IdGenny
+	timer := deps.Timer
+
+	//	2. Determine if a new questionnaire schedule should be saved to the database.
+	questionnaireRow, err := tx.GetById(event.QuestionnaireId, &models.Questionnaire{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Questionnaire (id: %s) from database: %v", event.QuestionnaireId, err)
+	}
 	questionnaire := questionnaireRow.(*models.Questionnaire)
 
-	participantRow, err := dbConn.GetById(event.UserId, &models.Participant{})
+	participantRow, err := tx.GetById(event.UserId, &models.Participant{})
 	if err != nil {
 		// making sure the participant exists in the database
-		err = fmt.Errorf("failed to get participant (id: %s) from database: %v", event.UserId, err)
-		return
+		return nil, fmt.Errorf("failed to get participant (id: %s) from database: %v", event.UserId, err)
 	}
 	participant := participantRow.(*models.Participant)
 
 	// checking to see if the questionnaire relates to a scheduled_questionnaire
 	scheduledQuestionnairesArgs := db.Filters{
-		{"questionnaire_id", "=", questionnaire.Id},
-		{"participant_id", "=", participant.Id}}
-	var scheduledQuestionnaires models.ScheduledQuestionnaires
-	err = dbConn.GetList(&scheduledQuestionnaires, scheduledQuestionnairesArgs)
+		db.Eq("questionnaire_id", questionnaire.Id),
+		db.Eq("participant_id", participant.Id)}
+	hasSchedule, err := tx.Exists(&models.ScheduledQuestionnaire{}, scheduledQuestionnairesArgs)
 
-	switch err {
-	case nil:
+	switch {
+	case err == nil && hasSchedule:
 		existingResultsArgs := db.Filters{
-			{"questionnaire_id", "=", questionnaire.Id},
-			{"participant_id", "=", participant.Id},
-			{"questionnaire_schedule_id", "=", event.Id}}
-
-		var existingResults models.QuestionnaireResults
-		err = dbConn.GetList(&existingResults, existingResultsArgs)
-		if err != nil && err != sql.ErrNoRows {
-			err = fmt.Errorf("failed to query existing_results (questionnaire_id: %s, participant_id: %s) from database: %v",
+			db.Eq("questionnaire_id", questionnaire.Id),
+			db.Eq("participant_id", participant.Id),
+			db.Eq("questionnaire_schedule_id", event.Id)}
+
+		// only the number of results matters here, so there's no need to load their answers
+		existingResults, err := tx.Count(&models.QuestionnaireResult{}, existingResultsArgs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query existing_results (questionnaire_id: %s, participant_id: %s) from database: %v",
 				event.QuestionnaireId, event.UserId, err)
-			return
+		}
+
+		// whatever was due by the time the questionnaire was completed has now been done. scheduled_at is stored in UTC,
+		// and some databases compare it as text, so the completion time has to be in UTC too
+		completedAt := event.GetCompletedAt().UTC()
+		if err = event.setScheduledStatus(tx, Completed, db.Filters{db.Lte("scheduled_at", completedAt)}); err != nil {
+			return nil, err
 		}
 
 		// assuming remaining completions is the number of scheduled questionnaires a participant has left to complete, then
 		// if zero the service deems questionnaire as complete. I'm also taking into consideration the maximum number of attempts
 		//for a particular questionnaire (based off the number of results?)
-		if event.RemainingCompletions == 0 || !questionnaire.CanAttempt(existingResults.Count()) {
+		if event.RemainingCompletions == 0 || !questionnaire.CanAttempt(existingResults) {
 			log.Printf("maximum number of results reached for questionnaire (id: %s, participant_id %s)",
 				event.QuestionnaireId, event.UserId)
-			err = ErrMaxAttemptsReached
-			return
+			return nil, event.cancelRemaining(tx)
+		}
+
+		scheduledAt, ok, err := event.nextScheduledAt(tx, questionnaire, participant)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			log.Printf("recurrence has ended for questionnaire (id: %s, participant_id %s)", event.QuestionnaireId, event.UserId)
+			return nil, event.cancelRemaining(tx)
 		}
 
 		//	3. If so, save one in the database, and push a new message to SQS that a new schedule has been created.
 		// At this point, we h
-		scheduledQuestionnaire = &models.ScheduledQuestionnaire{
+		scheduledQuestionnaire := &models.ScheduledQuestionnaire{
 			Id:              idGenny.GenerateId(),
 			QuestionnaireId: event.QuestionnaireId,
 			ParticipantId:   event.UserId,
-			ScheduledAt:     event.GetCompletedAt().Add(questionnaire.GetHoursBetweenAttemptsDuration()),
+			ScheduledAt:     scheduledAt,
 			Status:          sql.NullString{Valid: true, String: Pending},
 		}
 
+		// the scheduled_questionnaire created message goes into the outbox within the same transaction as the
+		// scheduled_questionnaire, so it can't be lost if the process restarts before it's sent to SQS
+		outboxRow, scheduledOutboxed, err := NewOutboxEvent(idGenny, timer, &ScheduledQuestionnaireEvent{
+			Name:            ScheduledQuestionnaire,
+			Id:              scheduledQuestionnaire.Id,
+			ParticipantId:   scheduledQuestionnaire.ParticipantId,
+			QuestionnaireId: scheduledQuestionnaire.QuestionnaireId,
+			Status:          scheduledQuestionnaire.Status.String,
+			ScheduledAt:     scheduledQuestionnaire.ScheduledAt,
+			CreatedAt:       timer.GetTimeNow(),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		processedRow, err := NewProcessedEvent(event, nil, scheduledQuestionnaire.Id, timer.GetTimeNow())
+		if err != nil {
+			return nil, err
+		}
+
 		// attempt to insert the scheduled_questionnaire into the database
 		// I'm going to assume updating a scheduled_questionnaire record would be handled in a separate update event? Presumably
 		// but whatever process consumes the QuestionnaireComplete message that this microservices pushes to SQS?
-		err = dbConn.Create(&scheduledQuestionnaire)
-		return
+		if err = tx.CreateAll(scheduledQuestionnaire, outboxRow, processedRow); err != nil {
+			return nil, err
+		}
+		return scheduledOutboxed, nil
 
-	case sql.ErrNoRows:
+	case err == nil:
 		// ad hoc
-		err = ErrAdhocQuestionnaireCompleted
-		return
+		return nil, ErrAdhocQuestionnaireCompleted
 	default:
-		return
+		return nil, fmt.Errorf("failed to query scheduled_questionnaires (questionnaire_id: %s, participant_id: %s) from database: %v",
+			event.QuestionnaireId, event.UserId, err)
+	}
+}
+
+// nextScheduledAt when the next attempt is due, in UTC. It's worked out in the participant's time zone, so rules and
+// delivery windows go by their local time, daylight saving included. A recurrence rule is evaluated from when the
+// participant's schedule started, so it isn't thrown out by attempts completed late. It's then deferred out of the
+// study's blackouts and quiet hours, and back into the delivery window. false once the rule has ended
+func (event *QuestionnaireCompletedEvent) nextScheduledAt(tx db.Client, questionnaire *models.Questionnaire,
+	participant *models.Participant) (time.Time, bool, error) {
+	loc, err := participant.Location()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	window, err := questionnaire.GetDeliveryWindow()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	completedAt := event.GetCompletedAt().In(loc)
+	anchor := completedAt
+	if questionnaire.Recurrence.Valid {
+		first, err := GetFirstSchedule(tx, event.QuestionnaireId, event.UserId)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		anchor = first.ScheduledAt.In(loc)
+	}
+
+	next, ok, err := questionnaire.NextAttemptAt(anchor, completedAt)
+	if err != nil || !ok {
+		return time.Time{}, false, err
+	}
+
+	calendar, err := GetStudyCalendar(tx, questionnaire.StudyId, next)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	constraints := calendar.Constraints()
+	if window != nil {
+		constraints = append(constraints, *window)
 	}
+
+	deferred, ok := recurrence.Earliest(next, constraints...)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("no time to schedule questionnaire (id: %s, participant_id: %s) outside of the study's (id: %s) blackouts and quiet hours",
+			event.QuestionnaireId, event.UserId, questionnaire.StudyId)
+	}
+	if !deferred.Equal(next) {
+		log.Printf("deferred questionnaire (id: %s, participant_id: %s) from %s to %s for the study's (id: %s) calendar",
+			event.QuestionnaireId, event.UserId, next.Format(time.RFC3339), deferred.Format(time.RFC3339), questionnaire.StudyId)
+	}
+	return deferred.UTC(), true, nil
 }
 
-func (event *QuestionnaireCompletedEvent) handleDeferFunc(err error, eventsQueue Queue, scheduledQuestionnaire *models.ScheduledQuestionnaire) {
+// cancelRemaining no more attempts are allowed, so anything still pending won't be completed. It returns
+// ErrMaxAttemptsReached once they've been cancelled
+func (event *QuestionnaireCompletedEvent) cancelRemaining(tx db.Client) error {
+	if err := event.setScheduledStatus(tx, Cancelled, nil); err != nil {
+		return err
+	}
+	return ErrMaxAttemptsReached
+}
+
+// setScheduledStatus moves the participant's pending scheduled_questionnaires for this questionnaire on to status,
+// filters narrows down which of them are changed
+func (event *QuestionnaireCompletedEvent) setScheduledStatus(tx db.Client, status string, filters db.Filters) error {
+	pending := append(db.Filters{
+		db.Eq("questionnaire_id", event.QuestionnaireId),
+		db.Eq("participant_id", event.UserId),
+		db.Eq("status", Pending)}, filters...)
+
+	updated, err := tx.UpdateWhere(&models.ScheduledQuestionnaire{}, pending, map[string]interface{}{"status": status})
+	if err != nil {
+		return fmt.Errorf("failed to mark scheduled_questionnaires (questionnaire_id: %s, participant_id: %s) as %s: %v",
+			event.QuestionnaireId, event.UserId, status, err)
+	}
+	if updated > 0 {
+		log.Printf("marked %d scheduled_questionnaires (questionnaire_id: %s, participant_id: %s) as %s",
+			updated, event.QuestionnaireId, event.UserId, status)
+	}
+	return nil
+}
+
+// handleDeferFunc by the time we get here, whichever message needs sending has already been written to the outbox
+func (event *QuestionnaireCompletedEvent) handleDeferFunc(err error, eventsQueue Queue, outboxed *OutboxedEvent) {
 	switch err {
 	case nil:
 		// pops the scheduled_questionnaire created message onto the events queue for asynchronous SQS transmission
-		eventsQueue.Push(&ScheduledQuestionnaireEvent{
-			Id:              scheduledQuestionnaire.Id,
-			ParticipantId:   scheduledQuestionnaire.ParticipantId,
-			QuestionnaireId: scheduledQuestionnaire.QuestionnaireId,
-			Status:          scheduledQuestionnaire.Status.String,
-			ScheduledAt:     scheduledQuestionnaire.ScheduledAt,
-		})
+		pushOrLog(eventsQueue, outboxed)
 
 	//	4. If not, push a new message to SQS that the user has completed all of their alloted scheduled questionnaires.
 	// so, from this, I'm guessing the three scenarios for this would be if:
@@ -174,10 +382,31 @@ func (event *QuestionnaireCompletedEvent) handleDeferFunc(err error, eventsQueue
 	//		- we've reached our maxiumum number of attempts
 	// 		- it's adhoc and thus doesn't have/ require a scheduled questionnaire record
 	case ErrMaxAttemptsReached, ErrScheduledQuestionnaireIsAlreadyCompleted, ErrAdhocQuestionnaireCompleted:
-		eventsQueue.Push(event)
+		pushOrLog(eventsQueue, outboxed)
 
 	default:
-		// unexpected errors handled here, log and cry about it loudly!
-		log.Fatalf("failed to process scheduled questionnaire event: %s", err)
+		// unexpected errors are returned by HandleEvent, so whatever delivered the event can have it redelivered
+		log.Printf("failed to process %s event (id: %s): %s", event.FunctionName(), event.Id, err)
+	}
+}
+
+// alreadyProcessed checks whether a failed write was down to the event having been processed in the meantime
+func alreadyProcessed(store IdempotencyStore, eventId string) (*models.ProcessedEvent, bool) {
+	processed, err := store.Lookup(eventId)
+	if err != nil || processed == nil {
+		return nil, false
+	}
+	return processed, true
+}
+
+// pushOrLog a full queue doesn't lose outboxed events, they're still in the outbox and get sent after the next restart
+func pushOrLog(eventsQueue Queue, e IncomingEvent) {
+	if err := eventsQueue.Push(e); err != nil {
+		if _, ok := e.(*OutboxedEvent); ok {
+			log.Printf("failed to queue %s event (id: %s), it'll be sent from the outbox after the next restart: %s",
+				e.FunctionName(), e.EventId(), err)
+			return
+		}
+		log.Printf("failed to queue %s event (id: %s), it has been lost: %s", e.FunctionName(), e.EventId(), err)
 	}
 }
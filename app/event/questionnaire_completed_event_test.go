@@ -1,35 +1,471 @@
 package event
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jamesineda/reschedular/app/db"
+	"github.com/jamesineda/reschedular/app/models"
+	"github.com/jamesineda/reschedular/app/utils"
 	"github.com/stretchr/testify/suite"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
+// QuestionnaireCompletedEventSuite runs HandleEvent end-to-end against an in-memory database
 type QuestionnaireCompletedEventSuite struct {
 	suite.Suite
+	DB    *db.MemoryClient
+	Timer utils.Timer
+	Queue *pushedQueue
+	Deps  *Dependencies
+	Event *QuestionnaireCompletedEvent
 }
 
 func (suite *QuestionnaireCompletedEventSuite) SetupTest() {
+	suite.DB = db.NewMemoryClient()
+	suite.Timer = utils.NewFakeTimer(time.Date(2022, 7, 18, 10, 0, 0, 0, time.UTC))
+	suite.Queue = &pushedQueue{}
+	suite.Deps = &Dependencies{
+		DB:          suite.DB,
+		Timer:       suite.Timer,
+		IdGenny:     utils.NewFakeIdGenny("ID1", "ID2", "ID3"),
+		Queue:       suite.Queue,
+		Idempotency: NewDBIdempotencyStore(suite.DB),
+	}
+
+	completedAt := suite.Timer.GetTimeNow().Add(-1 * time.Hour)
+	suite.Event = &QuestionnaireCompletedEvent{
+		Name:                 QuestionnaireCompleted,
+		Id:                   "COMPLETED1",
+		UserId:               "PARTICIPANT1",
+		StudyId:              "STUDY1",
+		QuestionnaireId:      "QUESTIONNAIRE1",
+		CompletedAt:          completedAt.Format(time.RFC3339),
+		RemainingCompletions: 2,
+	}
+
+	suite.Require().NoError(suite.DB.CreateAll(
+		&models.Participant{Id: "PARTICIPANT1", Name: "Ann"},
+		&models.Questionnaire{Id: "QUESTIONNAIRE1", StudyId: "STUDY1", Name: "hair regrowth", Questions: "{}",
+			MaxAttempts: sql.NullInt64{Valid: true, Int64: 3}, HoursBetweenAttempts: sql.NullInt64{Valid: true, Int64: 48}},
+	))
+}
+
+// schedule a pending scheduled_questionnaire for the participant
+func (suite *QuestionnaireCompletedEventSuite) schedule(id string, scheduledAt time.Time) {
+	suite.Require().NoError(suite.DB.Create(&models.ScheduledQuestionnaire{Id: id, QuestionnaireId: "QUESTIONNAIRE1",
+		ParticipantId: "PARTICIPANT1", ScheduledAt: scheduledAt, Status: sql.NullString{Valid: true, String: Pending}}))
+}
+
+func (suite *QuestionnaireCompletedEventSuite) statuses() map[string]string {
+	var rows models.ScheduledQuestionnaires
+	suite.Require().NoError(suite.DB.GetList(&rows, nil))
+	statuses := make(map[string]string, len(rows))
+	for _, row := range rows {
+		statuses[row.Id] = row.Status.String
+	}
+	return statuses
+}
+
+func (suite *QuestionnaireCompletedEventSuite) outbox() (types []string) {
+	var rows models.OutboxEvents
+	suite.Require().NoError(suite.DB.GetList(&rows, nil))
+	for _, row := range rows {
+		types = append(types, row.EventType)
+	}
+	return
+}
+
+func (suite *QuestionnaireCompletedEventSuite) processed() *models.ProcessedEvent {
+	row, err := suite.DB.GetById(suite.Event.Id, &models.ProcessedEvent{})
+	suite.Require().NoError(err)
+	return row.(*models.ProcessedEvent)
+}
+
+// unscheduled checks an event that failed left nothing behind, so it can be processed again once it's redelivered
+func (suite *QuestionnaireCompletedEventSuite) unscheduled() {
+	suite.Equal(map[string]string{"SCHEDULED1": Pending}, suite.statuses())
+	suite.Empty(suite.outbox())
+	suite.Empty(suite.Queue.pushed)
+	processed, err := suite.Deps.Idempotency.Lookup(suite.Event.Id)
+	suite.Require().NoError(err)
+	suite.Nil(processed)
 }
 
-// TODO: finish this test
 func (suite *QuestionnaireCompletedEventSuite) Test_HandleEvent() {
+	completedAt := suite.Event.GetCompletedAt()
+
 	suite.Run("when there are no remaining completions", func() {
+		suite.SetupTest()
+		suite.schedule("SCHEDULED1", completedAt.Add(-1*time.Hour))
+		suite.schedule("SCHEDULED2", completedAt.Add(24*time.Hour))
+		suite.Event.RemainingCompletions = 0
+
+		suite.Equal(ErrMaxAttemptsReached, suite.Event.HandleEvent(context.Background(), suite.Deps))
+		suite.Equal(map[string]string{"SCHEDULED1": Completed, "SCHEDULED2": Cancelled}, suite.statuses())
+		suite.Equal([]string{QuestionnaireCompleted}, suite.outbox())
+		suite.Equal(OutcomeMaxAttempts, suite.processed().Outcome)
+		suite.Require().Len(suite.Queue.pushed, 1)
+		suite.Equal(QuestionnaireCompleted, suite.Queue.pushed[0].FunctionName())
+	})
+
+	suite.Run("the status changes aren't kept if the event can't be written to the outbox", func() {
+		suite.SetupTest()
+		suite.schedule("SCHEDULED1", completedAt.Add(-1*time.Hour))
+		suite.schedule("SCHEDULED2", completedAt.Add(24*time.Hour))
+		suite.Event.RemainingCompletions = 0
+		suite.Deps.DB = &failingCreateDB{Client: suite.DB}
+
+		suite.EqualError(suite.Event.HandleEvent(context.Background(), suite.Deps), "connection reset")
+		suite.Equal(map[string]string{"SCHEDULED1": Pending, "SCHEDULED2": Pending}, suite.statuses())
+		suite.Empty(suite.outbox())
+		suite.Empty(suite.Queue.pushed)
 	})
 
 	suite.Run("when questionnaire has reached max attempts", func() {
+		suite.SetupTest()
+		suite.schedule("SCHEDULED1", completedAt.Add(-1*time.Hour))
+		for _, id := range []string{"RESULT1", "RESULT2", "RESULT3"} {
+			suite.Require().NoError(suite.DB.Create(&models.QuestionnaireResult{Id: id, Answers: "{}",
+				QuestionnaireId: "QUESTIONNAIRE1", ParticipantId: "PARTICIPANT1", CompletedAt: &completedAt,
+				QuestionnaireScheduleId: sql.NullString{Valid: true, String: suite.Event.Id}}))
+		}
 
+		suite.Equal(ErrMaxAttemptsReached, suite.Event.HandleEvent(context.Background(), suite.Deps))
+		suite.Equal(map[string]string{"SCHEDULED1": Completed}, suite.statuses())
+		suite.Equal([]string{QuestionnaireCompleted}, suite.outbox())
 	})
 
 	suite.Run("when there are no associated scheduled_questionnaire records", func() {
+		suite.SetupTest()
+
+		suite.Equal(ErrAdhocQuestionnaireCompleted, suite.Event.HandleEvent(context.Background(), suite.Deps))
+		suite.Empty(suite.statuses())
+		suite.Equal([]string{QuestionnaireCompleted}, suite.outbox())
+		suite.Equal(OutcomeAdhoc, suite.processed().Outcome)
 	})
 
 	suite.Run("when there IS remaining completions", func() {
+		suite.SetupTest()
+		suite.schedule("SCHEDULED1", completedAt.Add(-1*time.Hour))
+
+		suite.Require().NoError(suite.Event.HandleEvent(context.Background(), suite.Deps))
+		suite.Equal(map[string]string{"SCHEDULED1": Completed, "ID1": Pending}, suite.statuses())
+
+		row, err := suite.DB.GetById("ID1", &models.ScheduledQuestionnaire{})
+		suite.Require().NoError(err)
+		suite.Equal(completedAt.Add(48*time.Hour), row.(*models.ScheduledQuestionnaire).ScheduledAt)
+
+		suite.Equal([]string{ScheduledQuestionnaire}, suite.outbox())
+		suite.Equal(sql.NullString{Valid: true, String: "ID1"}, suite.processed().ScheduledQuestionnaireId)
+		suite.Require().Len(suite.Queue.pushed, 1)
+		suite.Equal(ScheduledQuestionnaire, suite.Queue.pushed[0].FunctionName())
+
+		suite.Run("a redelivery doesn't schedule it again", func() {
+			suite.NoError(suite.Event.HandleEvent(context.Background(), suite.Deps))
+			suite.Len(suite.statuses(), 2)
+			suite.Len(suite.outbox(), 1)
+			suite.Len(suite.Queue.pushed, 1)
+		})
 	})
 }
 
-func (suite *QuestionnaireCompletedEventSuite) Test_ToSQSMessage() {
+// conflictingDB the first conflicts transactions are rolled back as if they'd lost out to a concurrent one
+type conflictingDB struct {
+	db.Client
+	conflicts int
+}
+
+func (c *conflictingDB) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx db.Client) error) error {
+	if c.conflicts == 0 {
+		return c.Client.WithTx(ctx, opts, fn)
+	}
+
+	c.conflicts--
+	conflict := &db.ConflictError{Err: fmt.Errorf("could not serialize access due to concurrent update")}
+	_ = c.Client.WithTx(ctx, opts, func(tx db.Client) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return conflict
+	})
+	return conflict
+}
+
+// failingCreateDB every insert fails, as if the connection went away part way through
+type failingCreateDB struct {
+	db.Client
+}
+
+func (f *failingCreateDB) CreateAll(objects ...interface{}) error {
+	return fmt.Errorf("connection reset")
+}
+
+func (f *failingCreateDB) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx db.Client) error) error {
+	return f.Client.WithTx(ctx, opts, func(tx db.Client) error {
+		return fn(&failingCreateDB{Client: tx})
+	})
+}
+
+// Test_HandleEvent_SQLite the memory client compares times as times, where SQLite compares them as text
+func (suite *QuestionnaireCompletedEventSuite) Test_HandleEvent_SQLite() {
+	suite.Run("a completion in another time zone only completes what was due by then", func() {
+		dbConn, err := db.NewDatabaseConn(&utils.DatabaseConfig{ClientName: "sqlx", Driver: "sqlite3",
+			Dsn: filepath.Join(suite.T().TempDir(), "reschedular.db")})
+		suite.Require().NoError(err)
+		migrator, err := db.NewMigrator(dbConn, suite.Timer)
+		suite.Require().NoError(err)
+		_, err = migrator.Up()
+		suite.Require().NoError(err)
+		suite.Deps.DB = dbConn
+		suite.Deps.Idempotency = NewDBIdempotencyStore(dbConn)
+
+		// completed at 10:00 BST, which is 09:00 UTC, so the 09:30 UTC schedule wasn't due yet
+		suite.Event.CompletedAt = time.Date(2022, 7, 18, 10, 0, 0, 0, time.FixedZone("BST", 60*60)).Format(time.RFC3339)
+		pending := sql.NullString{Valid: true, String: Pending}
+		suite.Require().NoError(dbConn.CreateAll(
+			&models.Participant{Id: "PARTICIPANT1", Name: "Ann"},
+			&models.Questionnaire{Id: "QUESTIONNAIRE1", StudyId: "STUDY1", Name: "hair regrowth", Questions: "{}",
+				MaxAttempts: sql.NullInt64{Valid: true, Int64: 3}, HoursBetweenAttempts: sql.NullInt64{Valid: true, Int64: 48}},
+			&models.ScheduledQuestionnaire{Id: "SCHEDULED1", QuestionnaireId: "QUESTIONNAIRE1", ParticipantId: "PARTICIPANT1",
+				ScheduledAt: time.Date(2022, 7, 18, 8, 30, 0, 0, time.UTC), Status: pending},
+			&models.ScheduledQuestionnaire{Id: "SCHEDULED2", QuestionnaireId: "QUESTIONNAIRE1", ParticipantId: "PARTICIPANT1",
+				ScheduledAt: time.Date(2022, 7, 18, 9, 30, 0, 0, time.UTC), Status: pending},
+		))
+
+		suite.Require().NoError(suite.Event.HandleEvent(context.Background(), suite.Deps))
+		var rows models.ScheduledQuestionnaires
+		suite.Require().NoError(dbConn.GetList(&rows, nil))
+		statuses := make(map[string]string, len(rows))
+		for _, row := range rows {
+			statuses[row.Id] = row.Status.String
+		}
+		suite.Equal(map[string]string{"SCHEDULED1": Completed, "SCHEDULED2": Pending, "ID1": Pending}, statuses)
+	})
+}
+
+func (suite *QuestionnaireCompletedEventSuite) Test_HandleEvent_Conflict() {
+	suite.Run("a schedule transaction that loses out to a concurrent one is run again", func() {
+		suite.schedule("SCHEDULED1", suite.Timer.GetTimeNow().Add(-2*time.Hour))
+		suite.Deps.DB = &conflictingDB{Client: suite.DB, conflicts: 1}
+
+		suite.Require().NoError(suite.Event.HandleEvent(context.Background(), suite.Deps))
+
+		var schedules models.ScheduledQuestionnaires
+		suite.Require().NoError(suite.DB.GetList(&schedules, db.Filters{db.Eq("status", Pending)}))
+		suite.Len(schedules, 1)
+		suite.Equal(suite.Event.GetCompletedAt().Add(48*time.Hour), schedules[0].ScheduledAt)
+		suite.Equal([]string{ScheduledQuestionnaire}, suite.outbox())
+	})
+
+	suite.Run("gives up once it has conflicted on every attempt", func() {
+		suite.SetupTest()
+		suite.schedule("SCHEDULED1", suite.Timer.GetTimeNow().Add(-2*time.Hour))
+		suite.Deps.DB = &conflictingDB{Client: suite.DB, conflicts: scheduleTxAttempts}
+
+		err := suite.Event.HandleEvent(context.Background(), suite.Deps)
+		suite.True(db.IsConflict(err))
+		suite.Empty(suite.outbox())
+	})
+}
+
+func (suite *QuestionnaireCompletedEventSuite) Test_HandleEvent_Recurrence() {
+	// the participant's schedule started on Monday 11th July at 09:00, and they completed it on Monday 18th at 09:00
+	completedAt := time.Date(2022, 7, 18, 9, 0, 0, 0, time.UTC)
+	setup := func(rule string) {
+		suite.SetupTest()
+		suite.Event.CompletedAt = completedAt.Format(time.RFC3339)
+		suite.schedule("SCHEDULED1", completedAt.AddDate(0, 0, -7))
+		suite.Require().NoError(suite.DB.Update(&models.Questionnaire{Id: "QUESTIONNAIRE1",
+			Recurrence: sql.NullString{Valid: true, String: rule}}, "recurrence"))
+	}
+
+	suite.Run("the next occurrence is scheduled", func() {
+		setup("RRULE:FREQ=WEEKLY;BYDAY=MO;BYHOUR=9;BYMINUTE=0")
 
+		suite.Require().NoError(suite.Event.HandleEvent(context.Background(), suite.Deps))
+		row, err := suite.DB.GetById("ID1", &models.ScheduledQuestionnaire{})
+		suite.Require().NoError(err)
+		suite.Equal(completedAt.AddDate(0, 0, 7), row.(*models.ScheduledQuestionnaire).ScheduledAt)
+	})
+
+	suite.Run("rules are evaluated from when the schedule started", func() {
+		setup("RRULE:FREQ=DAILY;COUNT=14\nRRULE:FREQ=WEEKLY")
+		suite.Event.CompletedAt = completedAt.AddDate(0, 0, 7).Format(time.RFC3339)
+
+		suite.Require().NoError(suite.Event.HandleEvent(context.Background(), suite.Deps))
+		row, err := suite.DB.GetById("ID1", &models.ScheduledQuestionnaire{})
+		suite.Require().NoError(err)
+		// the daily attempts ran out on the 24th, so it's the following Monday
+		suite.Equal(completedAt.AddDate(0, 0, 14), row.(*models.ScheduledQuestionnaire).ScheduledAt)
+	})
+
+	suite.Run("once the recurrence has ended", func() {
+		setup("RRULE:FREQ=WEEKLY;COUNT=2")
+		suite.schedule("SCHEDULED2", completedAt.AddDate(0, 0, 1))
+
+		suite.Equal(ErrMaxAttemptsReached, suite.Event.HandleEvent(context.Background(), suite.Deps))
+		suite.Equal(map[string]string{"SCHEDULED1": Completed, "SCHEDULED2": Cancelled}, suite.statuses())
+		suite.Equal([]string{QuestionnaireCompleted}, suite.outbox())
+	})
+}
+
+func (suite *QuestionnaireCompletedEventSuite) Test_HandleEvent_LocalTime() {
+	setup := func(timeZone string, questionnaire *models.Questionnaire, completedAt time.Time) {
+		suite.SetupTest()
+		suite.Event.CompletedAt = completedAt.Format(time.RFC3339)
+		suite.schedule("SCHEDULED1", completedAt.AddDate(0, 0, -7))
+		suite.Require().NoError(suite.DB.Update(&models.Participant{Id: "PARTICIPANT1", Name: "Ann",
+			TimeZone: sql.NullString{Valid: true, String: timeZone}}))
+
+		questionnaire.Id = "QUESTIONNAIRE1"
+		suite.Require().NoError(suite.DB.Update(questionnaire, "hours_between_attempts", "recurrence",
+			"delivery_window_start", "delivery_window_end"))
+	}
+
+	scheduledAt := func() time.Time {
+		row, err := suite.DB.GetById("ID1", &models.ScheduledQuestionnaire{})
+		suite.Require().NoError(err)
+		return row.(*models.ScheduledQuestionnaire).ScheduledAt
+	}
+
+	suite.Run("pushed into the participant's delivery window", func() {
+		// completed at 22:00 in New York, 4 hours later is 02:00 local, so it's moved on to 08:00 local
+		setup("America/New_York", &models.Questionnaire{
+			HoursBetweenAttempts: sql.NullInt64{Valid: true, Int64: 4},
+			DeliveryWindowStart:  sql.NullString{Valid: true, String: "08:00"},
+			DeliveryWindowEnd:    sql.NullString{Valid: true, String: "21:00"},
+		}, time.Date(2022, 7, 19, 2, 0, 0, 0, time.UTC))
+
+		suite.Require().NoError(suite.Event.HandleEvent(context.Background(), suite.Deps))
+		suite.Equal(time.Date(2022, 7, 19, 12, 0, 0, 0, time.UTC), scheduledAt())
+	})
+
+	suite.Run("already within the window", func() {
+		setup("America/New_York", &models.Questionnaire{
+			HoursBetweenAttempts: sql.NullInt64{Valid: true, Int64: 12},
+			DeliveryWindowStart:  sql.NullString{Valid: true, String: "08:00"},
+			DeliveryWindowEnd:    sql.NullString{Valid: true, String: "21:00"},
+		}, time.Date(2022, 7, 19, 2, 0, 0, 0, time.UTC))
+
+		suite.Require().NoError(suite.Event.HandleEvent(context.Background(), suite.Deps))
+		suite.Equal(time.Date(2022, 7, 19, 14, 0, 0, 0, time.UTC), scheduledAt())
+	})
+
+	suite.Run("rules stay at the same local time when the clocks go back", func() {
+		// completed on Monday 24th October at 09:30 BST, the clocks go back on the 30th so the next 09:00 is GMT
+		setup("Europe/London", &models.Questionnaire{
+			Recurrence: sql.NullString{Valid: true, String: "RRULE:FREQ=WEEKLY;BYDAY=MO;BYHOUR=9;BYMINUTE=0"},
+		}, time.Date(2022, 10, 24, 8, 30, 0, 0, time.UTC))
+
+		suite.Require().NoError(suite.Event.HandleEvent(context.Background(), suite.Deps))
+		suite.Equal(time.Date(2022, 10, 31, 9, 0, 0, 0, time.UTC), scheduledAt())
+	})
+
+	suite.Run("daily attempts stay at the same local time when the clocks go back", func() {
+		// completed on Saturday 29th October at 09:00 BST, a day later is 09:00 GMT on the Sunday, 25 hours on
+		setup("Europe/London", &models.Questionnaire{
+			HoursBetweenAttempts: sql.NullInt64{Valid: true, Int64: 24},
+		}, time.Date(2022, 10, 29, 8, 0, 0, 0, time.UTC))
+
+		suite.Require().NoError(suite.Event.HandleEvent(context.Background(), suite.Deps))
+		suite.Equal(time.Date(2022, 10, 30, 9, 0, 0, 0, time.UTC), scheduledAt())
+	})
+
+	suite.Run("an unknown time zone isn't guessed at", func() {
+		setup("Mars/Olympus", &models.Questionnaire{}, time.Date(2022, 7, 19, 2, 0, 0, 0, time.UTC))
+
+		err := suite.Event.HandleEvent(context.Background(), suite.Deps)
+		suite.EqualError(err, "invalid time zone for participant (id: PARTICIPANT1): unknown time zone Mars/Olympus")
+		suite.unscheduled()
+	})
+
+	suite.Run("an invalid recurrence rule isn't scheduled from", func() {
+		setup("Europe/London", &models.Questionnaire{
+			Recurrence: sql.NullString{Valid: true, String: "RRULE:FREQ=FORTNIGHTLY"},
+		}, time.Date(2022, 7, 19, 2, 0, 0, 0, time.UTC))
+
+		err := suite.Event.HandleEvent(context.Background(), suite.Deps)
+		suite.Require().Error(err)
+		suite.Contains(err.Error(), "invalid recurrence for questionnaire (id: QUESTIONNAIRE1)")
+		suite.unscheduled()
+	})
+}
+
+func (suite *QuestionnaireCompletedEventSuite) Test_HandleEvent_StudyCalendar() {
+	// completed on Monday 18th July at 09:00 UTC, so the next attempt is due 48 hours later, on Wednesday 20th at 09:00
+	setup := func(rows ...interface{}) {
+		suite.SetupTest()
+		suite.schedule("SCHEDULED1", suite.Timer.GetTimeNow().AddDate(0, 0, -2))
+		suite.Require().NoError(suite.DB.CreateAll(rows...))
+	}
+
+	scheduledAt := func() time.Time {
+		row, err := suite.DB.GetById("ID1", &models.ScheduledQuestionnaire{})
+		suite.Require().NoError(err)
+		return row.(*models.ScheduledQuestionnaire).ScheduledAt
+	}
+
+	suite.Run("deferred until after a blackout", func() {
+		setup(&models.StudyBlackout{Id: "BLACKOUT1", StudyId: "STUDY1", StartsOn: "2022-07-20", EndsOn: "2022-07-21"})
+
+		suite.Require().NoError(suite.Event.HandleEvent(context.Background(), suite.Deps))
+		suite.Equal(time.Date(2022, 7, 22, 0, 0, 0, 0, time.UTC), scheduledAt())
+	})
+
+	suite.Run("blackouts for other studies, or that have ended, are ignored", func() {
+		setup(
+			&models.StudyBlackout{Id: "BLACKOUT1", StudyId: "STUDY2", StartsOn: "2022-07-20", EndsOn: "2022-07-20"},
+			&models.StudyBlackout{Id: "BLACKOUT2", StudyId: "STUDY1", StartsOn: "2022-07-18", EndsOn: "2022-07-19"},
+		)
+
+		suite.Require().NoError(suite.Event.HandleEvent(context.Background(), suite.Deps))
+		suite.Equal(time.Date(2022, 7, 20, 9, 0, 0, 0, time.UTC), scheduledAt())
+	})
+
+	suite.Run("out of a blackout and into the delivery window", func() {
+		setup(&models.StudyBlackout{Id: "BLACKOUT1", StudyId: "STUDY1", StartsOn: "2022-07-20", EndsOn: "2022-07-20"})
+		suite.Require().NoError(suite.DB.Update(&models.Questionnaire{Id: "QUESTIONNAIRE1",
+			DeliveryWindowStart: sql.NullString{Valid: true, String: "08:00"},
+			DeliveryWindowEnd:   sql.NullString{Valid: true, String: "21:00"}},
+			"delivery_window_start", "delivery_window_end"))
+
+		suite.Require().NoError(suite.Event.HandleEvent(context.Background(), suite.Deps))
+		suite.Equal(time.Date(2022, 7, 21, 8, 0, 0, 0, time.UTC), scheduledAt())
+	})
+
+	suite.Run("deferred until the end of quiet hours, in the participant's time zone", func() {
+		// 09:00 UTC is 05:00 in New York, which is within the overnight quiet hours on Wednesdays
+		setup(&models.StudyQuietPeriod{Id: "QUIET1", StudyId: "STUDY1", StartTime: "22:00", EndTime: "07:00",
+			Days: sql.NullString{Valid: true, String: "TU"}})
+		suite.Require().NoError(suite.DB.Update(&models.Participant{Id: "PARTICIPANT1", Name: "Ann",
+			TimeZone: sql.NullString{Valid: true, String: "America/New_York"}}))
+
+		suite.Require().NoError(suite.Event.HandleEvent(context.Background(), suite.Deps))
+		suite.Equal(time.Date(2022, 7, 20, 11, 0, 0, 0, time.UTC), scheduledAt())
+	})
+
+	suite.Run("when the calendar rules out every time of day", func() {
+		setup(&models.StudyQuietPeriod{Id: "QUIET1", StudyId: "STUDY1", StartTime: "07:00", EndTime: "22:00"})
+		suite.Require().NoError(suite.DB.Update(&models.Questionnaire{Id: "QUESTIONNAIRE1",
+			DeliveryWindowStart: sql.NullString{Valid: true, String: "08:00"},
+			DeliveryWindowEnd:   sql.NullString{Valid: true, String: "21:00"}},
+			"delivery_window_start", "delivery_window_end"))
+
+		err := suite.Event.HandleEvent(context.Background(), suite.Deps)
+		suite.EqualError(err, "no time to schedule questionnaire (id: QUESTIONNAIRE1, participant_id: PARTICIPANT1) outside of the study's (id: STUDY1) blackouts and quiet hours")
+		suite.unscheduled()
+	})
+}
+
+func (suite *QuestionnaireCompletedEventSuite) Test_ToSQSMessage() {
+	message := suite.Event.ToSQSMessage()
+	suite.Equal("COMPLETED1", *message["Id"].StringValue)
+	suite.Equal("PARTICIPANT1", *message["UserId"].StringValue)
+	suite.Equal("2", *message["RemainingCompletions"].StringValue)
+	suite.Equal("Number", *message["RemainingCompletions"].DataType)
 }
 
 func TestQuestionnaireCompletedEventSuite(t *testing.T) {
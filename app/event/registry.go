@@ -0,0 +1,55 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// UnknownEventError returned when decoding an event whose name hasn't been registered
+type UnknownEventError struct {
+	Name string
+}
+
+func (e *UnknownEventError) Error() string {
+	return fmt.Sprintf("unknown event %q", e.Name)
+}
+
+// Registry maps an event's function name to a constructor for its concrete type, so raw JSON can be decoded into the
+// right IncomingEvent. Events should be registered before anything is decoded, the registry isn't safe to change
+// concurrently
+type Registry map[string]func() IncomingEvent
+
+// DefaultRegistry every event type the service knows about
+var DefaultRegistry = Registry{
+	QuestionnaireCompleted: func() IncomingEvent { return &QuestionnaireCompletedEvent{} },
+	ScheduledQuestionnaire: func() IncomingEvent { return &ScheduledQuestionnaireEvent{} },
+}
+
+func (r Registry) Register(name string, newEvent func() IncomingEvent) {
+	r[name] = newEvent
+}
+
+// Decode uses the event's Name field to pick the type to decode raw into
+func (r Registry) Decode(raw []byte) (IncomingEvent, error) {
+	var named struct {
+		Name string
+	}
+	if err := json.Unmarshal(raw, &named); err != nil {
+		return nil, err
+	}
+	return r.DecodeAs(named.Name, raw)
+}
+
+// DecodeAs decodes raw into the type registered under name
+func (r Registry) DecodeAs(name string, raw []byte) (IncomingEvent, error) {
+	newEvent, ok := r[name]
+	if !ok {
+		return nil, &UnknownEventError{Name: name}
+	}
+
+	e := newEvent()
+	if err := json.Unmarshal(raw, e); err != nil {
+		return nil, fmt.Errorf("failed to decode %s event: %v", name, err)
+	}
+	return e, nil
+}
@@ -0,0 +1,50 @@
+package event
+
+import (
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type RegistrySuite struct {
+	suite.Suite
+}
+
+func (suite *RegistrySuite) Test_Decode() {
+	suite.Run("decodes into the type registered for the event's name", func() {
+		e, err := DefaultRegistry.Decode([]byte(`{"Name": "QUESTIONNAIRE_COMPLETED", "Id": "ABC123", "RemainingCompletions": 2}`))
+		suite.Require().NoError(err)
+		suite.Equal(&QuestionnaireCompletedEvent{Name: QuestionnaireCompleted, Id: "ABC123", RemainingCompletions: 2}, e)
+	})
+
+	suite.Run("the name is matched case insensitively, like every other field", func() {
+		e, err := DefaultRegistry.Decode([]byte(`{"name": "SCHEDULED_QUESTIONNAIRE", "id": "ABC123"}`))
+		suite.Require().NoError(err)
+		suite.Equal(&ScheduledQuestionnaireEvent{Name: ScheduledQuestionnaire, Id: "ABC123"}, e)
+	})
+
+	suite.Run("when the event name isn't registered", func() {
+		_, err := DefaultRegistry.Decode([]byte(`{"Name": "PARTICIPANT_WITHDRAWN"}`))
+		suite.Equal(&UnknownEventError{Name: "PARTICIPANT_WITHDRAWN"}, err)
+	})
+
+	suite.Run("when the event isn't JSON", func() {
+		_, err := DefaultRegistry.Decode([]byte(`QUESTIONNAIRE_COMPLETED`))
+		suite.Error(err)
+	})
+}
+
+func (suite *RegistrySuite) Test_Register() {
+	registry := Registry{}
+	registry.Register(QuestionnaireCompleted, func() IncomingEvent { return &QuestionnaireCompletedEvent{} })
+
+	e, err := registry.DecodeAs(QuestionnaireCompleted, []byte(`{"Id": "ABC123"}`))
+	suite.Require().NoError(err)
+	suite.Equal("ABC123", e.EventId())
+
+	_, err = registry.DecodeAs(ScheduledQuestionnaire, []byte(`{}`))
+	suite.IsType(&UnknownEventError{}, err)
+}
+
+func TestRegistrySuite(t *testing.T) {
+	suite.Run(t, new(RegistrySuite))
+}
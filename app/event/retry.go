@@ -0,0 +1,113 @@
+package event
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/jamesineda/reschedular/app/db"
+	"github.com/jamesineda/reschedular/app/models"
+	"github.com/jamesineda/reschedular/app/utils"
+	"log"
+	"time"
+)
+
+// RetryHandler decides what happens to an event that failed to send: it's either sent again after a backoff, or once it
+// has run out of attempts, stored in the failed_events table so that it can be replayed later
+type RetryHandler struct {
+	DB      db.Client
+	IdGenny utils.IdGenny
+	Timer   utils.Timer
+	Config  *utils.RetryConfig
+}
+
+// HandleFailedSend returns true if the event should be sent again, otherwise it has been moved to failed_events
+func (r *RetryHandler) HandleFailedSend(e *OutboxedEvent, sendErr error) (retry bool) {
+	e.Attempts++
+	if r.Config.CanRetry(e.Attempts) {
+		log.Printf("failed to submit event %s (attempt %d/%d): %s", e.FunctionName(), e.Attempts,
+			r.Config.MaxAttempts, sendErr)
+		return true
+	}
+
+	log.Printf("failed to submit event %s after %d attempts, moving it to failed_events: %s", e.FunctionName(),
+		e.Attempts, sendErr)
+	if err := r.deadLetter(e, sendErr); err != nil {
+		// the outbox row is still unsent, so the event will be picked up again on the next restart
+		log.Printf("failed to store failed event %s: %s", e.FunctionName(), err)
+	}
+	return false
+}
+
+func (r *RetryHandler) deadLetter(e *OutboxedEvent, sendErr error) error {
+	failed, err := NewFailedEvent(r.IdGenny.GenerateId(), r.Timer.GetTimeNow(), e, sendErr)
+	if err != nil {
+		return err
+	}
+
+	if err = r.DB.Create(failed); err != nil {
+		return err
+	}
+
+	if e.OutboxId == "" {
+		return nil
+	}
+	return MarkOutboxEventFailed(r.DB, e.OutboxId, failed.FailedAt)
+}
+
+// NewFailedEvent serialises the event into a failed_events row, recording the attempts made and the last error seen
+func NewFailedEvent(id string, failedAt time.Time, e *OutboxedEvent, lastErr error) (*models.FailedEvent, error) {
+	payload, err := json.Marshal(e.IncomingEvent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialise %s event: %v", e.FunctionName(), err)
+	}
+
+	failed := &models.FailedEvent{
+		Id:        id,
+		OutboxId:  sql.NullString{Valid: e.OutboxId != "", String: e.OutboxId},
+		EventType: e.FunctionName(),
+		Payload:   string(payload),
+		Attempts:  e.Attempts,
+		FailedAt:  failedAt,
+	}
+	if lastErr != nil {
+		failed.LastError = sql.NullString{Valid: true, String: lastErr.Error()}
+	}
+	return failed, nil
+}
+
+// ReplayFailedEvents moves every failed event that hasn't been replayed yet back into the outbox, where it'll be picked
+// up and sent the next time the event processor starts
+func ReplayFailedEvents(dbConn db.Client, idGenny utils.IdGenny, timer utils.Timer) (replayed int, err error) {
+	var rows models.FailedEvents
+	err = dbConn.GetList(&rows, db.Filters{db.IsNull("replayed_at")}, db.OrderBy(db.Asc("failed_at")))
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to query failed_events from database: %v", err)
+	}
+
+	for _, row := range rows {
+		e, err := DefaultRegistry.DecodeAs(row.EventType, []byte(row.Payload))
+		if err != nil {
+			log.Printf("failed to decode failed event (id: %s): %s", row.Id, err)
+			continue
+		}
+
+		outboxRow, _, err := NewOutboxEvent(idGenny, timer, e)
+		if err != nil {
+			return replayed, err
+		}
+
+		if err = dbConn.Create(outboxRow); err != nil {
+			return replayed, fmt.Errorf("failed to write failed event (id: %s) to the outbox: %v", row.Id, err)
+		}
+
+		replayedAt := timer.GetTimeNow()
+		row.ReplayedAt = &replayedAt
+		if err = dbConn.Update(row, "replayed_at"); err != nil {
+			return replayed, fmt.Errorf("failed to mark failed event (id: %s) as replayed: %v", row.Id, err)
+		}
+		replayed++
+	}
+	return replayed, nil
+}
@@ -0,0 +1,54 @@
+package event
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type RetrySuite struct {
+	suite.Suite
+	FailedAt time.Time
+}
+
+func (suite *RetrySuite) SetupTest() {
+	suite.FailedAt = time.Date(2022, 7, 18, 10, 0, 0, 0, time.UTC)
+}
+
+func (suite *RetrySuite) Test_NewFailedEvent() {
+	completed := &QuestionnaireCompletedEvent{Name: QuestionnaireCompleted, Id: "ABC123", UserId: "PARTICIPANT1"}
+
+	suite.Run("when the event came from the outbox", func() {
+		failed, err := NewFailedEvent("FAILED1", suite.FailedAt, &OutboxedEvent{
+			IncomingEvent: completed,
+			OutboxId:      "OUTBOX1",
+			Attempts:      5,
+		}, fmt.Errorf("queue does not exist"))
+		suite.Require().NoError(err)
+
+		suite.Equal("FAILED1", failed.Id)
+		suite.Equal(sql.NullString{Valid: true, String: "OUTBOX1"}, failed.OutboxId)
+		suite.Equal(QuestionnaireCompleted, failed.EventType)
+		suite.Equal(5, failed.Attempts)
+		suite.Equal(sql.NullString{Valid: true, String: "queue does not exist"}, failed.LastError)
+		suite.Equal(suite.FailedAt, failed.FailedAt)
+		suite.False(failed.IsReplayed())
+
+		decoded, err := DefaultRegistry.DecodeAs(failed.EventType, []byte(failed.Payload))
+		suite.Require().NoError(err)
+		suite.Equal(completed, decoded)
+	})
+
+	suite.Run("when the event was never written to the outbox", func() {
+		failed, err := NewFailedEvent("FAILED1", suite.FailedAt, &OutboxedEvent{IncomingEvent: completed}, nil)
+		suite.Require().NoError(err)
+		suite.False(failed.OutboxId.Valid)
+		suite.False(failed.LastError.Valid)
+	})
+}
+
+func TestRetrySuite(t *testing.T) {
+	suite.Run(t, new(RetrySuite))
+}
@@ -9,9 +9,11 @@ import (
 )
 
 const (
-	ScheduledQuestionnaire = "SCHEDULED_QUESTIONNAIRE"
-	Pending                = "pending"
-	Completed              = "completed"
+	ScheduledQuestionnaire              = "SCHEDULED_QUESTIONNAIRE"
+	ScheduledQuestionnaireSchemaVersion = 1
+	Pending                             = "pending"
+	Completed                           = "completed"
+	Cancelled                           = "cancelled"
 )
 
 var ErrMaxAttemptsReached = fmt.Errorf("maximum number of results reached for questionnaire ")
@@ -26,12 +28,31 @@ type ScheduledQuestionnaireEvent struct {
 	QuestionnaireId string
 	Status          string
 	ScheduledAt     time.Time
+	CreatedAt       time.Time
 }
 
 func (q *ScheduledQuestionnaireEvent) FunctionName() string {
 	return q.Name
 }
 
+func (q *ScheduledQuestionnaireEvent) EventId() string {
+	return q.Id
+}
+
+func (q *ScheduledQuestionnaireEvent) SchemaVersion() int {
+	return ScheduledQuestionnaireSchemaVersion
+}
+
+// OccurredAt when the scheduled_questionnaire was created, rather than when it's scheduled for
+func (q *ScheduledQuestionnaireEvent) OccurredAt() time.Time {
+	return q.CreatedAt
+}
+
+// PartitionKey events for the same participant are published in order
+func (q *ScheduledQuestionnaireEvent) PartitionKey() string {
+	return q.ParticipantId
+}
+
 func (q *ScheduledQuestionnaireEvent) ToSQSMessage() map[string]*sqs.MessageAttributeValue {
 	return map[string]*sqs.MessageAttributeValue{
 		"Id": &sqs.MessageAttributeValue{
@@ -58,6 +79,6 @@ func (q *ScheduledQuestionnaireEvent) ToSQSMessage() map[string]*sqs.MessageAttr
 }
 
 // HandleEvent No specific handling for this function from a Lambda call just yet
-func (event *ScheduledQuestionnaireEvent) HandleEvent(ctx context.Context) (err error) {
+func (event *ScheduledQuestionnaireEvent) HandleEvent(ctx context.Context, deps *Dependencies) (err error) {
 	return
 }
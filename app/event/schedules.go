@@ -0,0 +1,64 @@
+package event
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/jamesineda/reschedular/app/db"
+	"github.com/jamesineda/reschedular/app/models"
+	"github.com/jamesineda/reschedular/app/recurrence"
+	"time"
+)
+
+// GetFirstSchedule the participant's earliest scheduled_questionnaire for the questionnaire, which is when their
+// schedule started. sql.ErrNoRows if they don't have one
+func GetFirstSchedule(dbConn db.Client, questionnaireId, participantId string) (*models.ScheduledQuestionnaire, error) {
+	var schedules models.ScheduledQuestionnaires
+	err := dbConn.GetList(&schedules, db.Filters{
+		db.Eq("questionnaire_id", questionnaireId),
+		db.Eq("participant_id", participantId)},
+		db.OrderBy(db.Asc("scheduled_at"), db.Asc("id")), db.Limit(1))
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to query first schedule (questionnaire_id: %s, participant_id: %s) from database: %v",
+			questionnaireId, participantId, err)
+	}
+
+	if len(schedules) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return schedules[0], nil
+}
+
+// GetStudyCalendar the study's blackouts and quiet hours, leaving out blackouts that ended before from's local date
+func GetStudyCalendar(dbConn db.Client, studyId string, from time.Time) (*recurrence.Calendar, error) {
+	var blackouts models.StudyBlackouts
+	err := dbConn.GetList(&blackouts, db.Filters{
+		db.Eq("study_id", studyId),
+		db.Gte("ends_on", from.Format("2006-01-02"))},
+		db.OrderBy(db.Asc("starts_on"), db.Asc("id")))
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to query study_blackouts (study_id: %s) from database: %v", studyId, err)
+	}
+
+	var quietPeriods models.StudyQuietPeriods
+	err = dbConn.GetList(&quietPeriods, db.Filters{db.Eq("study_id", studyId)}, db.OrderBy(db.Asc("id")))
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to query study_quiet_periods (study_id: %s) from database: %v", studyId, err)
+	}
+
+	calendar := &recurrence.Calendar{}
+	for _, row := range blackouts {
+		blackout, err := row.GetBlackout()
+		if err != nil {
+			return nil, err
+		}
+		calendar.Blackouts = append(calendar.Blackouts, *blackout)
+	}
+	for _, row := range quietPeriods {
+		quiet, err := row.GetQuietHours()
+		if err != nil {
+			return nil, err
+		}
+		calendar.QuietHours = append(calendar.QuietHours, *quiet)
+	}
+	return calendar, nil
+}
@@ -0,0 +1,197 @@
+package event
+
+import (
+	"context"
+	"github.com/jamesineda/reschedular/app/db"
+	"github.com/jamesineda/reschedular/app/utils"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+)
+
+// publishWorker publishes the events for its share of the partition keys. A batch never holds two events with the same
+// partition key, and a failed event is retried before the worker moves on, so a participant's events are always sent
+// in the order they were queued. The downside is that a failing event holds up the rest of the worker's partitions
+// until it either succeeds or runs out of attempts, their events wait in the worker's backlog in the meantime
+type publishWorker struct {
+	events       chan IncomingEvent
+	db           db.Client
+	timer        utils.Timer
+	publisher    Publisher
+	retryHandler *RetryHandler
+	config       *utils.ProcessorConfig
+	drain        *drainer
+}
+
+// partition picks the worker for a partition key
+func partition(key string, workers int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(workers))
+}
+
+// backlog an unbounded buffer in front of a worker's events channel, so dispatching to a worker that's backing off a
+// failed send never blocks, and the other workers carry on being dispatched to. Closing the returned channel closes
+// events once everything in the backlog has been handed over
+func backlog(events chan<- IncomingEvent) chan<- IncomingEvent {
+	in := make(chan IncomingEvent)
+	go func() {
+		defer close(events)
+
+		var pending IncomingEvents
+		for in != nil || len(pending) > 0 {
+			// a nil channel is never ready, so nothing is handed over until there's something pending
+			var out chan<- IncomingEvent
+			var next IncomingEvent
+			if len(pending) > 0 {
+				out, next = events, pending[0]
+			}
+
+			select {
+			case e, ok := <-in:
+				if !ok {
+					in = nil
+					continue
+				}
+				pending = append(pending, e)
+			case out <- next:
+				pending[0] = nil
+				pending = pending[1:]
+			}
+		}
+	}()
+	return in
+}
+
+// run publishes batches until the events channel is closed and everything on it has been sent. Once ctx is cancelled
+// the worker is draining, and once drainCtx is cancelled as well, whatever is left is persisted instead of sent
+func (w *publishWorker) run(ctx, drainCtx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	var held IncomingEvent
+	for {
+		if drainCtx.Err() != nil {
+			w.persistRemaining(held)
+			return
+		}
+
+		var batch IncomingEvents
+		var open bool
+		batch, held, open = w.collect(held)
+		if len(batch) > 0 {
+			w.publish(ctx, drainCtx, batch)
+		}
+
+		if !open && held == nil {
+			return
+		}
+	}
+}
+
+// persistRemaining the events channel is always closed once the processor closes the backlog, even when the drain has
+// timed out
+func (w *publishWorker) persistRemaining(held IncomingEvent) {
+	if held != nil {
+		w.drain.persist(held)
+	}
+
+	for e := range w.events {
+		w.drain.persist(e)
+	}
+}
+
+// collect blocks until there's at least one event, then waits up to the flush interval for the batch to fill up. An
+// event whose partition key is already in the batch is held back to start the next batch
+func (w *publishWorker) collect(held IncomingEvent) (batch IncomingEvents, next IncomingEvent, open bool) {
+	first := held
+	if first == nil {
+		if first, open = <-w.events; !open {
+			return nil, nil, false
+		}
+	}
+
+	batch = IncomingEvents{first}
+	keys := map[string]bool{first.PartitionKey(): true}
+	timeout := time.NewTimer(w.config.FlushInterval)
+	defer timeout.Stop()
+
+	for len(batch) < w.config.GetBatchSize() {
+		select {
+		case e, ok := <-w.events:
+			if !ok {
+				return batch, nil, false
+			}
+
+			if keys[e.PartitionKey()] {
+				return batch, e, true
+			}
+			keys[e.PartitionKey()] = true
+			batch = append(batch, e)
+
+		case <-timeout.C:
+			return batch, nil, true
+		}
+	}
+	return batch, nil, true
+}
+
+// publish each event in a batch succeeds or fails on its own. If we fail to publish the event, it's retried with a
+// backoff, and after N attempts stored as a failure in the failed_events table. These can be replayed after the issue
+// has been resolved. Retries carry on while draining, until the drain times out
+func (w *publishWorker) publish(ctx, drainCtx context.Context, events IncomingEvents) {
+	for len(events) > 0 {
+		errs := w.publisher.PublishBatch(events)
+
+		var retries IncomingEvents
+		attempts := 0
+		for i, queuedEvent := range events {
+			outboxed, ok := queuedEvent.(*OutboxedEvent)
+			if !ok {
+				// events that couldn't be written to the outbox still get retried, they just can't be marked as sent
+				outboxed = &OutboxedEvent{IncomingEvent: queuedEvent}
+			}
+
+			if errs[i] == nil {
+				w.markSent(outboxed)
+				if ctx.Err() != nil {
+					w.drain.sent()
+				}
+				continue
+			}
+
+			if w.retryHandler.HandleFailedSend(outboxed, errs[i]) {
+				retries = append(retries, outboxed)
+				if outboxed.Attempts > attempts {
+					attempts = outboxed.Attempts
+				}
+			}
+		}
+
+		if len(retries) == 0 {
+			return
+		}
+
+		backoff := w.retryHandler.Config.Backoff(attempts)
+		log.Printf("retrying %d events in %s", len(retries), backoff)
+		select {
+		case <-time.After(backoff):
+			events = retries
+		case <-drainCtx.Done():
+			for _, e := range retries {
+				w.drain.persist(e)
+			}
+			return
+		}
+	}
+}
+
+func (w *publishWorker) markSent(outboxed *OutboxedEvent) {
+	if outboxed.OutboxId == "" {
+		return
+	}
+
+	if err := MarkOutboxEventSent(w.db, outboxed.OutboxId, w.timer.GetTimeNow()); err != nil {
+		log.Printf("failed to mark outbox event %s as sent: %s", outboxed.OutboxId, err)
+	}
+}
@@ -0,0 +1,142 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"github.com/jamesineda/reschedular/app/db"
+	"github.com/jamesineda/reschedular/app/utils"
+	"github.com/stretchr/testify/suite"
+	"sync"
+	"testing"
+	"time"
+)
+
+// failingPublisher fails the first failures publishes of each event id, and records everything it publishes
+type failingPublisher struct {
+	sync.Mutex
+	failures  int
+	attempts  map[string]int
+	published IncomingEvents
+}
+
+func (p *failingPublisher) Publish(e IncomingEvent) error {
+	p.Lock()
+	defer p.Unlock()
+	p.attempts[e.EventId()]++
+	if p.attempts[e.EventId()] <= p.failures {
+		return fmt.Errorf("publish failed")
+	}
+	p.published = append(p.published, e)
+	return nil
+}
+
+func (p *failingPublisher) PublishBatch(events IncomingEvents) []error {
+	errs := make([]error, len(events))
+	for i, e := range events {
+		errs[i] = p.Publish(e)
+	}
+	return errs
+}
+
+type WorkerSuite struct {
+	suite.Suite
+	Publisher *failingPublisher
+	Worker    *publishWorker
+}
+
+func (suite *WorkerSuite) SetupTest() {
+	suite.Worker, suite.Publisher = newTestWorker()
+}
+
+// newTestWorker a worker publishing to a failingPublisher, with retries that back off for a millisecond
+func newTestWorker() (*publishWorker, *failingPublisher) {
+	dbConn, _ := db.NewFakeDatabaseConn(&db.FakeSQLX{})
+	timer := utils.NewFakeTimer(time.Date(2022, 7, 18, 10, 0, 0, 0, time.UTC))
+	publisher := &failingPublisher{attempts: make(map[string]int)}
+	return &publishWorker{
+		events:    make(chan IncomingEvent, 10),
+		db:        dbConn,
+		timer:     timer,
+		publisher: publisher,
+		retryHandler: &RetryHandler{
+			DB:      dbConn,
+			IdGenny: utils.NewFakeIdGenny("FAILED1"),
+			Timer:   timer,
+			Config:  &utils.RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 1},
+		},
+		config: &utils.ProcessorConfig{BatchSize: 10, FlushInterval: 10 * time.Millisecond},
+		drain:  &drainer{db: dbConn, idGenny: utils.NewFakeIdGenny("OUTBOX1"), timer: timer},
+	}, publisher
+}
+
+func scheduledFor(id, participantId string) *ScheduledQuestionnaireEvent {
+	return &ScheduledQuestionnaireEvent{Name: ScheduledQuestionnaire, Id: id, ParticipantId: participantId}
+}
+
+func (suite *WorkerSuite) Test_partition() {
+	suite.Run("the same key always goes to the same worker", func() {
+		suite.Equal(partition("PARTICIPANT1", 4), partition("PARTICIPANT1", 4))
+	})
+
+	suite.Run("a single worker takes everything", func() {
+		suite.Equal(0, partition("PARTICIPANT1", 1))
+	})
+}
+
+func (suite *WorkerSuite) Test_collect() {
+	suite.Run("a second event for the same participant is held back for the next batch", func() {
+		suite.Worker.events <- scheduledFor("ABC123", "PARTICIPANT1")
+		suite.Worker.events <- scheduledFor("ABC456", "PARTICIPANT2")
+		suite.Worker.events <- scheduledFor("ABC789", "PARTICIPANT1")
+
+		batch, held, open := suite.Worker.collect(nil)
+		suite.True(open)
+		suite.Equal(IncomingEvents{scheduledFor("ABC123", "PARTICIPANT1"), scheduledFor("ABC456", "PARTICIPANT2")}, batch)
+		suite.Equal(scheduledFor("ABC789", "PARTICIPANT1"), held)
+
+		batch, held, open = suite.Worker.collect(held)
+		suite.True(open)
+		suite.Equal(IncomingEvents{scheduledFor("ABC789", "PARTICIPANT1")}, batch)
+		suite.Nil(held)
+	})
+
+	suite.Run("reports the channel as closed once it's empty", func() {
+		close(suite.Worker.events)
+		batch, _, open := suite.Worker.collect(nil)
+		suite.False(open)
+		suite.Empty(batch)
+	})
+}
+
+func (suite *WorkerSuite) Test_run() {
+	suite.Run("failed events are retried before the next batch is sent", func() {
+		suite.Publisher.failures = 1
+		suite.Worker.events <- scheduledFor("ABC123", "PARTICIPANT1")
+		suite.Worker.events <- scheduledFor("ABC456", "PARTICIPANT1")
+		close(suite.Worker.events)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		suite.Worker.run(context.Background(), context.Background(), &wg)
+		wg.Wait()
+
+		suite.Require().Len(suite.Publisher.published, 2)
+		suite.Equal("ABC123", suite.Publisher.published[0].EventId())
+		suite.Equal("ABC456", suite.Publisher.published[1].EventId())
+		suite.Equal(2, suite.Publisher.attempts["ABC123"])
+	})
+}
+
+func (suite *WorkerSuite) Test_publish() {
+	suite.Run("events that run out of attempts are not published", func() {
+		suite.Publisher.failures = 3
+		suite.Worker.publish(context.Background(), context.Background(), IncomingEvents{scheduledFor("ABC123", "PARTICIPANT1")})
+
+		suite.Empty(suite.Publisher.published)
+		suite.Equal(3, suite.Publisher.attempts["ABC123"])
+	})
+}
+
+func TestWorkerSuite(t *testing.T) {
+	suite.Run(t, new(WorkerSuite))
+}
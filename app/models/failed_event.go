@@ -0,0 +1,37 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+/*
+	+-----------+------------+----+---+-------+-----+
+	|Field      |Type        |Null|Key|Default|Extra|
+	+-----------+------------+----+---+-------+-----+
+	|id         |varchar(128)|NO  |PRI|NULL   |     |
+	|outbox_id  |varchar(128)|YES |   |NULL   |     |
+	|event_type |varchar(128)|NO  |   |NULL   |     |
+	|payload    |json        |NO  |   |NULL   |     |
+	|attempts   |int(11)     |NO  |   |0      |     |
+	|last_error |text        |YES |   |NULL   |     |
+	|failed_at  |datetime    |NO  |   |NULL   |     |
+	|replayed_at|datetime    |YES |   |NULL   |     |
+	+-----------+------------+----+---+-------+-----+
+*/
+type FailedEvent struct {
+	Id         string         `db:"id"`
+	OutboxId   sql.NullString `db:"outbox_id"`
+	EventType  string         `db:"event_type"`
+	Payload    string         `db:"payload"`
+	Attempts   int            `db:"attempts"`
+	LastError  sql.NullString `db:"last_error"`
+	FailedAt   time.Time      `db:"failed_at"`
+	ReplayedAt *time.Time     `db:"replayed_at"`
+}
+
+type FailedEvents []*FailedEvent
+
+func (f *FailedEvent) IsReplayed() bool {
+	return f.ReplayedAt != nil
+}
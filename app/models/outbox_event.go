@@ -0,0 +1,52 @@
+package models
+
+import (
+	"sort"
+	"time"
+)
+
+/*
+	+----------+------------+----+---+-------+-----+
+	|Field     |Type        |Null|Key|Default|Extra|
+	+----------+------------+----+---+-------+-----+
+	|id        |varchar(128)|NO  |PRI|NULL   |     |
+	|event_type|varchar(128)|NO  |   |NULL   |     |
+	|payload   |json        |NO  |   |NULL   |     |
+	|created_at|datetime    |NO  |   |NULL   |     |
+	|sent_at   |datetime    |YES |   |NULL   |     |
+	|failed_at |datetime    |YES |   |NULL   |     |
+	+----------+------------+----+---+-------+-----+
+*/
+type OutboxEvent struct {
+	Id        string     `db:"id"`
+	EventType string     `db:"event_type"`
+	Payload   string     `db:"payload"`
+	CreatedAt time.Time  `db:"created_at"`
+	SentAt    *time.Time `db:"sent_at"`
+	FailedAt  *time.Time `db:"failed_at"`
+}
+
+type OutboxEvents []*OutboxEvent
+
+func (o *OutboxEvent) IsSent() bool {
+	return o.SentAt != nil
+}
+
+// IsFailed the event used up all of its send attempts and was moved to failed_events
+func (o *OutboxEvent) IsFailed() bool {
+	return o.FailedAt != nil
+}
+
+// Pending returns the unsent events that haven't failed, oldest first, so they're re-queued in the order they were written
+func (o *OutboxEvents) Pending() (pending OutboxEvents) {
+	for _, e := range *o {
+		if !e.IsSent() && !e.IsFailed() {
+			pending = append(pending, e)
+		}
+	}
+
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].CreatedAt.Before(pending[j].CreatedAt)
+	})
+	return
+}
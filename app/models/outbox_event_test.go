@@ -0,0 +1,45 @@
+package models
+
+import (
+	"github.com/jamesineda/reschedular/app/utils"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type OutboxEventTestSuite struct {
+	suite.Suite
+	Events OutboxEvents
+}
+
+func (suite *OutboxEventTestSuite) SetupTest() {
+	timer := utils.NewFakeTimer(time.Date(2022, 7, 18, 10, 0, 0, 0, time.UTC))
+
+	now := timer.GetTimeNow()
+	nowPlusOneHour := now.Add(1 * time.Hour)
+	nowPlusTwoHours := now.Add(2 * time.Hour)
+	suite.Events = OutboxEvents{
+		&OutboxEvent{Id: "ABC123", CreatedAt: nowPlusTwoHours},
+		&OutboxEvent{Id: "ABC456", CreatedAt: now, SentAt: &nowPlusOneHour},
+		&OutboxEvent{Id: "ABC789", CreatedAt: nowPlusOneHour},
+		&OutboxEvent{Id: "XYZ987", CreatedAt: now, FailedAt: &nowPlusTwoHours},
+	}
+}
+
+func (suite *OutboxEventTestSuite) Test_Pending() {
+	suite.Run("only unsent events that haven't failed are returned, oldest first", func() {
+		suite.Equal(OutboxEvents{suite.Events[2], suite.Events[0]}, suite.Events.Pending())
+	})
+
+	suite.Run("nothing is returned when everything has been sent", func() {
+		sentAt := time.Date(2022, 7, 18, 10, 0, 0, 0, time.UTC)
+		for _, e := range suite.Events {
+			e.SentAt = &sentAt
+		}
+		suite.Empty(suite.Events.Pending())
+	})
+}
+
+func TestOutboxEvent(t *testing.T) {
+	suite.Run(t, new(OutboxEventTestSuite))
+}
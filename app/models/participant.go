@@ -1,17 +1,39 @@
 package models
 
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
 /*
-	+-----+------------+----+---+-------+-----+
-	|Field|Type        |Null|Key|Default|Extra|
-	+-----+------------+----+---+-------+-----+
-	|id   |varchar(128)|NO  |PRI|NULL   |     |
-	|name |varchar(128)|NO  |   |NULL   |     |
-	+-----+------------+----+---+-------+-----+
+	+---------+------------+----+---+-------+-----+
+	|Field    |Type        |Null|Key|Default|Extra|
+	+---------+------------+----+---+-------+-----+
+	|id       |varchar(128)|NO  |PRI|NULL   |     |
+	|name     |varchar(128)|NO  |   |NULL   |     |
+	|time_zone|varchar(64) |YES |   |NULL   |     |
+	+---------+------------+----+---+-------+-----+
 
 */
 type Participant struct {
 	Id   string `db:"id"`
 	Name string `db:"name"`
+	// TimeZone an IANA time zone name, e.g. Europe/London
+	TimeZone sql.NullString `db:"time_zone"`
 }
 
 type Participants []*Participant
+
+// Location the participant's time zone, UTC when they haven't got one
+func (p *Participant) Location() (*time.Location, error) {
+	if !p.TimeZone.Valid || p.TimeZone.String == "" {
+		return time.UTC, nil
+	}
+
+	loc, err := time.LoadLocation(p.TimeZone.String)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone for participant (id: %s): %v", p.Id, err)
+	}
+	return loc, nil
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type ParticipantTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ParticipantTestSuite) Test_Location() {
+	suite.Run("defaults to UTC", func() {
+		loc, err := (&Participant{}).Location()
+		suite.Require().NoError(err)
+		suite.Equal(time.UTC, loc)
+	})
+
+	suite.Run("an IANA time zone", func() {
+		loc, err := (&Participant{TimeZone: sql.NullString{Valid: true, String: "America/New_York"}}).Location()
+		suite.Require().NoError(err)
+		suite.Equal("America/New_York", loc.String())
+	})
+
+	suite.Run("an unknown time zone", func() {
+		_, err := (&Participant{Id: "PARTICIPANT1", TimeZone: sql.NullString{Valid: true, String: "Mars/Olympus"}}).Location()
+		suite.EqualError(err, "invalid time zone for participant (id: PARTICIPANT1): unknown time zone Mars/Olympus")
+	})
+}
+
+func TestParticipant(t *testing.T) {
+	suite.Run(t, new(ParticipantTestSuite))
+}
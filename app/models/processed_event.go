@@ -0,0 +1,28 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+/*
++--------------------------+------------+----+---+-------+-----+
+|Field                     |Type        |Null|Key|Default|Extra|
++--------------------------+------------+----+---+-------+-----+
+|id                        |varchar(128)|NO  |PRI|NULL   |     |
+|event_type                |varchar(128)|NO  |   |NULL   |     |
+|outcome                   |varchar(64) |NO  |   |NULL   |     |
+|scheduled_questionnaire_id|varchar(128)|YES |   |NULL   |     |
+|processed_at              |datetime    |NO  |   |NULL   |     |
++--------------------------+------------+----+---+-------+-----+
+*/
+type ProcessedEvent struct {
+	// Id is the id of the incoming event, so a redelivered event can be looked up by it
+	Id                       string         `db:"id"`
+	EventType                string         `db:"event_type"`
+	Outcome                  string         `db:"outcome"`
+	ScheduledQuestionnaireId sql.NullString `db:"scheduled_questionnaire_id"`
+	ProcessedAt              time.Time      `db:"processed_at"`
+}
+
+type ProcessedEvents []*ProcessedEvent
@@ -3,6 +3,7 @@ package models
 import (
 	"database/sql"
 	"fmt"
+	"github.com/jamesineda/reschedular/app/recurrence"
 	"time"
 )
 
@@ -16,6 +17,9 @@ import (
 	|questions             |json        |NO  |   |NULL   |     |
 	|max_attempts          |int(11)     |YES |   |NULL   |     |
 	|hours_between_attempts|int(11)     |YES |   |24     |     |
+	|recurrence            |text        |YES |   |NULL   |     |
+	|delivery_window_start |varchar(5)  |YES |   |NULL   |     |
+	|delivery_window_end   |varchar(5)  |YES |   |NULL   |     |
 	+----------------------+------------+----+---+-------+-----+
 */
 type Questionnaire struct {
@@ -24,7 +28,13 @@ type Questionnaire struct {
 	Name                 string        `db:"name"`
 	Questions            string        `db:"questions"`
 	MaxAttempts          sql.NullInt64 `db:"max_attempts"`
-	HoursBetweenAttempts sql.NullInt64 `db:"max_attempts"`
+	HoursBetweenAttempts sql.NullInt64 `db:"hours_between_attempts"`
+	// Recurrence RRULE style (RFC 5545) schedule, which takes over from HoursBetweenAttempts when it's set
+	Recurrence sql.NullString `db:"recurrence"`
+	// DeliveryWindowStart and DeliveryWindowEnd the HH:MM local time attempts can be scheduled between, e.g. 08:00 and
+	// 21:00, attempts can be scheduled at any time of day when they aren't set
+	DeliveryWindowStart sql.NullString `db:"delivery_window_start"`
+	DeliveryWindowEnd   sql.NullString `db:"delivery_window_end"`
 }
 
 type Questionnaires []*Questionnaire
@@ -54,3 +64,50 @@ func (q *Questionnaire) GetHoursBetweenAttemptsDuration() (duration time.Duratio
 	duration, _ = time.ParseDuration("24h")
 	return
 }
+
+// GetRecurrence nil when the questionnaire doesn't have a recurrence rule
+func (q *Questionnaire) GetRecurrence() (*recurrence.Recurrence, error) {
+	if !q.Recurrence.Valid || q.Recurrence.String == "" {
+		return nil, nil
+	}
+
+	r, err := recurrence.Parse(q.Recurrence.String)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recurrence for questionnaire (id: %s): %v", q.Id, err)
+	}
+	return r, nil
+}
+
+// NextAttemptAt when the next attempt is due, after the questionnaire was completed at completedAt. With a recurrence
+// rule, it's the rule's next occurrence for a schedule that started at anchor, and false once the rule has ended.
+// Otherwise, it's HoursBetweenAttempts after completedAt. A whole number of days is counted on the calendar in
+// completedAt's location, so a daily attempt stays at the same local time when the clocks change
+func (q *Questionnaire) NextAttemptAt(anchor, completedAt time.Time) (time.Time, bool, error) {
+	r, err := q.GetRecurrence()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if r == nil {
+		between := q.GetHoursBetweenAttemptsDuration()
+		if day := 24 * time.Hour; between > 0 && between%day == 0 {
+			return completedAt.AddDate(0, 0, int(between/day)), true, nil
+		}
+		return completedAt.Add(between), true, nil
+	}
+
+	next, ok := r.Next(anchor, completedAt)
+	return next, ok, nil
+}
+
+// GetDeliveryWindow nil when attempts can be scheduled at any time of day
+func (q *Questionnaire) GetDeliveryWindow() (*recurrence.Window, error) {
+	if !q.DeliveryWindowStart.Valid && !q.DeliveryWindowEnd.Valid {
+		return nil, nil
+	}
+
+	w, err := recurrence.NewWindow(q.DeliveryWindowStart.String, q.DeliveryWindowEnd.String)
+	if err != nil {
+		return nil, fmt.Errorf("invalid delivery window for questionnaire (id: %s): %v", q.Id, err)
+	}
+	return w, nil
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"github.com/stretchr/testify/suite"
 	"testing"
+	"time"
 )
 
 type QuestionnaireTestSuite struct {
@@ -45,6 +46,78 @@ func (suite *QuestionnaireTestSuite) Test_GetHoursBetweenAttemptsDuration() {
 	})
 }
 
+func (suite *QuestionnaireTestSuite) Test_NextAttemptAt() {
+	anchor := time.Date(2022, 7, 18, 9, 0, 0, 0, time.UTC)
+	completedAt := time.Date(2022, 7, 20, 14, 30, 0, 0, time.UTC)
+
+	suite.Run("without a recurrence, it's hours between attempts after completion", func() {
+		questionnaire := Questionnaire{HoursBetweenAttempts: sql.NullInt64{Int64: 4, Valid: true}}
+		next, ok, err := questionnaire.NextAttemptAt(anchor, completedAt)
+		suite.Require().NoError(err)
+		suite.True(ok)
+		suite.Equal(completedAt.Add(4*time.Hour), next)
+	})
+
+	suite.Run("whole days are counted on the local calendar, across the clocks going back", func() {
+		london, err := time.LoadLocation("Europe/London")
+		suite.Require().NoError(err)
+		// the clocks go back an hour on the 30th of October, so the day after the 29th is 25 hours long
+		completedAt := time.Date(2022, 10, 29, 9, 0, 0, 0, london)
+
+		next, ok, err := (&Questionnaire{}).NextAttemptAt(completedAt, completedAt)
+		suite.Require().NoError(err)
+		suite.True(ok)
+		suite.Equal(time.Date(2022, 10, 30, 9, 0, 0, 0, london), next)
+		suite.Equal(25*time.Hour, next.Sub(completedAt))
+
+		questionnaire := Questionnaire{HoursBetweenAttempts: sql.NullInt64{Int64: 36, Valid: true}}
+		next, _, err = questionnaire.NextAttemptAt(completedAt, completedAt)
+		suite.Require().NoError(err)
+		suite.Equal(completedAt.Add(36*time.Hour), next)
+	})
+
+	suite.Run("every Monday at 09:00", func() {
+		questionnaire := Questionnaire{Recurrence: sql.NullString{Valid: true, String: "RRULE:FREQ=WEEKLY;BYDAY=MO;BYHOUR=9;BYMINUTE=0"}}
+		next, ok, err := questionnaire.NextAttemptAt(anchor, completedAt)
+		suite.Require().NoError(err)
+		suite.True(ok)
+		suite.Equal(time.Date(2022, 7, 25, 9, 0, 0, 0, time.UTC), next)
+	})
+
+	suite.Run("once the recurrence has ended", func() {
+		questionnaire := Questionnaire{Recurrence: sql.NullString{Valid: true, String: "RRULE:FREQ=DAILY;COUNT=2"}}
+		_, ok, err := questionnaire.NextAttemptAt(anchor, completedAt)
+		suite.Require().NoError(err)
+		suite.False(ok)
+	})
+
+	suite.Run("an invalid recurrence", func() {
+		questionnaire := Questionnaire{Id: "QUESTIONNAIRE1", Recurrence: sql.NullString{Valid: true, String: "FREQ=FORTNIGHTLY"}}
+		_, _, err := questionnaire.NextAttemptAt(anchor, completedAt)
+		suite.EqualError(err, `invalid recurrence for questionnaire (id: QUESTIONNAIRE1): unsupported recurrence frequency "FORTNIGHTLY"`)
+	})
+}
+
+func (suite *QuestionnaireTestSuite) Test_GetDeliveryWindow() {
+	suite.Run("when there isn't one", func() {
+		window, err := (&Questionnaire{}).GetDeliveryWindow()
+		suite.Require().NoError(err)
+		suite.Nil(window)
+	})
+
+	suite.Run("08:00 to 21:00", func() {
+		window, err := (&Questionnaire{DeliveryWindowStart: sql.NullString{Valid: true, String: "08:00"},
+			DeliveryWindowEnd: sql.NullString{Valid: true, String: "21:00"}}).GetDeliveryWindow()
+		suite.Require().NoError(err)
+		suite.Equal("08:00-21:00", window.String())
+	})
+
+	suite.Run("when only one end is set", func() {
+		_, err := (&Questionnaire{Id: "QUESTIONNAIRE1", DeliveryWindowStart: sql.NullString{Valid: true, String: "08:00"}}).GetDeliveryWindow()
+		suite.EqualError(err, `invalid delivery window for questionnaire (id: QUESTIONNAIRE1): invalid time of day "", expected HH:MM`)
+	})
+}
+
 func TestQuestionnaire(t *testing.T) {
 	suite.Run(t, new(QuestionnaireTestSuite))
 }
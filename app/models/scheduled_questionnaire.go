@@ -6,15 +6,15 @@ import (
 )
 
 /*
-	+----------------+---------------------------+----+---+-------+-----+
-	|Field           |Type                       |Null|Key|Default|Extra|
-	+----------------+---------------------------+----+---+-------+-----+
-	|id              |varchar(128)               |NO  |PRI|NULL   |     |
-	|questionnaire_id|varchar(128)               |NO  |   |NULL   |     |
-	|participant_id  |varchar(128)               |NO  |   |NULL   |     |
-	|scheduled_at    |datetime                   |NO  |   |NULL   |     |
-	|status          |enum('pending','completed')|YES |   |NULL   |     |
-	+----------------+---------------------------+----+---+-------+-----+
+	+----------------+---------------------------------------+----+---+-------+-----+
+	|Field           |Type                                   |Null|Key|Default|Extra|
+	+----------------+---------------------------------------+----+---+-------+-----+
+	|id              |varchar(128)                           |NO  |PRI|NULL   |     |
+	|questionnaire_id|varchar(128)                           |NO  |   |NULL   |     |
+	|participant_id  |varchar(128)                           |NO  |   |NULL   |     |
+	|scheduled_at    |datetime                               |NO  |   |NULL   |     |
+	|status          |enum('pending','completed','cancelled')|YES |   |NULL   |     |
+	+----------------+---------------------------------------+----+---+-------+-----+
 */
 type ScheduledQuestionnaire struct {
 	Id              string         `db:"id"`
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+/*
+	+----------+------------+----+---+-------+-----+
+	|Field     |Type        |Null|Key|Default|Extra|
+	+----------+------------+----+---+-------+-----+
+	|version   |int(11)     |NO  |PRI|NULL   |     |
+	|name      |varchar(128)|NO  |   |NULL   |     |
+	|applied_at|datetime    |NO  |   |NULL   |     |
+	+----------+------------+----+---+-------+-----+
+*/
+type SchemaMigration struct {
+	Version   int       `db:"version"`
+	Name      string    `db:"name"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+type SchemaMigrations []*SchemaMigration
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/jamesineda/reschedular/app/recurrence"
+)
+
+/*
+	+---------+------------+----+---+-------+-----+
+	|Field    |Type        |Null|Key|Default|Extra|
+	+---------+------------+----+---+-------+-----+
+	|id       |varchar(128)|NO  |PRI|NULL   |     |
+	|study_id |varchar(128)|NO  |MUL|NULL   |     |
+	|starts_on|varchar(10) |NO  |   |NULL   |     |
+	|ends_on  |varchar(10) |NO  |   |NULL   |     |
+	|reason   |varchar(255)|YES |   |NULL   |     |
+	+---------+------------+----+---+-------+-----+
+*/
+type StudyBlackout struct {
+	Id      string `db:"id"`
+	StudyId string `db:"study_id"`
+	// StartsOn and EndsOn the YYYY-MM-DD dates, inclusive, that attempts can't be scheduled on in the participant's
+	// time zone, e.g. 2022-12-25 and 2022-12-26
+	StartsOn string `db:"starts_on"`
+	EndsOn   string `db:"ends_on"`
+	// Reason why, e.g. Christmas
+	Reason sql.NullString `db:"reason"`
+}
+
+type StudyBlackouts []*StudyBlackout
+
+func (b *StudyBlackout) GetBlackout() (*recurrence.Blackout, error) {
+	blackout, err := recurrence.NewBlackout(b.StartsOn, b.EndsOn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid blackout for study (id: %s, blackout_id: %s): %v", b.StudyId, b.Id, err)
+	}
+	return blackout, nil
+}
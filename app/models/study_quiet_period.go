@@ -0,0 +1,38 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/jamesineda/reschedular/app/recurrence"
+)
+
+/*
+	+----------+------------+----+---+-------+-----+
+	|Field     |Type        |Null|Key|Default|Extra|
+	+----------+------------+----+---+-------+-----+
+	|id        |varchar(128)|NO  |PRI|NULL   |     |
+	|study_id  |varchar(128)|NO  |MUL|NULL   |     |
+	|start_time|varchar(5)  |NO  |   |NULL   |     |
+	|end_time  |varchar(5)  |NO  |   |NULL   |     |
+	|days      |varchar(32) |YES |   |NULL   |     |
+	+----------+------------+----+---+-------+-----+
+*/
+type StudyQuietPeriod struct {
+	Id      string `db:"id"`
+	StudyId string `db:"study_id"`
+	// StartTime and EndTime the HH:MM local time attempts can't be scheduled between, e.g. 22:00 and 07:00
+	StartTime string `db:"start_time"`
+	EndTime   string `db:"end_time"`
+	// Days the days the quiet period starts on, e.g. SA,SU, it's every day when this is null
+	Days sql.NullString `db:"days"`
+}
+
+type StudyQuietPeriods []*StudyQuietPeriod
+
+func (p *StudyQuietPeriod) GetQuietHours() (*recurrence.QuietHours, error) {
+	quiet, err := recurrence.NewQuietHours(p.StartTime, p.EndTime, p.Days.String)
+	if err != nil {
+		return nil, fmt.Errorf("invalid quiet period for study (id: %s, quiet_period_id: %s): %v", p.StudyId, p.Id, err)
+	}
+	return quiet, nil
+}
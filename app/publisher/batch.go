@@ -0,0 +1,51 @@
+package publisher
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/jamesineda/reschedular/app/event"
+	"strconv"
+)
+
+// maxAWSBatchSize the most entries SQS SendMessageBatch and SNS PublishBatch accept in a single call
+const maxAWSBatchSize = 10
+
+// publishEach for publishers without a batch API, events are published one at a time
+func publishEach(p event.Publisher, events event.IncomingEvents) []error {
+	errs := make([]error, len(events))
+	for i, e := range events {
+		errs[i] = p.Publish(e)
+	}
+	return errs
+}
+
+// publishInChunks splits events into chunks the AWS batch APIs accept. The batch entry ids are the index of the event
+// within the chunk, publishChunk is expected to return the failures keyed by entry id
+func publishInChunks(events event.IncomingEvents, publishChunk func(chunk event.IncomingEvents) (map[string]error, error)) []error {
+	errs := make([]error, len(events))
+	for start := 0; start < len(events); start += maxAWSBatchSize {
+		end := start + maxAWSBatchSize
+		if end > len(events) {
+			end = len(events)
+		}
+
+		failed, err := publishChunk(events[start:end])
+		for i := start; i < end; i++ {
+			if err != nil {
+				// the whole call failed, so none of the chunk was published
+				errs[i] = err
+			} else if entryErr, ok := failed[batchEntryId(i-start)]; ok {
+				errs[i] = entryErr
+			}
+		}
+	}
+	return errs
+}
+
+func batchEntryId(i int) string {
+	return strconv.Itoa(i)
+}
+
+func batchEntryError(code, message *string) error {
+	return fmt.Errorf("%s: %s", aws.StringValue(code), aws.StringValue(message))
+}
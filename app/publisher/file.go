@@ -0,0 +1,60 @@
+package publisher
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/jamesineda/reschedular/app/event"
+	"os"
+	"sync"
+)
+
+// FilePublisher appends each event to a newline-delimited JSON file, for running the service locally
+type FilePublisher struct {
+	sync.Mutex
+	path string
+}
+
+// FileMessage a single line in the file written by the FilePublisher
+type FileMessage struct {
+	Type       string            `json:"type"`
+	Attributes map[string]string `json:"attributes"`
+	Body       json.RawMessage   `json:"body"`
+}
+
+func NewFilePublisher(path string) *FilePublisher {
+	return &FilePublisher{path: path}
+}
+
+func (p *FilePublisher) Publish(e event.IncomingEvent) error {
+	attributes := make(map[string]string)
+	for name, value := range e.ToSQSMessage() {
+		attributes[name] = aws.StringValue(value.StringValue)
+	}
+
+	body, err := event.MarshalEnvelope(e)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(&FileMessage{Type: e.FunctionName(), Attributes: attributes, Body: body})
+	if err != nil {
+		return err
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	f, err := os.OpenFile(p.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (p *FilePublisher) PublishBatch(events event.IncomingEvents) []error {
+	return publishEach(p, events)
+}
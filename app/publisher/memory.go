@@ -0,0 +1,40 @@
+package publisher
+
+import (
+	"github.com/jamesineda/reschedular/app/event"
+	"sync"
+)
+
+// MemoryPublisher records every published event, so tests can assert on what was sent. Setting Err makes every publish
+// fail with it
+type MemoryPublisher struct {
+	sync.Mutex
+	published event.IncomingEvents
+	Err       error
+}
+
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+func (p *MemoryPublisher) Publish(e event.IncomingEvent) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.Err != nil {
+		return p.Err
+	}
+
+	p.published = append(p.published, e)
+	return nil
+}
+
+func (p *MemoryPublisher) PublishBatch(events event.IncomingEvents) []error {
+	return publishEach(p, events)
+}
+
+// Published a copy of the events published so far, in the order they were published
+func (p *MemoryPublisher) Published() event.IncomingEvents {
+	p.Lock()
+	defer p.Unlock()
+	return append(event.IncomingEvents{}, p.published...)
+}
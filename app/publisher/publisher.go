@@ -0,0 +1,46 @@
+package publisher
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/jamesineda/reschedular/app/event"
+	"github.com/jamesineda/reschedular/app/utils"
+)
+
+const (
+	SQS    = "sqs"
+	SNS    = "sns"
+	File   = "file"
+	Memory = "memory"
+)
+
+// NewPublisher builds the publisher picked in the config. The AWS session is only created for the SQS and SNS
+// publishers, so the file and memory publishers can be used without an AWS account
+func NewPublisher(config *utils.PublisherConfig) (event.Publisher, error) {
+	switch config.Type {
+	case SQS:
+		if config.QueueUrl == "" {
+			return nil, fmt.Errorf("sqs publisher requires a queue_url")
+		}
+		return NewSQSPublisher(sqs.New(utils.NewAWSSession()), config.QueueUrl), nil
+
+	case SNS:
+		if config.TopicArn == "" {
+			return nil, fmt.Errorf("sns publisher requires a topic_arn")
+		}
+		return NewSNSPublisher(sns.New(utils.NewAWSSession()), config.TopicArn), nil
+
+	case File:
+		if config.Path == "" {
+			return nil, fmt.Errorf("file publisher requires a path")
+		}
+		return NewFilePublisher(config.Path), nil
+
+	case Memory:
+		return NewMemoryPublisher(), nil
+
+	default:
+		return nil, fmt.Errorf("unknown publisher type %s", config.Type)
+	}
+}
@@ -0,0 +1,173 @@
+package publisher
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/jamesineda/reschedular/app/event"
+	"github.com/jamesineda/reschedular/app/utils"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeSQS records the messages sent to it, anything it doesn't override panics via the nil embedded interface
+type fakeSQS struct {
+	sqsiface.SQSAPI
+	sent    []*sqs.SendMessageInput
+	batches []*sqs.SendMessageBatchInput
+	// failIds batch entry ids that are reported back as failed
+	failIds map[string]bool
+}
+
+func (f *fakeSQS) SendMessage(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	f.sent = append(f.sent, input)
+	return &sqs.SendMessageOutput{}, nil
+}
+
+func (f *fakeSQS) SendMessageBatch(input *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+	f.batches = append(f.batches, input)
+	output := &sqs.SendMessageBatchOutput{}
+	for _, entry := range input.Entries {
+		if f.failIds[aws.StringValue(entry.Id)] {
+			output.Failed = append(output.Failed, &sqs.BatchResultErrorEntry{
+				Id:      entry.Id,
+				Code:    aws.String("InvalidMessageContents"),
+				Message: aws.String("message rejected"),
+			})
+			continue
+		}
+		output.Successful = append(output.Successful, &sqs.SendMessageBatchResultEntry{Id: entry.Id})
+	}
+	return output, nil
+}
+
+type PublisherSuite struct {
+	suite.Suite
+	Event event.IncomingEvent
+}
+
+func (suite *PublisherSuite) SetupTest() {
+	suite.Event = &event.ScheduledQuestionnaireEvent{
+		Name:            event.ScheduledQuestionnaire,
+		Id:              "ABC123",
+		ParticipantId:   "PARTICIPANT1",
+		QuestionnaireId: "QUESTIONNAIRE1",
+		Status:          event.Pending,
+		ScheduledAt:     time.Date(2022, 7, 19, 10, 0, 0, 0, time.UTC),
+	}
+}
+
+func (suite *PublisherSuite) Test_NewPublisher() {
+	suite.Run("when the type is unknown", func() {
+		_, err := NewPublisher(&utils.PublisherConfig{Type: "carrier-pigeon"})
+		suite.Error(err)
+	})
+
+	suite.Run("when the sqs publisher has no queue url", func() {
+		_, err := NewPublisher(&utils.PublisherConfig{Type: SQS})
+		suite.Error(err)
+	})
+
+	suite.Run("when the memory publisher is picked", func() {
+		p, err := NewPublisher(&utils.PublisherConfig{Type: Memory})
+		suite.Require().NoError(err)
+		suite.IsType(&MemoryPublisher{}, p)
+	})
+}
+
+func (suite *PublisherSuite) Test_SQSPublisher() {
+	svc := &fakeSQS{}
+	suite.Require().NoError(NewSQSPublisher(svc, "https://sqs.example/queue").Publish(suite.Event))
+
+	suite.Require().Len(svc.sent, 1)
+	suite.Equal("https://sqs.example/queue", aws.StringValue(svc.sent[0].QueueUrl))
+	suite.Equal(suite.Event.ToSQSMessage(), svc.sent[0].MessageAttributes)
+
+	var envelope event.Envelope
+	suite.Require().NoError(json.Unmarshal([]byte(aws.StringValue(svc.sent[0].MessageBody)), &envelope))
+	suite.Equal(event.ScheduledQuestionnaire, envelope.Type)
+	suite.Equal("ABC123", envelope.EventId)
+}
+
+func (suite *PublisherSuite) Test_SQSPublisher_PublishBatch() {
+	events := make(event.IncomingEvents, 12)
+	for i := range events {
+		events[i] = suite.Event
+	}
+
+	suite.Run("events are split into batches of 10", func() {
+		svc := &fakeSQS{}
+		errs := NewSQSPublisher(svc, "https://sqs.example/queue").PublishBatch(events)
+
+		suite.Equal(make([]error, 12), errs)
+		suite.Require().Len(svc.batches, 2)
+		suite.Len(svc.batches[0].Entries, 10)
+		suite.Len(svc.batches[1].Entries, 2)
+	})
+
+	suite.Run("failed entries only fail their own event", func() {
+		svc := &fakeSQS{failIds: map[string]bool{"1": true}}
+		errs := NewSQSPublisher(svc, "https://sqs.example/queue").PublishBatch(events)
+
+		suite.Require().Len(errs, 12)
+		for i, err := range errs {
+			if i == 1 || i == 11 {
+				suite.EqualError(err, "InvalidMessageContents: message rejected")
+				continue
+			}
+			suite.NoError(err)
+		}
+	})
+}
+
+func (suite *PublisherSuite) Test_FilePublisher() {
+	path := filepath.Join(suite.T().TempDir(), "events.ndjson")
+	p := NewFilePublisher(path)
+	suite.Require().NoError(p.Publish(suite.Event))
+	suite.Require().NoError(p.Publish(suite.Event))
+
+	f, err := os.Open(path)
+	suite.Require().NoError(err)
+	defer f.Close()
+
+	var lines []FileMessage
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line FileMessage
+		suite.Require().NoError(json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+
+	suite.Require().Len(lines, 2)
+	suite.Equal(event.ScheduledQuestionnaire, lines[0].Type)
+	suite.Equal("PARTICIPANT1", lines[0].Attributes["ParticipantId"])
+
+	var envelope event.Envelope
+	suite.Require().NoError(json.Unmarshal(lines[0].Body, &envelope))
+	suite.Equal(event.ScheduledQuestionnaire, envelope.Type)
+}
+
+func (suite *PublisherSuite) Test_MemoryPublisher() {
+	suite.Run("published events are recorded in order", func() {
+		p := NewMemoryPublisher()
+		suite.Require().NoError(p.Publish(suite.Event))
+		suite.Equal(event.IncomingEvents{suite.Event}, p.Published())
+	})
+
+	suite.Run("when the publisher is set to fail", func() {
+		p := NewMemoryPublisher()
+		p.Err = fmt.Errorf("publish failed")
+		suite.Error(p.Publish(suite.Event))
+		suite.Empty(p.Published())
+	})
+}
+
+func TestPublisherSuite(t *testing.T) {
+	suite.Run(t, new(PublisherSuite))
+}
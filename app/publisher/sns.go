@@ -0,0 +1,79 @@
+package publisher
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/jamesineda/reschedular/app/event"
+)
+
+// SNSPublisher publishes each event to an SNS topic, fanning it out to every subscriber of the topic
+type SNSPublisher struct {
+	svc      snsiface.SNSAPI
+	topicArn string
+}
+
+func NewSNSPublisher(svc snsiface.SNSAPI, topicArn string) *SNSPublisher {
+	return &SNSPublisher{svc: svc, topicArn: topicArn}
+}
+
+func (p *SNSPublisher) Publish(e event.IncomingEvent) error {
+	body, err := event.MarshalEnvelope(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.svc.Publish(&sns.PublishInput{
+		Message:           aws.String(string(body)),
+		MessageAttributes: toSNSAttributes(e),
+		TopicArn:          aws.String(p.topicArn),
+	})
+	return err
+}
+
+// PublishBatch publishes the events with PublishBatch, up to 10 at a time. Entries that SNS rejects fail on their own
+// without affecting the rest of the batch
+func (p *SNSPublisher) PublishBatch(events event.IncomingEvents) []error {
+	return publishInChunks(events, func(chunk event.IncomingEvents) (map[string]error, error) {
+		entries := make([]*sns.PublishBatchRequestEntry, len(chunk))
+		for i, e := range chunk {
+			body, err := event.MarshalEnvelope(e)
+			if err != nil {
+				return nil, err
+			}
+
+			entries[i] = &sns.PublishBatchRequestEntry{
+				Id:                aws.String(batchEntryId(i)),
+				Message:           aws.String(string(body)),
+				MessageAttributes: toSNSAttributes(e),
+			}
+		}
+
+		output, err := p.svc.PublishBatch(&sns.PublishBatchInput{
+			PublishBatchRequestEntries: entries,
+			TopicArn:                   aws.String(p.topicArn),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		failed := make(map[string]error)
+		for _, entry := range output.Failed {
+			failed[aws.StringValue(entry.Id)] = batchEntryError(entry.Code, entry.Message)
+		}
+		return failed, nil
+	})
+}
+
+// toSNSAttributes events only know how to build SQS attributes, which have the same shape as SNS ones
+func toSNSAttributes(e event.IncomingEvent) map[string]*sns.MessageAttributeValue {
+	attributes := make(map[string]*sns.MessageAttributeValue)
+	for name, value := range e.ToSQSMessage() {
+		attributes[name] = &sns.MessageAttributeValue{
+			BinaryValue: value.BinaryValue,
+			DataType:    value.DataType,
+			StringValue: value.StringValue,
+		}
+	}
+	return attributes
+}
@@ -0,0 +1,68 @@
+package publisher
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/jamesineda/reschedular/app/event"
+)
+
+// SQSPublisher sends each event as a message on a single SQS queue
+type SQSPublisher struct {
+	svc      sqsiface.SQSAPI
+	queueUrl string
+}
+
+func NewSQSPublisher(svc sqsiface.SQSAPI, queueUrl string) *SQSPublisher {
+	return &SQSPublisher{svc: svc, queueUrl: queueUrl}
+}
+
+func (p *SQSPublisher) Publish(e event.IncomingEvent) error {
+	body, err := event.MarshalEnvelope(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.svc.SendMessage(&sqs.SendMessageInput{
+		DelaySeconds:      aws.Int64(10),
+		MessageAttributes: e.ToSQSMessage(),
+		MessageBody:       aws.String(string(body)),
+		QueueUrl:          aws.String(p.queueUrl),
+	})
+	return err
+}
+
+// PublishBatch sends the events with SendMessageBatch, up to 10 at a time. Entries that SQS rejects fail on their own
+// without affecting the rest of the batch
+func (p *SQSPublisher) PublishBatch(events event.IncomingEvents) []error {
+	return publishInChunks(events, func(chunk event.IncomingEvents) (map[string]error, error) {
+		entries := make([]*sqs.SendMessageBatchRequestEntry, len(chunk))
+		for i, e := range chunk {
+			body, err := event.MarshalEnvelope(e)
+			if err != nil {
+				return nil, err
+			}
+
+			entries[i] = &sqs.SendMessageBatchRequestEntry{
+				DelaySeconds:      aws.Int64(10),
+				Id:                aws.String(batchEntryId(i)),
+				MessageAttributes: e.ToSQSMessage(),
+				MessageBody:       aws.String(string(body)),
+			}
+		}
+
+		output, err := p.svc.SendMessageBatch(&sqs.SendMessageBatchInput{
+			Entries:  entries,
+			QueueUrl: aws.String(p.queueUrl),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		failed := make(map[string]error)
+		for _, entry := range output.Failed {
+			failed[aws.StringValue(entry.Id)] = batchEntryError(entry.Code, entry.Message)
+		}
+		return failed, nil
+	})
+}
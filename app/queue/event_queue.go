@@ -1,20 +1,73 @@
 package queue
 
 import (
+	"context"
+	"fmt"
 	"github.com/jamesineda/reschedular/app/event"
+	"github.com/jamesineda/reschedular/app/utils"
+	"log"
 	"sync"
+	"time"
 )
 
+// overflow policies, for when an event is pushed onto a full queue
+const (
+	Block      = "block"       // wait for space, up to the block timeout
+	DropOldest = "drop_oldest" // drop the event at the front of the queue to make room
+	Reject     = "reject"      // return ErrQueueFull
+	Spill      = "spill"       // write the event to the spill file, it's read back in once there's room
+)
+
+var ErrQueueFull = fmt.Errorf("events queue is full")
+
 type Events struct {
 	sync.Mutex
 	Queue event.IncomingEvents
+	// notify holds a single token whenever there may be events waiting, so blocked pops wake up without polling
+	notify chan struct{}
+	// space holds a single token whenever there may be room on a bounded queue, for blocked pushes
+	space chan struct{}
+
+	maxSize      int // zero for an unbounded queue
+	policy       string
+	blockTimeout time.Duration
+	spill        *spillFile
 }
 
-// NewEventsQueue setting a capacity just alleviates some of the re-sizing capacity
+// NewEventsQueue setting a capacity just alleviates some of the re-sizing capacity, the queue itself is unbounded
 func NewEventsQueue(capacity int) *Events {
 	return &Events{
-		Queue: make(event.IncomingEvents, 0, capacity),
+		Queue:  make(event.IncomingEvents, 0, capacity),
+		notify: make(chan struct{}, 1),
+		space:  make(chan struct{}, 1),
+	}
+}
+
+// NewBoundedEventsQueue a queue that holds at most config.MaxSize events, config.OverflowPolicy decides what happens
+// when something is pushed onto it once it's full
+func NewBoundedEventsQueue(config *utils.QueueConfig) (*Events, error) {
+	q := NewEventsQueue(config.MaxSize)
+	q.maxSize = config.MaxSize
+	q.policy = config.OverflowPolicy
+	q.blockTimeout = config.BlockTimeout
+
+	switch config.OverflowPolicy {
+	case Block, DropOldest, Reject:
+	case Spill:
+		if config.SpillPath == "" {
+			return nil, fmt.Errorf("the %s overflow policy needs a spill_path", Spill)
+		}
+		spill, err := newSpillFile(config.SpillPath)
+		if err != nil {
+			return nil, err
+		}
+		q.spill = spill
+		// anything spilled by a previous run is read back in first
+		q.refill()
+	default:
+		return nil, fmt.Errorf("unknown queue overflow policy %s", config.OverflowPolicy)
 	}
+	return q, nil
 }
 
 func (q *Events) Pop() event.IncomingEvent {
@@ -27,11 +80,127 @@ func (q *Events) Pop() event.IncomingEvent {
 	e := q.Queue[0]
 	q.Queue[0] = nil
 	q.Queue = q.Queue[1:]
+	q.popped()
 	return e
 }
 
-func (q *Events) Push(instruction event.IncomingEvent) {
+// PopContext blocks until there's an event to pop, or the context is done
+func (q *Events) PopContext(ctx context.Context) (event.IncomingEvent, error) {
+	for {
+		if e := q.Pop(); e != nil {
+			return e, nil
+		}
+
+		select {
+		case <-q.notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Push an error is only returned by a bounded queue that is full, and whose overflow policy couldn't make room for the
+// event
+func (q *Events) Push(instruction event.IncomingEvent) error {
+	var timeout <-chan time.Time
+	for {
+		q.Lock()
+		if !q.isFull() {
+			q.Queue = append(q.Queue, instruction)
+			q.signal()
+			q.Unlock()
+			return nil
+		}
+
+		switch q.policy {
+		case DropOldest:
+			dropped := q.Queue[0]
+			q.Queue[0] = nil
+			q.Queue = append(q.Queue[1:], instruction)
+			q.signal()
+			q.Unlock()
+			log.Printf("events queue is full, dropped %s event (id: %s)", dropped.FunctionName(), dropped.EventId())
+			return nil
+
+		case Spill:
+			err := q.spill.write(instruction)
+			q.Unlock()
+			return err
+
+		case Block:
+			q.Unlock()
+			if timeout == nil && q.blockTimeout > 0 {
+				timeout = time.After(q.blockTimeout)
+			}
+
+			select {
+			case <-q.space:
+			case <-timeout:
+				return ErrQueueFull
+			}
+
+		default:
+			q.Unlock()
+			return ErrQueueFull
+		}
+	}
+}
+
+// Len the number of events on the queue, including any that have been spilled to disk
+func (q *Events) Len() int {
 	q.Lock()
 	defer q.Unlock()
-	q.Queue = append(q.Queue, instruction)
+	if q.spill != nil {
+		return len(q.Queue) + q.spill.len()
+	}
+	return len(q.Queue)
+}
+
+// isFull once events have been spilled, everything else goes to the spill file too, until it has been read back in.
+// Otherwise newer events would jump ahead of the spilled ones
+func (q *Events) isFull() bool {
+	if q.maxSize <= 0 {
+		return false
+	}
+	return len(q.Queue) >= q.maxSize || (q.spill != nil && q.spill.len() > 0)
+}
+
+// popped reads spilled events back in and wakes up anything waiting on the queue, must be called while holding the lock
+func (q *Events) popped() {
+	if q.spill != nil {
+		q.refill()
+	}
+	q.signal()
+
+	select {
+	case q.space <- struct{}{}:
+	default:
+	}
+}
+
+// refill must be called while holding the lock
+func (q *Events) refill() {
+	room := q.maxSize - len(q.Queue)
+	if room <= 0 || q.spill.len() == 0 {
+		return
+	}
+
+	events, err := q.spill.read(room)
+	if err != nil {
+		log.Printf("failed to read spilled events back in: %s", err)
+		return
+	}
+	q.Queue = append(q.Queue, events...)
+}
+
+// signal wakes up a blocked pop if there's anything left on the queue, must be called while holding the lock
+func (q *Events) signal() {
+	if len(q.Queue) == 0 {
+		return
+	}
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
 }
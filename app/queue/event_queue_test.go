@@ -0,0 +1,265 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"github.com/jamesineda/reschedular/app/db"
+	"github.com/jamesineda/reschedular/app/event"
+	"github.com/jamesineda/reschedular/app/publisher"
+	"github.com/jamesineda/reschedular/app/utils"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type EventsQueueTestSuite struct {
+	suite.Suite
+	Queue  *Events
+	Events event.IncomingEvents
+}
+
+func (suite *EventsQueueTestSuite) SetupTest() {
+	suite.Queue = NewEventsQueue(10)
+	suite.Events = event.IncomingEvents{
+		&event.ScheduledQuestionnaireEvent{Name: event.ScheduledQuestionnaire, Id: "ABC123"},
+		&event.ScheduledQuestionnaireEvent{Name: event.ScheduledQuestionnaire, Id: "ABC456"},
+		&event.ScheduledQuestionnaireEvent{Name: event.ScheduledQuestionnaire, Id: "ABC789"},
+	}
+	for _, e := range suite.Events {
+		suite.Require().NoError(suite.Queue.Push(e))
+	}
+}
+
+func (suite *EventsQueueTestSuite) Test_Pop() {
+	suite.Run("events are popped in the order they were pushed", func() {
+		for _, e := range suite.Events {
+			suite.Equal(e, suite.Queue.Pop())
+		}
+	})
+
+	suite.Run("nil is returned when the queue is empty", func() {
+		suite.Nil(suite.Queue.Pop())
+	})
+}
+
+func (suite *EventsQueueTestSuite) Test_PopContext() {
+	suite.Run("pops straight away when there's an event waiting", func() {
+		e, err := suite.Queue.PopContext(context.Background())
+		suite.NoError(err)
+		suite.Equal(suite.Events[0], e)
+	})
+
+	suite.Run("blocks until an event is pushed", func() {
+		empty := NewEventsQueue(10)
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			_ = empty.Push(suite.Events[0])
+		}()
+
+		e, err := empty.PopContext(context.Background())
+		suite.NoError(err)
+		suite.Equal(suite.Events[0], e)
+	})
+
+	suite.Run("returns once the context is cancelled", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		e, err := NewEventsQueue(10).PopContext(ctx)
+		suite.Nil(e)
+		suite.ErrorIs(err, context.DeadlineExceeded)
+	})
+}
+
+func (suite *EventsQueueTestSuite) newBoundedQueue(policy string) *Events {
+	q, err := NewBoundedEventsQueue(&utils.QueueConfig{
+		MaxSize:        2,
+		OverflowPolicy: policy,
+		BlockTimeout:   10 * time.Millisecond,
+		SpillPath:      filepath.Join(suite.T().TempDir(), "spill.ndjson"),
+	})
+	suite.Require().NoError(err)
+	suite.Require().NoError(q.Push(suite.Events[0]))
+	suite.Require().NoError(q.Push(suite.Events[1]))
+	return q
+}
+
+func (suite *EventsQueueTestSuite) Test_NewBoundedEventsQueue() {
+	suite.Run("when the overflow policy is unknown", func() {
+		_, err := NewBoundedEventsQueue(&utils.QueueConfig{MaxSize: 2, OverflowPolicy: "shrug"})
+		suite.Error(err)
+	})
+
+	suite.Run("when spill doesn't have a spill path", func() {
+		_, err := NewBoundedEventsQueue(&utils.QueueConfig{MaxSize: 2, OverflowPolicy: Spill})
+		suite.EqualError(err, "the spill overflow policy needs a spill_path")
+	})
+}
+
+func (suite *EventsQueueTestSuite) Test_Push_WhenFull() {
+	suite.Run("reject returns ErrQueueFull", func() {
+		q := suite.newBoundedQueue(Reject)
+		suite.ErrorIs(q.Push(suite.Events[2]), ErrQueueFull)
+		suite.Equal(2, q.Len())
+	})
+
+	suite.Run("drop oldest makes room by dropping the front of the queue", func() {
+		q := suite.newBoundedQueue(DropOldest)
+		suite.NoError(q.Push(suite.Events[2]))
+		suite.Equal(suite.Events[1], q.Pop())
+		suite.Equal(suite.Events[2], q.Pop())
+		suite.Nil(q.Pop())
+	})
+
+	suite.Run("block returns ErrQueueFull after the block timeout", func() {
+		q := suite.newBoundedQueue(Block)
+		suite.ErrorIs(q.Push(suite.Events[2]), ErrQueueFull)
+	})
+
+	suite.Run("block waits for room on the queue", func() {
+		q := suite.newBoundedQueue(Block)
+		q.blockTimeout = time.Hour
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			q.Pop()
+		}()
+
+		suite.NoError(q.Push(suite.Events[2]))
+		suite.Equal(suite.Events[1], q.Pop())
+		suite.Equal(suite.Events[2], q.Pop())
+		suite.Nil(q.Pop())
+	})
+
+	suite.Run("spill writes to disk and reads back in order once there's room", func() {
+		q := suite.newBoundedQueue(Spill)
+		outboxed := &event.OutboxedEvent{IncomingEvent: suite.Events[2], OutboxId: "OUTBOX1"}
+		suite.NoError(q.Push(outboxed))
+		suite.Equal(3, q.Len())
+
+		suite.Equal(suite.Events[0], q.Pop())
+		suite.Equal(suite.Events[1], q.Pop())
+		suite.Equal(outboxed, q.Pop())
+		suite.Equal(0, q.Len())
+	})
+
+	suite.Run("spilled events are only read from the file once, and it's emptied once they've all been read", func() {
+		q := suite.newBoundedQueue(Spill)
+		for _, id := range []string{"DEF123", "DEF456", "DEF789"} {
+			suite.NoError(q.Push(&event.ScheduledQuestionnaireEvent{Name: event.ScheduledQuestionnaire, Id: id}))
+		}
+		info, err := os.Stat(q.spill.path)
+		suite.Require().NoError(err)
+		size := info.Size()
+
+		// popping reads the next spilled event in without rewriting the file
+		suite.Equal("ABC123", q.Pop().EventId())
+		info, err = os.Stat(q.spill.path)
+		suite.Require().NoError(err)
+		suite.Equal(size, info.Size())
+		suite.Equal(4, q.Len())
+
+		for _, id := range []string{"ABC456", "DEF123", "DEF456", "DEF789"} {
+			suite.Equal(id, q.Pop().EventId())
+		}
+		info, err = os.Stat(q.spill.path)
+		suite.Require().NoError(err)
+		suite.Zero(info.Size())
+	})
+
+	suite.Run("spilled events are read back in from where the last run got to", func() {
+		q := suite.newBoundedQueue(Spill)
+		for _, id := range []string{"DEF123", "DEF456", "DEF789"} {
+			suite.NoError(q.Push(&event.ScheduledQuestionnaireEvent{Name: event.ScheduledQuestionnaire, Id: id}))
+		}
+		suite.Equal("ABC123", q.Pop().EventId())
+
+		restarted, err := NewBoundedEventsQueue(&utils.QueueConfig{MaxSize: 2, OverflowPolicy: Spill, SpillPath: q.spill.path})
+		suite.Require().NoError(err)
+		suite.Equal(2, restarted.Len())
+		suite.Equal("DEF456", restarted.Pop().EventId())
+		suite.Equal("DEF789", restarted.Pop().EventId())
+		suite.Nil(restarted.Pop())
+	})
+
+	suite.Run("a line left partly written by a crash is skipped, rather than holding up everything after it", func() {
+		q := suite.newBoundedQueue(Spill)
+		for _, id := range []string{"DEF123", "DEF456", "DEF789"} {
+			suite.NoError(q.Push(&event.ScheduledQuestionnaireEvent{Name: event.ScheduledQuestionnaire, Id: id}))
+		}
+		f, err := os.OpenFile(q.spill.path, os.O_APPEND|os.O_WRONLY, 0644)
+		suite.Require().NoError(err)
+		_, err = f.WriteString(`{"id":"","event_type":"SCHEDULED_QUES`)
+		suite.Require().NoError(err)
+		suite.Require().NoError(f.Close())
+
+		restarted, err := NewBoundedEventsQueue(&utils.QueueConfig{MaxSize: 2, OverflowPolicy: Spill, SpillPath: q.spill.path})
+		suite.Require().NoError(err)
+		suite.NoError(restarted.Push(&event.ScheduledQuestionnaireEvent{Name: event.ScheduledQuestionnaire, Id: "GHI123"}))
+
+		for _, id := range []string{"DEF123", "DEF456", "DEF789", "GHI123"} {
+			suite.Equal(id, restarted.Pop().EventId())
+		}
+		suite.Nil(restarted.Pop())
+		suite.Equal(0, restarted.Len())
+
+		bad, err := os.ReadFile(q.spill.path + ".bad")
+		suite.Require().NoError(err)
+		suite.Equal("{\"id\":\"\",\"event_type\":\"SCHEDULED_QUES\n", string(bad))
+
+		// and the queue is back to holding events in memory
+		suite.NoError(restarted.Push(suite.Events[0]))
+		suite.Equal(suite.Events[0], restarted.Pop())
+	})
+}
+
+func (suite *EventsQueueTestSuite) Test_RequeueOnStartup() {
+	suite.Run("an outbox bigger than a blocking queue is sent instead of hanging", func() {
+		q, err := NewBoundedEventsQueue(&utils.QueueConfig{MaxSize: 2, OverflowPolicy: Block})
+		suite.Require().NoError(err)
+
+		dbConn := db.NewMemoryClient()
+		timer := utils.NewFakeTimer(time.Date(2022, 7, 18, 10, 0, 0, 0, time.UTC))
+		idGenny := utils.NewFakeIdGenny("OUTBOX1", "OUTBOX2", "OUTBOX3", "OUTBOX4", "OUTBOX5")
+		for i := 0; i < 5; i++ {
+			row, _, err := event.NewOutboxEvent(idGenny, timer, &event.ScheduledQuestionnaireEvent{
+				Name: event.ScheduledQuestionnaire, Id: fmt.Sprintf("ABC%d", i), ParticipantId: "PARTICIPANT1"})
+			suite.Require().NoError(err)
+			suite.Require().NoError(dbConn.Create(row))
+		}
+
+		published := publisher.NewMemoryPublisher()
+		ctx, cancel := context.WithCancel(context.Background())
+		var wg sync.WaitGroup
+		started := make(chan error, 1)
+		go func() {
+			started <- event.StartAsynchronousEventProcessor(ctx, &wg, &event.Dependencies{
+				DB:          dbConn,
+				Timer:       timer,
+				IdGenny:     idGenny,
+				Publisher:   published,
+				Queue:       q,
+				Retry:       utils.NewDefaultRetryConfig(),
+				Processor:   utils.NewDefaultProcessorConfig(),
+				Idempotency: event.NewDBIdempotencyStore(dbConn),
+			})
+		}()
+
+		select {
+		case err := <-started:
+			suite.NoError(err)
+		case <-time.After(time.Second):
+			suite.FailNow("starting the processor hung requeueing the outbox")
+		}
+
+		suite.Eventually(func() bool { return len(published.Published()) == 5 }, time.Second, time.Millisecond)
+		cancel()
+		wg.Wait()
+	})
+}
+
+func TestEventsQueue(t *testing.T) {
+	suite.Run(t, new(EventsQueueTestSuite))
+}
@@ -0,0 +1,218 @@
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/jamesineda/reschedular/app/event"
+	"github.com/jamesineda/reschedular/app/models"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// spillFile newline-delimited JSON file that a full queue spills events into. Each line is stored in the same shape
+// as an outbox_events row, so outboxed events keep their outbox id and can still be marked as sent. The file is only
+// ever appended to, events are read back in from offset, which is kept in a file next to it so a restart carries on
+// from the same place. Once everything has been read back in, the file is emptied. Lines that can't be read back in
+// are moved to a .bad file next to it
+type spillFile struct {
+	path   string
+	offset int64
+	count  int
+}
+
+func newSpillFile(path string) (*spillFile, error) {
+	s := &spillFile{path: path}
+	dat, err := os.ReadFile(s.offsetPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(dat) > 0 {
+		if s.offset, err = strconv.ParseInt(strings.TrimSpace(string(dat)), 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid spill file offset in %s: %v", s.offsetPath(), err)
+		}
+	}
+
+	// counting what's left is the only time the whole file is read
+	err = s.scan(-1, func(line []byte) (bool, error) {
+		s.count++
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *spillFile) len() int {
+	return s.count
+}
+
+func (s *spillFile) offsetPath() string {
+	return s.path + ".offset"
+}
+
+func (s *spillFile) badPath() string {
+	return s.path + ".bad"
+}
+
+func (s *spillFile) write(e event.IncomingEvent) error {
+	row := &models.OutboxEvent{EventType: e.FunctionName()}
+	if outboxed, ok := e.(*event.OutboxedEvent); ok {
+		row.Id = outboxed.OutboxId
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	row.Payload = string(payload)
+
+	line, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+
+	// a crash part way through an append leaves a partly written last line, which is ended before this one is added
+	// so it doesn't take this event down with it. It's read back in, and skipped, like any other line
+	terminated, err := endsInNewline(f)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	if !terminated {
+		line = append([]byte{'\n'}, line...)
+		s.count++
+	}
+
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	s.count++
+	return f.Close()
+}
+
+// endsInNewline whether the file is empty, or its last line is a whole one
+func endsInNewline(f *os.File) (bool, error) {
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return true, err
+	}
+
+	last := make([]byte, 1)
+	if _, err = f.ReadAt(last, info.Size()-1); err != nil {
+		return false, err
+	}
+	return last[0] == '\n', nil
+}
+
+// read takes up to max events from the offset, only the lines that are read are touched. A line that can't be decoded
+// is moved to the .bad file rather than tried again, otherwise nothing after it would ever be read
+func (s *spillFile) read(max int) (events event.IncomingEvents, err error) {
+	offset, read := s.offset, 0
+	err = s.scan(max, func(line []byte) (bool, error) {
+		read++
+		var row models.OutboxEvent
+		if err := json.Unmarshal(line, &row); err != nil {
+			return false, s.skip(line, err)
+		}
+
+		e, err := event.DecodeOutboxEvent(&row)
+		if err != nil {
+			return false, s.skip(line, err)
+		}
+
+		if e.OutboxId == "" {
+			events = append(events, e.IncomingEvent)
+		} else {
+			events = append(events, e)
+		}
+		return true, nil
+	})
+	if err != nil {
+		// nothing is taken off the file, so the same lines are tried again next time
+		s.offset = offset
+		return nil, err
+	}
+
+	s.count -= read
+	if s.count == 0 {
+		// everything has been read back in, start again with an empty file rather than letting it grow forever
+		s.offset = 0
+		if err = os.Truncate(s.path, 0); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if err = os.WriteFile(s.offsetPath(), []byte(strconv.FormatInt(s.offset, 10)), 0644); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// skip moves a line that couldn't be decoded to the .bad file
+func (s *spillFile) skip(line []byte, decodeErr error) error {
+	f, err := os.OpenFile(s.badPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	log.Printf("skipped a spilled event that couldn't be read back in, moved it to %s: %s", s.badPath(), decodeErr)
+	return f.Close()
+}
+
+// scan calls fn with up to max lines from the offset, or every line when max is negative, moving the offset past
+// each line once fn has accepted it. Only the lines fn counts are included in max
+func (s *spillFile) scan(max int, fn func(line []byte) (bool, error)) error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = f.Seek(s.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	offset := s.offset
+	reader := bufio.NewReader(f)
+	for n := 0; max < 0 || n < max; {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// a partly written last line is left alone
+			break
+		} else if err != nil {
+			return err
+		}
+
+		next := offset + int64(len(line))
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			counted, err := fn(line)
+			if err != nil {
+				return err
+			}
+			if counted {
+				n++
+			}
+		}
+		offset = next
+		if max >= 0 {
+			s.offset = offset
+		}
+	}
+	return nil
+}
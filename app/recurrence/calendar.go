@@ -0,0 +1,138 @@
+package recurrence
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxDeferrals how many times Earliest moves a time on before giving up, so constraints that rule out every time of
+// day don't loop forever
+const maxDeferrals = 1000
+
+// Constraint limits when attempts can be scheduled. Next returns t when it's allowed, otherwise a later time that
+// might be, in t's location
+type Constraint interface {
+	Next(t time.Time) time.Time
+}
+
+// Earliest the first time at or after t that every constraint allows. Constraints can move a time into one another,
+// e.g. out of a blackout and into quiet hours, so they're applied until none of them move it any further. false when
+// there isn't such a time
+func Earliest(t time.Time, constraints ...Constraint) (time.Time, bool) {
+	for i := 0; i < maxDeferrals; i++ {
+		moved := false
+		for _, constraint := range constraints {
+			if next := constraint.Next(t); !next.Equal(t) {
+				t, moved = next, true
+			}
+		}
+		if !moved {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Blackout a run of local dates, inclusive of both, that attempts can't be scheduled on, e.g. a public holiday
+type Blackout struct {
+	// From and To are YYYY-MM-DD dates, which sort the same as the dates themselves
+	From string
+	To   string
+}
+
+func NewBlackout(from, to string) (*Blackout, error) {
+	for _, date := range []string{from, to} {
+		if _, err := time.Parse(dateOnlyFormat, date); err != nil {
+			return nil, fmt.Errorf("invalid blackout date %q, expected YYYY-MM-DD", date)
+		}
+	}
+	if to < from {
+		return nil, fmt.Errorf("blackout can't end (%s) before it starts (%s)", to, from)
+	}
+	return &Blackout{From: from, To: to}, nil
+}
+
+// Next midnight after the blackout's last day, when t is on one of its days
+func (b Blackout) Next(t time.Time) time.Time {
+	if date := t.Format(dateOnlyFormat); date < b.From || date > b.To {
+		return t
+	}
+
+	last, _ := time.Parse(dateOnlyFormat, b.To)
+	return time.Date(last.Year(), last.Month(), last.Day()+1, 0, 0, 0, 0, t.Location())
+}
+
+// QuietHours a recurring time of day that attempts can't be scheduled within, e.g. 22:00-07:00. Days limits them to
+// the days they start on, so 22:00-07:00 on Saturday runs into Sunday morning. They're every day when Days is empty
+type QuietHours struct {
+	Hours Window
+	Days  []time.Weekday
+}
+
+// NewQuietHours days is a comma separated list of RRULE style days, e.g. SA,SU, or empty for every day
+func NewQuietHours(start, end, days string) (*QuietHours, error) {
+	window, err := NewWindow(start, end)
+	if err != nil {
+		return nil, fmt.Errorf("invalid quiet hours: %v", err)
+	}
+
+	q := &QuietHours{Hours: *window}
+	if strings.TrimSpace(days) != "" {
+		if q.Days, err = parseWeekdays(days); err != nil {
+			return nil, fmt.Errorf("invalid quiet hours: %v", err)
+		}
+	}
+	return q, nil
+}
+
+// Next when the quiet hours end, when t is within them
+func (q QuietHours) Next(t time.Time) time.Time {
+	now := t.Hour()*3600 + t.Minute()*60 + t.Second()
+	start, end := q.Hours.Start.seconds(), q.Hours.End.seconds()
+
+	// the day the quiet hours t might be in started on, relative to t's
+	var startedOn int
+	switch {
+	case start < end && now >= start && now < end, start > end && now >= start:
+		startedOn = 0
+	case start > end && now < end:
+		startedOn = -1
+	default:
+		return t
+	}
+
+	started := time.Date(t.Year(), t.Month(), t.Day()+startedOn, 0, 0, 0, 0, t.Location())
+	if len(q.Days) > 0 && !hasWeekday(q.Days, started.Weekday()) {
+		return t
+	}
+
+	endsOn := startedOn
+	if start > end {
+		endsOn++
+	}
+	ends := time.Date(t.Year(), t.Month(), t.Day()+endsOn, int(q.Hours.End)/60, int(q.Hours.End)%60, 0, 0, t.Location())
+	if !ends.After(t) {
+		// the end fell into a daylight saving gap and was normalised back before t
+		return t
+	}
+	return ends
+}
+
+// Calendar a study's blackouts and quiet hours
+type Calendar struct {
+	Blackouts  []Blackout
+	QuietHours []QuietHours
+}
+
+// Constraints every blackout and quiet hours, for Earliest
+func (c *Calendar) Constraints() []Constraint {
+	constraints := make([]Constraint, 0, len(c.Blackouts)+len(c.QuietHours))
+	for _, blackout := range c.Blackouts {
+		constraints = append(constraints, blackout)
+	}
+	for _, quiet := range c.QuietHours {
+		constraints = append(constraints, quiet)
+	}
+	return constraints
+}
@@ -0,0 +1,121 @@
+package recurrence
+
+import (
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type CalendarTestSuite struct {
+	suite.Suite
+	London *time.Location
+}
+
+func (suite *CalendarTestSuite) SetupTest() {
+	london, err := time.LoadLocation("Europe/London")
+	suite.Require().NoError(err)
+	suite.London = london
+}
+
+func (suite *CalendarTestSuite) Test_Blackout() {
+	christmas, err := NewBlackout("2022-12-25", "2022-12-26")
+	suite.Require().NoError(err)
+
+	suite.Run("outside of the blackout", func() {
+		t := time.Date(2022, 12, 24, 23, 59, 0, 0, suite.London)
+		suite.Equal(t, christmas.Next(t))
+	})
+
+	suite.Run("moved on to the day after", func() {
+		suite.Equal(time.Date(2022, 12, 27, 0, 0, 0, 0, suite.London), christmas.Next(time.Date(2022, 12, 25, 9, 0, 0, 0, suite.London)))
+		suite.Equal(time.Date(2022, 12, 27, 0, 0, 0, 0, suite.London), christmas.Next(time.Date(2022, 12, 26, 23, 0, 0, 0, suite.London)))
+	})
+
+	suite.Run("dates are local to t", func() {
+		// 23:00 on Christmas Eve in UTC is already Christmas Day in Tokyo
+		tokyo, err := time.LoadLocation("Asia/Tokyo")
+		suite.Require().NoError(err)
+		t := time.Date(2022, 12, 24, 23, 0, 0, 0, time.UTC)
+		suite.Equal(t, christmas.Next(t))
+		suite.Equal(time.Date(2022, 12, 27, 0, 0, 0, 0, tokyo), christmas.Next(t.In(tokyo)))
+	})
+
+	suite.Run("invalid blackouts", func() {
+		_, err := NewBlackout("25/12/2022", "2022-12-26")
+		suite.EqualError(err, `invalid blackout date "25/12/2022", expected YYYY-MM-DD`)
+
+		_, err = NewBlackout("2022-12-26", "2022-12-25")
+		suite.EqualError(err, "blackout can't end (2022-12-25) before it starts (2022-12-26)")
+	})
+}
+
+func (suite *CalendarTestSuite) Test_QuietHours() {
+	suite.Run("every day", func() {
+		lunch, err := NewQuietHours("12:00", "13:30", "")
+		suite.Require().NoError(err)
+		suite.Equal(time.Date(2022, 7, 18, 13, 30, 0, 0, time.UTC), lunch.Next(time.Date(2022, 7, 18, 12, 15, 0, 0, time.UTC)))
+
+		t := time.Date(2022, 7, 18, 13, 30, 0, 0, time.UTC)
+		suite.Equal(t, lunch.Next(t))
+	})
+
+	suite.Run("overnight at the weekend", func() {
+		weekends, err := NewQuietHours("22:00", "10:00", "FR,SA")
+		suite.Require().NoError(err)
+
+		// Friday night into Saturday morning
+		suite.Equal(time.Date(2022, 7, 23, 10, 0, 0, 0, time.UTC), weekends.Next(time.Date(2022, 7, 22, 23, 0, 0, 0, time.UTC)))
+		suite.Equal(time.Date(2022, 7, 23, 10, 0, 0, 0, time.UTC), weekends.Next(time.Date(2022, 7, 23, 6, 0, 0, 0, time.UTC)))
+		// Sunday night isn't quiet, and neither is Friday morning
+		t := time.Date(2022, 7, 24, 23, 0, 0, 0, time.UTC)
+		suite.Equal(t, weekends.Next(t))
+		t = time.Date(2022, 7, 22, 6, 0, 0, 0, time.UTC)
+		suite.Equal(t, weekends.Next(t))
+	})
+
+	suite.Run("the clocks going forward", func() {
+		overnight, err := NewQuietHours("22:00", "07:00", "")
+		suite.Require().NoError(err)
+		next := overnight.Next(time.Date(2022, 3, 26, 23, 0, 0, 0, suite.London))
+		suite.Equal(time.Date(2022, 3, 27, 7, 0, 0, 0, suite.London), next)
+		suite.Equal(7*time.Hour, next.Sub(time.Date(2022, 3, 26, 23, 0, 0, 0, suite.London)))
+	})
+
+	suite.Run("invalid quiet hours", func() {
+		_, err := NewQuietHours("22:00", "07:00", "WEEKENDS")
+		suite.EqualError(err, `invalid quiet hours: unsupported day "WEEKENDS"`)
+	})
+}
+
+func (suite *CalendarTestSuite) Test_Earliest() {
+	window := Window{Start: 8 * 60, End: 21 * 60}
+	calendar := &Calendar{
+		Blackouts:  []Blackout{{From: "2022-12-25", To: "2022-12-26"}},
+		QuietHours: []QuietHours{{Hours: Window{Start: 8 * 60, End: 10 * 60}, Days: []time.Weekday{time.Tuesday}}},
+	}
+	constraints := append(calendar.Constraints(), window)
+
+	suite.Run("allowed times aren't moved", func() {
+		t := time.Date(2022, 12, 20, 12, 0, 0, 0, suite.London)
+		next, ok := Earliest(t, constraints...)
+		suite.True(ok)
+		suite.Equal(t, next)
+	})
+
+	suite.Run("out of the blackout, into the window and then out of the quiet hours", func() {
+		// midnight after Boxing Day is outside of the window, which opens at 08:00 on Tuesday, during quiet hours
+		next, ok := Earliest(time.Date(2022, 12, 25, 9, 0, 0, 0, suite.London), constraints...)
+		suite.True(ok)
+		suite.Equal(time.Date(2022, 12, 27, 10, 0, 0, 0, suite.London), next)
+	})
+
+	suite.Run("when nothing is ever allowed", func() {
+		always := QuietHours{Hours: Window{Start: 7 * 60, End: 22 * 60}}
+		_, ok := Earliest(time.Date(2022, 12, 20, 12, 0, 0, 0, suite.London), window, always)
+		suite.False(ok)
+	})
+}
+
+func TestCalendarTestSuite(t *testing.T) {
+	suite.Run(t, new(CalendarTestSuite))
+}
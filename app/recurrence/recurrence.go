@@ -0,0 +1,254 @@
+package recurrence
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dateTimeFormat    = "20060102T150405"
+	dateTimeUTCFormat = "20060102T150405Z"
+	dateFormat        = "20060102"
+	// dateOnlyFormat how blackout dates are written
+	dateOnlyFormat = "2006-01-02"
+)
+
+var weekdayCodes = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// Recurrence a set of rules, an occurrence of any one of them is an occurrence of the set. Sequential schedules are
+// written as overlapping rules, e.g. daily for 14 days then weekly is
+//
+//	RRULE:FREQ=DAILY;COUNT=14
+//	RRULE:FREQ=WEEKLY
+type Recurrence struct {
+	// Start DTSTART, when it isn't set the series starts from the anchor given to Next
+	Start time.Time
+	Rules []Rule
+}
+
+// Parse reads a recurrence written as RFC 5545 content lines, an optional DTSTART followed by one or more RRULE. A
+// single rule can also be given on its own, without the RRULE: prefix
+func Parse(s string) (*Recurrence, error) {
+	r := &Recurrence{}
+	for _, line := range strings.FieldsFunc(s, func(c rune) bool { return c == '\n' || c == '\r' }) {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			name, value = "RRULE", line
+		}
+		name, params, _ := strings.Cut(name, ";")
+		name = strings.ToUpper(name)
+
+		switch name {
+		case "DTSTART":
+			start, err := parseDTStart(params, value)
+			if err != nil {
+				return nil, err
+			}
+			r.Start = start
+		case "RRULE":
+			rule, err := ParseRule(value)
+			if err != nil {
+				return nil, err
+			}
+			r.Rules = append(r.Rules, rule)
+		default:
+			return nil, fmt.Errorf("unsupported recurrence property %s", name)
+		}
+	}
+
+	if len(r.Rules) == 0 {
+		return nil, fmt.Errorf("recurrence needs at least one RRULE")
+	}
+	return r, nil
+}
+
+// Next the first occurrence of any rule strictly after after. anchor is when the series starts if there's no DTSTART.
+// false when every rule has ended
+func (r *Recurrence) Next(anchor, after time.Time) (next time.Time, ok bool) {
+	start := anchor
+	if !r.Start.IsZero() {
+		start = r.Start
+	}
+
+	for _, rule := range r.Rules {
+		if occurrence, found := rule.Next(start, after); found && (!ok || occurrence.Before(next)) {
+			next, ok = occurrence, true
+		}
+	}
+	return
+}
+
+// String the recurrence as content lines, which Parse reads back in
+func (r *Recurrence) String() string {
+	var lines []string
+	if !r.Start.IsZero() {
+		if r.Start.Location() == time.UTC {
+			lines = append(lines, "DTSTART:"+r.Start.Format(dateTimeUTCFormat))
+		} else {
+			lines = append(lines, "DTSTART;TZID="+r.Start.Location().String()+":"+r.Start.Format(dateTimeFormat))
+		}
+	}
+	for _, rule := range r.Rules {
+		lines = append(lines, "RRULE:"+rule.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ParseRule reads the value of a single RRULE, e.g. FREQ=WEEKLY;BYDAY=MO;BYHOUR=9;BYMINUTE=0
+func ParseRule(value string) (Rule, error) {
+	var rule Rule
+	for _, part := range strings.Split(value, ";") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+
+		key, v, found := strings.Cut(part, "=")
+		if !found {
+			return Rule{}, fmt.Errorf("invalid recurrence rule part %q", part)
+		}
+
+		var err error
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			rule.Freq = Frequency(strings.ToUpper(v))
+		case "INTERVAL":
+			rule.Interval, err = strconv.Atoi(v)
+		case "COUNT":
+			rule.Count, err = strconv.Atoi(v)
+		case "UNTIL":
+			rule.Until, err = parseTime(v, time.UTC)
+		case "BYDAY":
+			rule.ByDay, err = parseWeekdays(v)
+		case "BYMONTHDAY":
+			rule.ByMonthDay, err = parseInts(v)
+		case "BYHOUR":
+			rule.ByHour, err = parseInts(v)
+		case "BYMINUTE":
+			rule.ByMinute, err = parseInts(v)
+		case "WKST":
+			if strings.ToUpper(v) != "MO" {
+				err = fmt.Errorf("weeks can only start on a Monday")
+			}
+		default:
+			return Rule{}, fmt.Errorf("unsupported recurrence rule part %s", strings.ToUpper(key))
+		}
+		if err != nil {
+			return Rule{}, fmt.Errorf("invalid recurrence rule part %q: %v", part, err)
+		}
+	}
+
+	if err := rule.Validate(); err != nil {
+		return Rule{}, err
+	}
+	return rule, nil
+}
+
+// String the RRULE value, without the RRULE: prefix
+func (r Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(dateTimeUTCFormat))
+	}
+	if len(r.ByDay) > 0 {
+		codes := make([]string, 0, len(r.ByDay))
+		for _, weekday := range r.ByDay {
+			codes = append(codes, strings.ToUpper(weekday.String()[:2]))
+		}
+		parts = append(parts, "BYDAY="+strings.Join(codes, ","))
+	}
+	for _, by := range []struct {
+		name   string
+		values []int
+	}{{"BYMONTHDAY", r.ByMonthDay}, {"BYHOUR", r.ByHour}, {"BYMINUTE", r.ByMinute}} {
+		if len(by.values) > 0 {
+			values := make([]string, 0, len(by.values))
+			for _, value := range by.values {
+				values = append(values, strconv.Itoa(value))
+			}
+			parts = append(parts, by.name+"="+strings.Join(values, ","))
+		}
+	}
+	return strings.Join(parts, ";")
+}
+
+// parseDTStart the only parameter supported is TZID, without one the start is in UTC
+func parseDTStart(params, value string) (time.Time, error) {
+	loc := time.UTC
+	if params != "" {
+		key, tzid, _ := strings.Cut(params, "=")
+		if strings.ToUpper(key) != "TZID" {
+			return time.Time{}, fmt.Errorf("unsupported DTSTART parameter %s", key)
+		}
+		var err error
+		if loc, err = time.LoadLocation(tzid); err != nil {
+			return time.Time{}, fmt.Errorf("invalid DTSTART time zone %q: %v", tzid, err)
+		}
+	}
+
+	start, err := parseTime(value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid DTSTART %q: %v", value, err)
+	}
+	return start, nil
+}
+
+// parseTime a date, or a date and time which is in UTC when it ends with a Z, or loc otherwise
+func parseTime(value string, loc *time.Location) (time.Time, error) {
+	switch {
+	case strings.HasSuffix(value, "Z"):
+		return time.Parse(dateTimeUTCFormat, value)
+	case len(value) == len(dateFormat):
+		return time.ParseInLocation(dateFormat, value, loc)
+	default:
+		return time.ParseInLocation(dateTimeFormat, value, loc)
+	}
+}
+
+// parseWeekdays returned in the order they fall within the week
+func parseWeekdays(value string) ([]time.Weekday, error) {
+	var weekdays []time.Weekday
+	for _, code := range strings.Split(value, ",") {
+		weekday, ok := weekdayCodes[strings.ToUpper(strings.TrimSpace(code))]
+		if !ok {
+			return nil, fmt.Errorf("unsupported day %q", code)
+		}
+		weekdays = append(weekdays, weekday)
+	}
+	sort.Slice(weekdays, func(i, j int) bool {
+		return daysSinceMonday(weekdays[i]) < daysSinceMonday(weekdays[j])
+	})
+	return weekdays, nil
+}
+
+func parseInts(value string) ([]int, error) {
+	var values []int
+	for _, v := range strings.Split(value, ",") {
+		i, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, i)
+	}
+	return values, nil
+}
@@ -0,0 +1,109 @@
+package recurrence
+
+import (
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type RecurrenceTestSuite struct {
+	suite.Suite
+	Anchor time.Time
+}
+
+func (suite *RecurrenceTestSuite) SetupTest() {
+	suite.Anchor = time.Date(2022, 7, 18, 9, 0, 0, 0, time.UTC)
+}
+
+func (suite *RecurrenceTestSuite) Test_Parse() {
+	suite.Run("a single rule without the RRULE prefix", func() {
+		r, err := Parse("FREQ=WEEKLY;BYDAY=MO;BYHOUR=9;BYMINUTE=0")
+		suite.Require().NoError(err)
+		suite.Equal(&Recurrence{Rules: []Rule{
+			{Freq: Weekly, ByDay: []time.Weekday{time.Monday}, ByHour: []int{9}, ByMinute: []int{0}},
+		}}, r)
+	})
+
+	suite.Run("a start with a time zone, and several rules", func() {
+		r, err := Parse("DTSTART;TZID=Europe/London:20220718T090000\r\nRRULE:FREQ=DAILY;COUNT=14\nRRULE:FREQ=WEEKLY;UNTIL=20221231T000000Z")
+		suite.Require().NoError(err)
+
+		london, err := time.LoadLocation("Europe/London")
+		suite.Require().NoError(err)
+		suite.Equal(time.Date(2022, 7, 18, 9, 0, 0, 0, london), r.Start)
+		suite.Equal([]Rule{
+			{Freq: Daily, Count: 14},
+			{Freq: Weekly, Until: time.Date(2022, 12, 31, 0, 0, 0, 0, time.UTC)},
+		}, r.Rules)
+	})
+
+	suite.Run("String is read back in the same", func() {
+		for _, s := range []string{
+			"RRULE:FREQ=DAILY;BYHOUR=9,13,18;BYMINUTE=0",
+			"DTSTART:20220718T090000Z\nRRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,TH",
+			"DTSTART;TZID=Europe/London:20220718T090000\nRRULE:FREQ=MONTHLY;COUNT=6;BYMONTHDAY=1,15",
+		} {
+			r, err := Parse(s)
+			suite.Require().NoError(err)
+			suite.Equal(s, r.String())
+		}
+	})
+
+	suite.Run("invalid recurrences", func() {
+		for s, want := range map[string]string{
+			"":                                   "recurrence needs at least one RRULE",
+			"EXDATE:20220718T090000Z":            "unsupported recurrence property EXDATE",
+			"FREQ=DAILY;BYSETPOS=1":              "unsupported recurrence rule part BYSETPOS",
+			"FREQ=DAILY;COUNT=two":               `invalid recurrence rule part "COUNT=two": strconv.Atoi: parsing "two": invalid syntax`,
+			"FREQ=WEEKLY;BYDAY=1MO":              `invalid recurrence rule part "BYDAY=1MO": unsupported day "1MO"`,
+			"FREQ=WEEKLY;WKST=SU":                `invalid recurrence rule part "WKST=SU": weeks can only start on a Monday`,
+			"FREQ=HOURLY":                        `unsupported recurrence frequency "HOURLY"`,
+			"DTSTART;TZID=Mars/Olympus:20220718": `invalid DTSTART time zone "Mars/Olympus": unknown time zone Mars/Olympus`,
+		} {
+			_, err := Parse(s)
+			suite.EqualError(err, want, s)
+		}
+	})
+}
+
+func (suite *RecurrenceTestSuite) Test_Next() {
+	suite.Run("daily for 14 days then weekly", func() {
+		r, err := Parse("RRULE:FREQ=DAILY;COUNT=14\nRRULE:FREQ=WEEKLY")
+		suite.Require().NoError(err)
+
+		var occurrences []time.Time
+		after := suite.Anchor.Add(-time.Second)
+		for i := 0; i < 16; i++ {
+			next, ok := r.Next(suite.Anchor, after)
+			suite.Require().True(ok)
+			occurrences = append(occurrences, next)
+			after = next
+		}
+
+		suite.Equal(suite.Anchor, occurrences[0])
+		suite.Equal(suite.Anchor.AddDate(0, 0, 13), occurrences[13])
+		suite.Equal(suite.Anchor.AddDate(0, 0, 14), occurrences[14])
+		suite.Equal(suite.Anchor.AddDate(0, 0, 21), occurrences[15])
+	})
+
+	suite.Run("DTSTART takes precedence over the anchor", func() {
+		r, err := Parse("DTSTART:20220801T090000Z\nRRULE:FREQ=DAILY")
+		suite.Require().NoError(err)
+
+		next, ok := r.Next(suite.Anchor, suite.Anchor)
+		suite.True(ok)
+		suite.Equal(time.Date(2022, 8, 1, 9, 0, 0, 0, time.UTC), next)
+	})
+
+	suite.Run("once every rule has ended", func() {
+		r, err := Parse("RRULE:FREQ=DAILY;COUNT=2\nRRULE:FREQ=WEEKLY;COUNT=1")
+		suite.Require().NoError(err)
+
+		_, ok := r.Next(suite.Anchor, suite.Anchor.AddDate(0, 0, 1))
+		suite.False(ok)
+	})
+}
+
+func TestRecurrenceTestSuite(t *testing.T) {
+	suite.Run(t, new(RecurrenceTestSuite))
+}
@@ -0,0 +1,215 @@
+package recurrence
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+)
+
+// maxPeriods how many days, weeks or months Next looks through before giving up, so a rule that can't match anything
+// doesn't loop forever
+const maxPeriods = 1000
+
+// Rule a single RRULE (RFC 5545). Only the parts that make sense for questionnaire schedules are supported, weeks
+// always start on a Monday
+type Rule struct {
+	Freq Frequency
+	// Interval every how many days, weeks or months, 0 is the same as 1
+	Interval int
+	// Count and Until end the series, at most one of them can be set
+	Count int
+	Until time.Time
+	// ByDay limits a daily rule to the given days, or expands a weekly or monthly one onto them
+	ByDay      []time.Weekday
+	ByMonthDay []int
+	// ByHour and ByMinute default to the time of day the series starts at
+	ByHour   []int
+	ByMinute []int
+}
+
+func (r Rule) Validate() error {
+	switch r.Freq {
+	case Daily, Weekly, Monthly:
+	default:
+		return fmt.Errorf("unsupported recurrence frequency %q", r.Freq)
+	}
+
+	switch {
+	case r.Interval < 0:
+		return fmt.Errorf("recurrence interval can't be negative, got %d", r.Interval)
+	case r.Count < 0:
+		return fmt.Errorf("recurrence count can't be negative, got %d", r.Count)
+	case r.Count > 0 && !r.Until.IsZero():
+		return fmt.Errorf("recurrence can't have both a COUNT and an UNTIL")
+	case len(r.ByMonthDay) > 0 && r.Freq != Monthly:
+		return fmt.Errorf("BYMONTHDAY is only supported with FREQ=%s", Monthly)
+	}
+
+	for _, day := range r.ByMonthDay {
+		if day < 1 || day > 31 {
+			return fmt.Errorf("BYMONTHDAY must be between 1 and 31, got %d", day)
+		}
+	}
+	for _, hour := range r.ByHour {
+		if hour < 0 || hour > 23 {
+			return fmt.Errorf("BYHOUR must be between 0 and 23, got %d", hour)
+		}
+	}
+	for _, minute := range r.ByMinute {
+		if minute < 0 || minute > 59 {
+			return fmt.Errorf("BYMINUTE must be between 0 and 59, got %d", minute)
+		}
+	}
+	return nil
+}
+
+// Next the first occurrence strictly after after, for a series starting at start. Occurrences are worked out in
+// start's location, so a 09:00 rule stays at 09:00 local time across daylight saving changes. false when the series
+// has ended
+func (r Rule) Next(start, after time.Time) (time.Time, bool) {
+	interval := r.Interval
+	if interval < 1 {
+		interval = 1
+	}
+
+	// without a COUNT, every occurrence doesn't need counting, so the periods before after can be skipped
+	first := 0
+	if r.Count == 0 && after.After(start) {
+		if first = r.periodsBetween(start, after)/interval - 1; first < 0 {
+			first = 0
+		}
+	}
+
+	n := 0
+	for period := first; period < first+maxPeriods; period++ {
+		for _, occurrence := range r.occurrences(start, period*interval) {
+			if occurrence.Before(start) {
+				continue
+			}
+			if !r.Until.IsZero() && occurrence.After(r.Until) {
+				return time.Time{}, false
+			}
+			if n++; r.Count > 0 && n > r.Count {
+				return time.Time{}, false
+			}
+			if occurrence.After(after) {
+				return occurrence, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// occurrences every occurrence within the period offset days, weeks or months on from the one start is in, in order
+func (r Rule) occurrences(start time.Time, offset int) (occurrences []time.Time) {
+	year, month, day := start.Date()
+	loc := start.Location()
+
+	var days []time.Time
+	switch r.Freq {
+	case Daily:
+		d := time.Date(year, month, day+offset, 0, 0, 0, 0, loc)
+		if len(r.ByDay) == 0 || hasWeekday(r.ByDay, d.Weekday()) {
+			days = append(days, d)
+		}
+
+	case Weekly:
+		monday := time.Date(year, month, day-daysSinceMonday(start.Weekday())+offset*7, 0, 0, 0, 0, loc)
+		weekdays := r.ByDay
+		if len(weekdays) == 0 {
+			weekdays = []time.Weekday{start.Weekday()}
+		}
+		for _, weekday := range weekdays {
+			days = append(days, monday.AddDate(0, 0, daysSinceMonday(weekday)))
+		}
+
+	case Monthly:
+		firstOfMonth := time.Date(year, month+time.Month(offset), 1, 0, 0, 0, 0, loc)
+		inMonth := firstOfMonth.AddDate(0, 1, -1).Day()
+		monthDays := r.ByMonthDay
+		if len(monthDays) == 0 && len(r.ByDay) == 0 {
+			monthDays = []int{day}
+		}
+		for d := 1; d <= inMonth; d++ {
+			date := firstOfMonth.AddDate(0, 0, d-1)
+			// days that don't exist in the month, e.g. the 31st of April, are skipped as RFC 5545 says
+			if (len(monthDays) == 0 || hasInt(monthDays, d)) && (len(r.ByDay) == 0 || hasWeekday(r.ByDay, date.Weekday())) {
+				days = append(days, date)
+			}
+		}
+	}
+
+	hours := sorted(r.ByHour, start.Hour())
+	minutes := sorted(r.ByMinute, start.Minute())
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].Before(days[j])
+	})
+	for _, d := range days {
+		for _, hour := range hours {
+			for _, minute := range minutes {
+				occurrences = append(occurrences, time.Date(d.Year(), d.Month(), d.Day(), hour, minute, start.Second(), 0, loc))
+			}
+		}
+	}
+	return
+}
+
+// periodsBetween how many whole days, weeks or months after is on from start
+func (r Rule) periodsBetween(start, after time.Time) int {
+	after = after.In(start.Location())
+	switch r.Freq {
+	case Weekly:
+		return daysBetween(start.AddDate(0, 0, -daysSinceMonday(start.Weekday())), after) / 7
+	case Monthly:
+		return (after.Year()-start.Year())*12 + int(after.Month()) - int(start.Month())
+	default:
+		return daysBetween(start, after)
+	}
+}
+
+// daysBetween calendar days, so daylight saving changes don't throw it out
+func daysBetween(from, to time.Time) int {
+	f := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	t := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(t.Sub(f).Hours() / 24)
+}
+
+func daysSinceMonday(weekday time.Weekday) int {
+	return (int(weekday) + 6) % 7
+}
+
+func hasWeekday(weekdays []time.Weekday, weekday time.Weekday) bool {
+	for _, w := range weekdays {
+		if w == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+func hasInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// sorted a sorted copy of values, or just def when there aren't any
+func sorted(values []int, def int) []int {
+	if len(values) == 0 {
+		return []int{def}
+	}
+	out := append([]int(nil), values...)
+	sort.Ints(out)
+	return out
+}
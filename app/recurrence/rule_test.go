@@ -0,0 +1,136 @@
+package recurrence
+
+import (
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type RuleTestSuite struct {
+	suite.Suite
+	// Start Monday 18th July 2022, 10:00 UTC
+	Start time.Time
+}
+
+func (suite *RuleTestSuite) SetupTest() {
+	suite.Start = time.Date(2022, 7, 18, 10, 0, 0, 0, time.UTC)
+}
+
+// occurrences the first n occurrences of rule, by calling Next from each one in turn
+func (suite *RuleTestSuite) occurrences(rule Rule, start time.Time, n int) (occurrences []time.Time) {
+	after := start.Add(-time.Second)
+	for i := 0; i < n; i++ {
+		next, ok := rule.Next(start, after)
+		if !ok {
+			break
+		}
+		occurrences = append(occurrences, next)
+		after = next
+	}
+	return
+}
+
+func (suite *RuleTestSuite) Test_Next() {
+	suite.Run("every Monday at 09:00", func() {
+		rule := Rule{Freq: Weekly, ByDay: []time.Weekday{time.Monday}, ByHour: []int{9}, ByMinute: []int{0}}
+		suite.Equal([]time.Time{
+			time.Date(2022, 7, 25, 9, 0, 0, 0, time.UTC),
+			time.Date(2022, 8, 1, 9, 0, 0, 0, time.UTC),
+			time.Date(2022, 8, 8, 9, 0, 0, 0, time.UTC),
+		}, suite.occurrences(rule, suite.Start, 3))
+	})
+
+	suite.Run("3 times a day at fixed times", func() {
+		rule := Rule{Freq: Daily, ByHour: []int{18, 9, 13}, ByMinute: []int{30}}
+		suite.Equal([]time.Time{
+			time.Date(2022, 7, 18, 13, 30, 0, 0, time.UTC),
+			time.Date(2022, 7, 18, 18, 30, 0, 0, time.UTC),
+			time.Date(2022, 7, 19, 9, 30, 0, 0, time.UTC),
+			time.Date(2022, 7, 19, 13, 30, 0, 0, time.UTC),
+		}, suite.occurrences(rule, suite.Start, 4))
+	})
+
+	suite.Run("weekdays only", func() {
+		rule := Rule{Freq: Daily, ByDay: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}}
+		next, ok := rule.Next(suite.Start, time.Date(2022, 7, 22, 10, 0, 0, 0, time.UTC))
+		suite.True(ok)
+		suite.Equal(time.Date(2022, 7, 25, 10, 0, 0, 0, time.UTC), next)
+	})
+
+	suite.Run("every other week on Tuesday and Thursday", func() {
+		rule := Rule{Freq: Weekly, Interval: 2, ByDay: []time.Weekday{time.Thursday, time.Tuesday}}
+		suite.Equal([]time.Time{
+			time.Date(2022, 7, 19, 10, 0, 0, 0, time.UTC),
+			time.Date(2022, 7, 21, 10, 0, 0, 0, time.UTC),
+			time.Date(2022, 8, 2, 10, 0, 0, 0, time.UTC),
+			time.Date(2022, 8, 4, 10, 0, 0, 0, time.UTC),
+		}, suite.occurrences(rule, suite.Start, 4))
+	})
+
+	suite.Run("monthly skips days that don't exist", func() {
+		start := time.Date(2022, 1, 31, 9, 0, 0, 0, time.UTC)
+		suite.Equal([]time.Time{
+			start,
+			time.Date(2022, 3, 31, 9, 0, 0, 0, time.UTC),
+			time.Date(2022, 5, 31, 9, 0, 0, 0, time.UTC),
+		}, suite.occurrences(Rule{Freq: Monthly}, start, 3))
+	})
+
+	suite.Run("monthly on every Friday", func() {
+		rule := Rule{Freq: Monthly, ByDay: []time.Weekday{time.Friday}}
+		next, ok := rule.Next(suite.Start, time.Date(2022, 7, 29, 10, 0, 0, 0, time.UTC))
+		suite.True(ok)
+		suite.Equal(time.Date(2022, 8, 5, 10, 0, 0, 0, time.UTC), next)
+	})
+
+	suite.Run("count ends the series, counting from the start", func() {
+		rule := Rule{Freq: Daily, Count: 3}
+		suite.Len(suite.occurrences(rule, suite.Start, 10), 3)
+
+		_, ok := rule.Next(suite.Start, suite.Start.AddDate(0, 0, 2))
+		suite.False(ok)
+	})
+
+	suite.Run("until is inclusive", func() {
+		rule := Rule{Freq: Daily, Until: suite.Start.AddDate(0, 0, 2)}
+		suite.Len(suite.occurrences(rule, suite.Start, 10), 3)
+	})
+
+	suite.Run("a long way after the start", func() {
+		rule := Rule{Freq: Weekly, Interval: 3}
+		next, ok := rule.Next(suite.Start, suite.Start.AddDate(30, 0, 0))
+		suite.True(ok)
+		suite.Equal(time.Monday, next.Weekday())
+		suite.Equal(0, daysBetween(suite.Start, next)%21)
+		suite.True(next.After(suite.Start.AddDate(30, 0, 0)))
+	})
+
+	suite.Run("the time of day is kept across daylight saving changes", func() {
+		london, err := time.LoadLocation("Europe/London")
+		suite.Require().NoError(err)
+
+		start := time.Date(2022, 3, 26, 9, 0, 0, 0, london)
+		occurrences := suite.occurrences(Rule{Freq: Daily}, start, 2)
+		suite.Equal(time.Date(2022, 3, 27, 9, 0, 0, 0, london), occurrences[1])
+		suite.Equal(23*time.Hour, occurrences[1].Sub(occurrences[0]))
+	})
+}
+
+func (suite *RuleTestSuite) Test_Validate() {
+	for _, tc := range []struct {
+		rule Rule
+		err  string
+	}{
+		{Rule{Freq: "YEARLY"}, `unsupported recurrence frequency "YEARLY"`},
+		{Rule{Freq: Daily, Count: 2, Until: suite.Start}, "recurrence can't have both a COUNT and an UNTIL"},
+		{Rule{Freq: Weekly, ByMonthDay: []int{1}}, "BYMONTHDAY is only supported with FREQ=MONTHLY"},
+		{Rule{Freq: Daily, ByHour: []int{24}}, "BYHOUR must be between 0 and 23, got 24"},
+		{Rule{Freq: Daily, ByMinute: []int{-1}}, "BYMINUTE must be between 0 and 59, got -1"},
+	} {
+		suite.EqualError(tc.rule.Validate(), tc.err)
+	}
+}
+
+func TestRuleTestSuite(t *testing.T) {
+	suite.Run(t, new(RuleTestSuite))
+}
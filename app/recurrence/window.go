@@ -0,0 +1,81 @@
+package recurrence
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeOfDay minutes after midnight, local time
+type TimeOfDay int
+
+// ParseTimeOfDay reads a 24 hour HH:MM time, e.g. 08:00 or 21:30
+func ParseTimeOfDay(s string) (TimeOfDay, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	return TimeOfDay(t.Hour()*60 + t.Minute()), nil
+}
+
+func (t TimeOfDay) String() string {
+	return fmt.Sprintf("%02d:%02d", t/60, t%60)
+}
+
+func (t TimeOfDay) seconds() int {
+	return int(t) * 60
+}
+
+// Window a daily window of local time that attempts can be scheduled within, the start is inclusive and the end isn't.
+// A window that ends before it starts runs over midnight, e.g. 21:00-06:00 for night shift workers
+type Window struct {
+	Start TimeOfDay
+	End   TimeOfDay
+}
+
+func NewWindow(start, end string) (*Window, error) {
+	s, err := ParseTimeOfDay(start)
+	if err != nil {
+		return nil, err
+	}
+	e, err := ParseTimeOfDay(end)
+	if err != nil {
+		return nil, err
+	}
+	if s == e {
+		return nil, fmt.Errorf("window can't start and end at the same time, %s", s)
+	}
+	return &Window{Start: s, End: e}, nil
+}
+
+func (w Window) String() string {
+	return w.Start.String() + "-" + w.End.String()
+}
+
+// Contains whether t is within the window, going by the wall clock in t's location
+func (w Window) Contains(t time.Time) bool {
+	start, end, now := w.Start.seconds(), w.End.seconds(), t.Hour()*3600+t.Minute()*60+t.Second()
+	if start < end {
+		return now >= start && now < end
+	}
+	return now >= start || now < end
+}
+
+// Next t when it's within the window, otherwise when the window next opens, in t's location. The opening time is worked
+// out on the local calendar, so it stays at the same wall clock time across daylight saving changes
+func (w Window) Next(t time.Time) time.Time {
+	if w.Contains(t) {
+		return t
+	}
+
+	// outside of the window, it next opens either later today or tomorrow
+	day := 0
+	if t.Hour()*3600+t.Minute()*60+t.Second() >= w.Start.seconds() {
+		day = 1
+	}
+	opens := time.Date(t.Year(), t.Month(), t.Day()+day, int(w.Start)/60, int(w.Start)%60, 0, 0, t.Location())
+	if opens.Before(t) {
+		// the opening time fell into a daylight saving gap and was normalised back before t
+		return t
+	}
+	return opens
+}
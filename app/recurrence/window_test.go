@@ -0,0 +1,83 @@
+package recurrence
+
+import (
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type WindowTestSuite struct {
+	suite.Suite
+	London *time.Location
+}
+
+func (suite *WindowTestSuite) SetupTest() {
+	london, err := time.LoadLocation("Europe/London")
+	suite.Require().NoError(err)
+	suite.London = london
+}
+
+func (suite *WindowTestSuite) Test_NewWindow() {
+	suite.Run("08:00-21:00", func() {
+		w, err := NewWindow("08:00", "21:00")
+		suite.Require().NoError(err)
+		suite.Equal(&Window{Start: 8 * 60, End: 21 * 60}, w)
+		suite.Equal("08:00-21:00", w.String())
+	})
+
+	suite.Run("invalid windows", func() {
+		_, err := NewWindow("8am", "21:00")
+		suite.EqualError(err, `invalid time of day "8am", expected HH:MM`)
+
+		_, err = NewWindow("08:00", "24:00")
+		suite.EqualError(err, `invalid time of day "24:00", expected HH:MM`)
+
+		_, err = NewWindow("08:00", "08:00")
+		suite.EqualError(err, "window can't start and end at the same time, 08:00")
+	})
+}
+
+func (suite *WindowTestSuite) Test_Next() {
+	w := Window{Start: 8 * 60, End: 21 * 60}
+
+	for name, tc := range map[string]struct {
+		t, want time.Time
+	}{
+		"within the window":         {time.Date(2022, 7, 18, 12, 0, 0, 0, suite.London), time.Date(2022, 7, 18, 12, 0, 0, 0, suite.London)},
+		"the start is inclusive":    {time.Date(2022, 7, 18, 8, 0, 0, 0, suite.London), time.Date(2022, 7, 18, 8, 0, 0, 0, suite.London)},
+		"before it opens":           {time.Date(2022, 7, 18, 6, 30, 0, 0, suite.London), time.Date(2022, 7, 18, 8, 0, 0, 0, suite.London)},
+		"after it closes":           {time.Date(2022, 7, 18, 21, 0, 0, 0, suite.London), time.Date(2022, 7, 19, 8, 0, 0, 0, suite.London)},
+		"going by local time":       {time.Date(2022, 7, 18, 7, 30, 0, 0, time.UTC).In(suite.London), time.Date(2022, 7, 18, 8, 30, 0, 0, suite.London)},
+		"the clocks going forward":  {time.Date(2022, 3, 26, 22, 0, 0, 0, suite.London), time.Date(2022, 3, 27, 8, 0, 0, 0, suite.London)},
+		"the clocks going back":     {time.Date(2022, 10, 29, 22, 0, 0, 0, suite.London), time.Date(2022, 10, 30, 8, 0, 0, 0, suite.London)},
+		"across the end of a month": {time.Date(2022, 7, 31, 23, 0, 0, 0, suite.London), time.Date(2022, 8, 1, 8, 0, 0, 0, suite.London)},
+	} {
+		suite.Run(name, func() {
+			suite.Equal(tc.want, w.Next(tc.t))
+		})
+	}
+
+	suite.Run("the clocks going forward, keeps the wall clock time rather than the number of hours", func() {
+		next := w.Next(time.Date(2022, 3, 26, 22, 0, 0, 0, suite.London))
+		suite.Equal(9*time.Hour, next.Sub(time.Date(2022, 3, 26, 22, 0, 0, 0, suite.London)))
+	})
+
+	suite.Run("a window that runs over midnight", func() {
+		night := Window{Start: 21 * 60, End: 6 * 60}
+		suite.True(night.Contains(time.Date(2022, 7, 18, 23, 0, 0, 0, time.UTC)))
+		suite.True(night.Contains(time.Date(2022, 7, 18, 5, 59, 0, 0, time.UTC)))
+		suite.Equal(time.Date(2022, 7, 18, 21, 0, 0, 0, time.UTC), night.Next(time.Date(2022, 7, 18, 6, 0, 0, 0, time.UTC)))
+	})
+
+	suite.Run("a window opening within the daylight saving gap", func() {
+		early := Window{Start: 1*60 + 30, End: 6 * 60}
+		// 01:30 doesn't exist on the 27th of March, the clocks go from 01:00 to 02:00
+		next := early.Next(time.Date(2022, 3, 26, 22, 0, 0, 0, suite.London))
+		suite.True(early.Contains(next))
+		suite.Equal(27, next.Day())
+	})
+}
+
+func TestWindowTestSuite(t *testing.T) {
+	suite.Run(t, new(WindowTestSuite))
+}
@@ -0,0 +1,11 @@
+package utils
+
+import "github.com/aws/aws-sdk-go/aws/session"
+
+// NewAWSSession Not used AWS SQS before, so I'm going to assume the default config store is fine to use for this demo,
+// as per the docs
+func NewAWSSession() *session.Session {
+	return session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff exponential backoff for the given attempt (starting at 1), with jitter applied
+func (c *RetryConfig) Backoff(attempt int) time.Duration {
+	return c.backoff(attempt, rand.Float64())
+}
+
+// CanRetry whether another attempt is allowed after the given number of failed attempts
+func (c *RetryConfig) CanRetry(attempts int) bool {
+	return attempts < c.MaxAttempts
+}
+
+// backoff random is expected to be in the range [0, 1), with 0.5 applying no jitter at all
+func (c *RetryConfig) backoff(attempt int, random float64) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	delay := float64(c.InitialBackoff) * math.Pow(c.Multiplier, float64(attempt-1))
+	if c.MaxBackoff > 0 && delay > float64(c.MaxBackoff) {
+		delay = float64(c.MaxBackoff)
+	}
+
+	delay += delay * c.Jitter * (2*random - 1)
+	return time.Duration(delay)
+}
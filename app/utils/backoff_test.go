@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type BackoffTestSuite struct {
+	suite.Suite
+	Config *RetryConfig
+}
+
+func (suite *BackoffTestSuite) SetupTest() {
+	suite.Config = &RetryConfig{
+		MaxAttempts:    3,
+		InitialBackoff: 1 * time.Second,
+		MaxBackoff:     10 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.5,
+	}
+}
+
+func (suite *BackoffTestSuite) Test_backoff() {
+	suite.Run("backoff doubles for every attempt", func() {
+		suite.Equal(1*time.Second, suite.Config.backoff(1, 0.5))
+		suite.Equal(2*time.Second, suite.Config.backoff(2, 0.5))
+		suite.Equal(4*time.Second, suite.Config.backoff(3, 0.5))
+	})
+
+	suite.Run("backoff is capped at the max backoff", func() {
+		suite.Equal(10*time.Second, suite.Config.backoff(10, 0.5))
+	})
+
+	suite.Run("jitter moves the backoff either side of the base delay", func() {
+		suite.Equal(1*time.Second, suite.Config.backoff(2, 0))
+		suite.Equal(3*time.Second, suite.Config.backoff(2, 1))
+	})
+}
+
+func (suite *BackoffTestSuite) Test_CanRetry() {
+	suite.True(suite.Config.CanRetry(2))
+	suite.False(suite.Config.CanRetry(3))
+}
+
+func TestBackoff(t *testing.T) {
+	suite.Run(t, new(BackoffTestSuite))
+}
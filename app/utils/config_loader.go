@@ -3,15 +3,147 @@ package utils
 import (
 	"gopkg.in/yaml.v2"
 	"os"
+	"time"
 )
 
 type Config struct {
-	Database *DatabaseConfig `yaml:"database"`
+	Database  *DatabaseConfig  `yaml:"database"`
+	Retry     *RetryConfig     `yaml:"retry"`
+	Publisher *PublisherConfig `yaml:"publisher"`
+	Processor *ProcessorConfig `yaml:"processor"`
+	Queue     *QueueConfig     `yaml:"queue"`
+	Consumer  *ConsumerConfig  `yaml:"consumer"`
 }
 
+// DatabaseConfig Driver is one of mysql, postgres, sqlite3, fake or memory, and also decides which SQL dialect is
+// generated. memory keeps every row in memory, it's meant for tests rather than for running the service with. ClientName
+// is the client library, only sqlx is supported. The pool settings are handed on to database/sql, where zero leaves its
+// default in place. On startup, connecting is retried up to ConnectRetries times, ConnectRetryInterval apart, with each
+// attempt given ConnectTimeout (zero waits as long as the driver does)
 type DatabaseConfig struct {
-	Driver string `yaml:"driver"`
-	Dsn    string `yaml:"dsn"`
+	ClientName           string        `yaml:"client_name"`
+	Driver               string        `yaml:"driver"`
+	Dsn                  string        `yaml:"dsn"`
+	MaxOpenConns         int           `yaml:"max_open_conns"`
+	MaxIdleConns         int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime      time.Duration `yaml:"db_conn_max_life_time"`
+	ConnMaxIdleTime      time.Duration `yaml:"conn_max_idle_time"`
+	ConnectTimeout       time.Duration `yaml:"connect_timeout"`
+	ConnectRetries       int           `yaml:"connect_retries"`
+	ConnectRetryInterval time.Duration `yaml:"connect_retry_interval"`
+}
+
+func NewDefaultDatabaseConfig() *DatabaseConfig {
+	return &DatabaseConfig{
+		ClientName:           "sqlx",
+		MaxOpenConns:         10,
+		MaxIdleConns:         5,
+		ConnMaxLifetime:      5 * time.Minute,
+		ConnectTimeout:       5 * time.Second,
+		ConnectRetries:       5,
+		ConnectRetryInterval: 2 * time.Second,
+	}
+}
+
+// PublisherConfig where processed events are published to. Type is one of sqs, sns, file or memory, and only the
+// matching QueueUrl, TopicArn or Path setting is needed
+type PublisherConfig struct {
+	Type     string `yaml:"type"`
+	QueueUrl string `yaml:"queue_url"`
+	TopicArn string `yaml:"topic_arn"`
+	Path     string `yaml:"path"`
+}
+
+// QueueConfig how many events can wait on the in-memory queue, and what happens to any more that are pushed onto it.
+// OverflowPolicy is one of block, drop_oldest, reject or spill. BlockTimeout is only used by block (zero waits
+// forever) and SpillPath only by spill, which has to have one
+type QueueConfig struct {
+	MaxSize        int           `yaml:"max_size"`
+	OverflowPolicy string        `yaml:"overflow_policy"`
+	BlockTimeout   time.Duration `yaml:"block_timeout"`
+	SpillPath      string        `yaml:"spill_path"`
+}
+
+func NewDefaultQueueConfig() *QueueConfig {
+	return &QueueConfig{
+		MaxSize:        1000,
+		OverflowPolicy: "block",
+		BlockTimeout:   5 * time.Second,
+	}
+}
+
+// ConsumerConfig the SQS queue that the consumer receives QUESTIONNAIRE_COMPLETED messages from. Messages are hidden
+// from other consumers for VisibilityTimeout, which is extended every HeartbeatInterval while they're being handled
+type ConsumerConfig struct {
+	QueueUrl          string        `yaml:"queue_url"`
+	MaxMessages       int64         `yaml:"max_messages"`
+	WaitTime          time.Duration `yaml:"wait_time"`
+	VisibilityTimeout time.Duration `yaml:"visibility_timeout"`
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+}
+
+func NewDefaultConsumerConfig() *ConsumerConfig {
+	return &ConsumerConfig{
+		MaxMessages:       10,
+		WaitTime:          20 * time.Second,
+		VisibilityTimeout: 30 * time.Second,
+		HeartbeatInterval: 10 * time.Second,
+	}
+}
+
+// ProcessorConfig how the event processor batches up events. BatchSize is capped at 10 for SQS and SNS, larger batches
+// are split across several calls. FlushInterval is how long a worker waits for a batch to fill up before sending it,
+// and Workers is how many batches can be sent at once. On shutdown, the processor spends up to DrainTimeout sending
+// whatever is still queued
+type ProcessorConfig struct {
+	BatchSize     int           `yaml:"batch_size"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	Workers       int           `yaml:"workers"`
+	DrainTimeout  time.Duration `yaml:"drain_timeout"`
+}
+
+func NewDefaultProcessorConfig() *ProcessorConfig {
+	return &ProcessorConfig{
+		BatchSize:     10,
+		FlushInterval: 10 * time.Millisecond,
+		Workers:       4,
+		DrainTimeout:  10 * time.Second,
+	}
+}
+
+// GetWorkers defaults to a single worker if the number of workers isn't set
+func (c *ProcessorConfig) GetWorkers() int {
+	if c.Workers < 1 {
+		return 1
+	}
+	return c.Workers
+}
+
+// GetBatchSize defaults to one event at a time if the batch size isn't set
+func (c *ProcessorConfig) GetBatchSize() int {
+	if c.BatchSize < 1 {
+		return 1
+	}
+	return c.BatchSize
+}
+
+// RetryConfig how the event processor backs off between failed sends, before giving up on an event
+type RetryConfig struct {
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	Multiplier     float64       `yaml:"multiplier"`
+	Jitter         float64       `yaml:"jitter"` // fraction of the backoff that is randomised, 0.2 = +/- 20%
+}
+
+func NewDefaultRetryConfig() *RetryConfig {
+	return &RetryConfig{
+		MaxAttempts:    5,
+		InitialBackoff: 1 * time.Second,
+		MaxBackoff:     5 * time.Minute,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
 }
 
 func NewConfig(path string) (config *Config, err error) {
@@ -20,6 +152,14 @@ func NewConfig(path string) (config *Config, err error) {
 		return
 	}
 
+	config = &Config{
+		Database:  NewDefaultDatabaseConfig(),
+		Retry:     NewDefaultRetryConfig(),
+		Publisher: &PublisherConfig{Type: "sqs"},
+		Processor: NewDefaultProcessorConfig(),
+		Queue:     NewDefaultQueueConfig(),
+		Consumer:  NewDefaultConsumerConfig(),
+	}
 	err = yaml.Unmarshal(dat, config)
 	if err != nil {
 		return
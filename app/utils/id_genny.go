@@ -9,6 +9,22 @@ type IdGenny interface {
 type UUIDID struct {
 }
 
-func GenerateId() string {
+func (u *UUIDID) GenerateId() string {
 	return uuid.NewV4().String()
 }
+
+// FakeIdGenny hands out the given ids in order, so generated ids are predictable in tests
+type FakeIdGenny struct {
+	ids []string
+	i   int
+}
+
+func NewFakeIdGenny(ids ...string) *FakeIdGenny {
+	return &FakeIdGenny{ids: ids}
+}
+
+func (f *FakeIdGenny) GenerateId() string {
+	id := f.ids[f.i%len(f.ids)]
+	f.i++
+	return id
+}
@@ -2,13 +2,15 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/jamesineda/reschedular/app/consumer"
 	db2 "github.com/jamesineda/reschedular/app/db"
 	"github.com/jamesineda/reschedular/app/event"
+	"github.com/jamesineda/reschedular/app/publisher"
 	"github.com/jamesineda/reschedular/app/queue"
 	"github.com/jamesineda/reschedular/app/utils"
 	"github.com/spf13/pflag"
@@ -16,14 +18,29 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
 	"syscall"
+	"time"
+	// participants' time zones are looked up by name, so the zone database is built in rather than relying on the host's
+	_ "time/tzdata"
 )
 
 const (
 	ERROR      = "ERROR"
 	ConfigPath = "CONFIG_PATH"
 	SqsQueue   = "SQS_QUEUE"
+
+	// ReplayCommand moves events from failed_events back into the outbox, e.g. `reschedular replay`
+	ReplayCommand = "replay"
+	// ConsumeCommand receives QUESTIONNAIRE_COMPLETED events from SQS instead of Lambda, e.g. `reschedular consume`
+	ConsumeCommand = "consume"
+	// HealthCommand exits non-zero if the database can't be reached, e.g. `reschedular health`
+	HealthCommand = "health"
+	// MigrateCommand manages the database schema, e.g. `reschedular migrate up|down|status|to <version>`
+	MigrateCommand = "migrate"
+
+	healthCheckTimeout = 5 * time.Second
 )
 
 func BindCommandLineArgs() {
@@ -36,15 +53,65 @@ func BindCommandLineArgs() {
 	}
 }
 
-func HandleRequest(ctx context.Context, e event.IncomingEvent) (string, error) {
-	if err := e.HandleEvent(ctx); err != nil {
-		log.Fatalf("received unhandled incoming event: %s", e.FunctionName())
+// HandleRequest the Lambda runtime can't unmarshal into an interface, so the raw event is decoded through the event
+// registry using its Name. Unknown events return an *event.UnknownEventError
+func HandleRequest(ctx context.Context, deps *event.Dependencies, raw json.RawMessage) (string, error) {
+	e, err := event.DefaultRegistry.Decode(raw)
+	if err != nil {
+		log.Printf("failed to decode incoming event: %s", err)
+		return ERROR, err
+	}
+
+	if err = e.HandleEvent(ctx, deps); err != nil && !event.IsHandledOutcome(err) {
+		log.Printf("failed to handle incoming event %s: %s", e.FunctionName(), err)
 		return ERROR, err
 	}
 
 	return fmt.Sprintf("Hello %s!", e.FunctionName()), nil
 }
 
+// RunMigrations runs a migrate subcommand, with up being the default
+func RunMigrations(migrator *db2.Migrator, command, version string) error {
+	var run []db2.Migration
+	var err error
+
+	switch command {
+	case "", "up":
+		run, err = migrator.Up()
+	case "down":
+		run, err = migrator.Down()
+	case "to":
+		v, convErr := strconv.Atoi(version)
+		if convErr != nil {
+			return fmt.Errorf("migrate to needs a version number, got %q", version)
+		}
+		run, err = migrator.To(v)
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			if status.IsApplied() {
+				log.Printf("%s applied at %s", status.Migration, status.AppliedAt.Format(time.RFC3339))
+			} else {
+				log.Printf("%s pending", status.Migration)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down, status or to <version>", command)
+	}
+
+	for _, migration := range run {
+		log.Printf("ran migration %s", migration)
+	}
+	if err == nil && len(run) == 0 {
+		log.Println("nothing to migrate")
+	}
+	return err
+}
+
 func main() {
 	BindCommandLineArgs()
 	queueUrl := viper.GetString(SqsQueue)
@@ -62,42 +129,108 @@ func main() {
 		return
 	}
 
-	// Not used AWS SQS before, so I'm going to assume the default config store is fine to use for this demo, as per the docs
-	sess := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	}))
-	svc := sqs.New(sess)
+	switch pflag.Arg(0) {
+	case HealthCommand:
+		ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+		defer cancel()
+		if err = db.Ping(ctx); err != nil {
+			log.Fatalf("database health check failed: %s", err)
+		}
+		log.Println("database is healthy")
+		return
+
+	case MigrateCommand:
+		migrator, err := db2.NewMigrator(db, &utils.RealTimer{})
+		if err != nil {
+			log.Fatalf("failed to load migrations: %s", err)
+		}
+		if err = RunMigrations(migrator, pflag.Arg(1), pflag.Arg(2)); err != nil {
+			log.Fatalf("failed to migrate database: %s", err)
+		}
+		return
 
-	eventsQueue := queue.NewEventsQueue(100)
+	case ReplayCommand:
+		replayed, err := event.ReplayFailedEvents(db, &utils.UUIDID{}, &utils.RealTimer{})
+		if err != nil {
+			log.Fatalf("failed to replay failed events: %s", err)
+		}
+		log.Printf("replayed %d failed events, they'll be sent the next time the service starts", replayed)
+		return
+	}
+
+	// the SQS_QUEUE flag is still honoured for the default sqs publisher
+	if config.Publisher.QueueUrl == "" {
+		config.Publisher.QueueUrl = queueUrl
+	}
+
+	eventPublisher, err := publisher.NewPublisher(config.Publisher)
+	if err != nil {
+		log.Fatalf("failed to create %s publisher: %s", config.Publisher.Type, err)
+		return
+	}
+
+	eventsQueue, err := queue.NewBoundedEventsQueue(config.Queue)
+	if err != nil {
+		log.Fatalf("failed to create events queue: %s", err)
+		return
+	}
 
 	var wg sync.WaitGroup
-	//lambdaChannel := make(chan bool) // would be used if lambda.Start() blocks
-	sqsQueueChannel := make(chan bool)
-	sigC := make(chan os.Signal)
-
-	// set the database on the context
-	ctx := context.Background()
-	context.WithValue(ctx, "db", db)
-	context.WithValue(ctx, "timer", &utils.RealTimer{})
-	context.WithValue(ctx, "idGenny", &utils.UUIDID{})
-	context.WithValue(ctx, "scs", &svc)
-	context.WithValue(ctx, "scsQueueUrl", &queueUrl)
-	context.WithValue(ctx, "eventsQueue", &eventsQueue)
-	event.StartAsynchronousEventProcessor(ctx, &wg, sqsQueueChannel)
-
-	// I'm not really sure how lambda.Start() behaves, so I'm making the huge assumption that is doesn't block due to
-	// the lack of a Stop() or Close() like function exposed. If it DOES block, then I would move the function call into
-	// a go routine and pass the waitGroup and a channel to the handler, so that I can shut down the process on a OS
-	// interrupt.
-	lambda.Start(HandleRequest)
+	sigC := make(chan os.Signal, 1)
+
+	deps := &event.Dependencies{
+		DB:          db,
+		Timer:       &utils.RealTimer{},
+		IdGenny:     &utils.UUIDID{},
+		Publisher:   eventPublisher,
+		Queue:       eventsQueue,
+		Retry:       config.Retry,
+		Processor:   config.Processor,
+		Idempotency: event.NewDBIdempotencyStore(db),
+	}
+
+	// cancelling the context shuts down the event processor
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err = event.StartAsynchronousEventProcessor(ctx, &wg, deps); err != nil {
+		log.Fatalf("failed to start event processor: %s", err)
+		return
+	}
+
+	switch pflag.Arg(0) {
+	case ConsumeCommand:
+		sqsConsumer, err := consumer.NewConsumer(sqs.New(utils.NewAWSSession()), config.Consumer, deps)
+		if err != nil {
+			log.Fatalf("failed to create SQS consumer: %s", err)
+			return
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := sqsConsumer.Run(ctx); err != nil {
+				log.Printf("SQS consumer stopped: %s", err)
+			}
+		}()
+
+	default:
+		// I'm not really sure how lambda.Start() behaves, so I'm making the huge assumption that is doesn't block due to
+		// the lack of a Stop() or Close() like function exposed. If it DOES block, then I would move the function call
+		// into a go routine and pass the waitGroup and the context to the handler, so that I can shut down the process on
+		// a OS interrupt.
+		lambda.Start(func(ctx context.Context, raw json.RawMessage) (string, error) {
+			return HandleRequest(ctx, deps, raw)
+		})
+	}
 
 	// wait here until a TERM signal is received
 	signal.Notify(sigC, syscall.SIGINT, syscall.SIGTERM)
 	<-sigC
 	signal.Stop(sigC)
 
-	// shuts down the Event process queue
-	sqsQueueChannel <- true
+	// shuts down the Event process queue, and waits for it to drain whatever is still queued
+	cancel()
+	wg.Wait()
 
 	log.Println("Reschedular service has shutdown.")
 }